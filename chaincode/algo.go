@@ -14,10 +14,6 @@
 
 package main
 
-import (
-	"chaincode/errors"
-)
-
 // Set is a method of the receiver Algo. It uses inputAlgo fields to set the Algo
 // Returns the algoKey
 func (algo *Algo) Set(db LedgerDB, inp inputAlgo) (algoKey string, err error) {
@@ -50,12 +46,7 @@ func (algo *Algo) Set(db LedgerDB, inp inputAlgo) (algoKey string, err error) {
 // -------------------------------------------------------------------------------------------
 // registerAlgo stores a new algo in the ledger.
 // If the key exists, it will override the value with the new one
-func registerAlgo(db LedgerDB, args []string) (resp map[string]string, err error) {
-	inp := inputAlgo{}
-	err = AssetFromJSON(args, &inp)
-	if err != nil {
-		return
-	}
+func registerAlgo(db LedgerDB, inp inputAlgo) (resp map[string]string, err error) {
 	// check validity of input args and convert it to Algo
 	algo := Algo{}
 	algoKey, err := algo.Set(db, inp)
@@ -76,12 +67,7 @@ func registerAlgo(db LedgerDB, args []string) (resp map[string]string, err error
 }
 
 // queryAlgo returns an algo of the ledger given its key
-func queryAlgo(db LedgerDB, args []string) (out outputAlgo, err error) {
-	inp := inputHash{}
-	err = AssetFromJSON(args, &inp)
-	if err != nil {
-		return
-	}
+func queryAlgo(db LedgerDB, inp inputHash) (out outputAlgo, err error) {
 	algo, err := db.GetAlgo(inp.Key)
 	if err != nil {
 		return
@@ -90,13 +76,10 @@ func queryAlgo(db LedgerDB, args []string) (out outputAlgo, err error) {
 	return
 }
 
-// queryAlgos returns all algos of the ledger
-func queryAlgos(db LedgerDB, args []string) (outAlgos []outputAlgo, err error) {
+// queryAlgos returns all algos of the ledger, archived ones are only returned
+// if includeArchived is set
+func queryAlgos(db LedgerDB, inp inputQueryAll) (outAlgos []outputAlgo, err error) {
 	outAlgos = []outputAlgo{}
-	if len(args) != 0 {
-		err = errors.BadRequest("incorrect number of arguments, expecting nothing")
-		return
-	}
 	elementsKeys, err := db.GetIndexKeys("algo~owner~key", []string{"algo"})
 	if err != nil {
 		return
@@ -106,9 +89,30 @@ func queryAlgos(db LedgerDB, args []string) (outAlgos []outputAlgo, err error) {
 		if err != nil {
 			return outAlgos, err
 		}
+		if algo.Archived && !inp.IncludeArchived {
+			continue
+		}
 		var out outputAlgo
 		out.Fill(key, algo)
 		outAlgos = append(outAlgos, out)
 	}
 	return
 }
+
+// archiveAlgo archives an algo so that it can't be used in new tuples anymore.
+// Only the owner of the algo can archive it.
+func archiveAlgo(db LedgerDB, inp inputHash) (resp map[string]string, err error) {
+	algo, err := db.GetAlgo(inp.Key)
+	if err != nil {
+		return
+	}
+	if err = checkArchivable(db, "algo", inp.Key, algo.Owner, algo.Archived); err != nil {
+		return
+	}
+	algo.Archived = true
+	err = db.Put(inp.Key, algo)
+	if err != nil {
+		return
+	}
+	return map[string]string{"key": inp.Key}, nil
+}
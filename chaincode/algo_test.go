@@ -15,6 +15,7 @@
 package main
 
 import (
+	"chaincode/errors"
 	"encoding/json"
 	"testing"
 
@@ -32,6 +33,11 @@ func TestAlgo(t *testing.T) {
 	args := inpAlgo.createDefault()
 	resp := mockStub.MockInvoke("42", args)
 	assert.EqualValuesf(t, 400, resp.Status, "when adding algo with invalid hash, status %d and message %s", resp.Status, resp.Message)
+	respError := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(resp.Payload, &respError))
+	assert.EqualValues(t, errors.CodeInvalidRequest, respError["code"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"field": "descriptionHash", "rule": "len", "param": "64"}}, respError["fields"])
+	assert.NotEmpty(t, respError["causes"])
 
 	// Properly add algo
 	resp, tt := registerItem(t, *mockStub, "algo")
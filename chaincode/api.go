@@ -0,0 +1,226 @@
+// Copyright 2018 Owkin, inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"chaincode/errors"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// apiVersion is the version of the shape of the inputs and outputs of the
+// smart contracts. Callers choose it with the apiVersion field of the json
+// argument envelope, so that their payloads keep working when the shapes
+// change.
+type apiVersion int
+
+// Supported API versions
+const (
+	// apiVersion1 is used when the caller doesn't send an apiVersion
+	apiVersion1 apiVersion = 1
+	// apiVersion2 takes testOnly as a boolean and renders the key of the algo
+	// and objective of the tuples in algoKey and objectiveKey, as in the ledger
+	apiVersion2 apiVersion = 2
+
+	// latestAPIVersion is the version of the input structs
+	latestAPIVersion = apiVersion2
+)
+
+// inputUpgrades translate the inputs of a version to the next one, by input
+// struct. They are applied from the version of the caller up to the latest one.
+var inputUpgrades = map[apiVersion]map[reflect.Type]func(obj map[string]interface{}) error{
+	apiVersion1: {
+		reflect.TypeOf(inputDataSample{}):               upgradeTestOnly,
+		reflect.TypeOf(inputUpdateDataSampleTestOnly{}): upgradeTestOnly,
+	},
+}
+
+// outputUpgrades translate the outputs of the previous version to a version,
+// by output struct. The output structs have the shape of the version 1, which
+// is also the one of the events.
+var outputUpgrades = map[apiVersion]map[reflect.Type]func(obj map[string]interface{}){
+	apiVersion2: {
+		reflect.TypeOf(outputTraintuple{}): exposeAssetKeys,
+		reflect.TypeOf(outputTesttuple{}):  exposeAssetKeys,
+		reflect.TypeOf(outputBoardTuple{}): exposeAssetKeys,
+	},
+}
+
+// parseAPIVersion reads the apiVersion of the json argument envelope and
+// returns the args without it
+func parseAPIVersion(args []string) (apiVersion, []string, error) {
+	if len(args) != 1 || !strings.HasPrefix(strings.TrimSpace(args[0]), "{") {
+		return apiVersion1, args, nil
+	}
+	envelope := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(args[0]), &envelope); err != nil {
+		// the invalid json is reported when reading the input
+		return apiVersion1, args, nil
+	}
+	raw, ok := envelope["apiVersion"]
+	if !ok {
+		return apiVersion1, args, nil
+	}
+	var version apiVersion
+	if err := json.Unmarshal(raw, &version); err != nil || version < apiVersion1 || version > latestAPIVersion {
+		return 0, nil, errors.BadRequest(errors.CodeUnsupportedAPIVersion,
+			"unsupported apiVersion %s, expecting an integer from %d to %d", raw, apiVersion1, latestAPIVersion)
+	}
+	delete(envelope, "apiVersion")
+	if len(envelope) == 0 {
+		return version, []string{}, nil
+	}
+	arg, err := json.Marshal(envelope)
+	if err != nil {
+		return 0, nil, errors.Internal(err)
+	}
+	return version, []string{string(arg)}, nil
+}
+
+// upgradeInput translates the json input of a contract sent with an older
+// version to the latest one. The input is returned as is if it doesn't need
+// any translation.
+func upgradeInput(t reflect.Type, arg string, version apiVersion) (string, error) {
+	var data interface{}
+	if err := decodeJSON([]byte(arg), &data); err != nil {
+		// the invalid json is reported when reading the input
+		return arg, nil
+	}
+	upgraded := false
+	for ; version < latestAPIVersion; version++ {
+		upgrades := inputUpgrades[version]
+		err := walkJSON(t, data, func(t reflect.Type, obj map[string]interface{}) error {
+			upgrade, ok := upgrades[t]
+			if !ok {
+				return nil
+			}
+			upgraded = true
+			return upgrade(obj)
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	if !upgraded {
+		return arg, nil
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return "", errors.Internal(err)
+	}
+	return string(b), nil
+}
+
+// renderOutput returns the json representation of the output of a contract
+// in the shape of the requested version
+func renderOutput(output interface{}, version apiVersion) (interface{}, error) {
+	if version == apiVersion1 || output == nil {
+		return output, nil
+	}
+	b, err := json.Marshal(output)
+	if err != nil {
+		return nil, errors.Internal(err, "could not format response")
+	}
+	var data interface{}
+	if err := decodeJSON(b, &data); err != nil {
+		return nil, errors.Internal(err, "could not format response")
+	}
+	for v := apiVersion1 + 1; v <= version; v++ {
+		upgrades := outputUpgrades[v]
+		walkJSON(reflect.TypeOf(output), data, func(t reflect.Type, obj map[string]interface{}) error {
+			if upgrade, ok := upgrades[t]; ok {
+				upgrade(obj)
+			}
+			return nil
+		})
+	}
+	return data, nil
+}
+
+// decodeJSON decodes json keeping the numbers as they are written
+func decodeJSON(b []byte, data *interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	return decoder.Decode(data)
+}
+
+// walkJSON calls apply on each json object of the decoded json representation
+// of a value of type t which represents a struct, innermost objects first
+func walkJSON(t reflect.Type, data interface{}, apply func(t reflect.Type, obj map[string]interface{}) error) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		items, _ := data.([]interface{})
+		for _, item := range items {
+			if err := walkJSON(t.Elem(), item, apply); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		obj, _ := data.(map[string]interface{})
+		for _, value := range obj {
+			if err := walkJSON(t.Elem(), value, apply); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		obj, ok := data.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for _, field := range jsonFields(t) {
+			if value, ok := obj[field.name]; ok {
+				if err := walkJSON(field.Type, value, apply); err != nil {
+					return err
+				}
+			}
+		}
+		return apply(t, obj)
+	}
+	return nil
+}
+
+// upgradeTestOnly converts the testOnly string of the version 1 to a boolean
+func upgradeTestOnly(obj map[string]interface{}) error {
+	testOnly, ok := obj["testOnly"].(string)
+	if !ok {
+		// booleans are accepted as well since the version 2 was introduced,
+		// missing values are reported by the validation
+		return nil
+	}
+	if testOnly != "true" && testOnly != "false" {
+		return errors.BadRequest("inputs validation failed: testOnly should be true or false, got %s", testOnly).
+			WithFieldErrors([]errors.FieldError{{Field: "testOnly", Rule: "oneof", Param: "true false"}})
+	}
+	obj["testOnly"] = testOnly == "true"
+	return nil
+}
+
+// exposeAssetKeys moves the hash of the algo and objective of a tuple to its
+// algoKey and objectiveKey fields
+func exposeAssetKeys(obj map[string]interface{}) {
+	for _, name := range []string{"algo", "objective"} {
+		asset, ok := obj[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		obj[name+"Key"] = asset["hash"]
+		delete(asset, "hash")
+	}
+}
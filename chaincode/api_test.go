@@ -0,0 +1,126 @@
+// Copyright 2018 Owkin, inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"chaincode/errors"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAPIVersion(t *testing.T) {
+	version, args, err := parseAPIVersion([]string{`{"key": "abc"}`})
+	assert.NoError(t, err)
+	assert.Equal(t, apiVersion1, version)
+	assert.Equal(t, []string{`{"key": "abc"}`}, args)
+
+	version, args, err = parseAPIVersion([]string{`{"key": "abc", "apiVersion": 2}`})
+	assert.NoError(t, err)
+	assert.Equal(t, apiVersion2, version)
+	assert.Equal(t, []string{`{"key":"abc"}`}, args)
+
+	version, args, err = parseAPIVersion([]string{`{"apiVersion": 1}`})
+	assert.NoError(t, err)
+	assert.Equal(t, apiVersion1, version)
+	assert.Empty(t, args)
+
+	for _, invalid := range []string{`3`, `0`, `"2"`, `1.5`} {
+		_, _, err = parseAPIVersion([]string{fmt.Sprintf(`{"apiVersion": %s}`, invalid)})
+		assert.Error(t, err, invalid)
+		assert.Equal(t, errors.CodeUnsupportedAPIVersion, errors.Wrap(err).Code(), invalid)
+	}
+}
+
+func TestVersionedInputs(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	inpDataManager := inputDataManager{}
+	mockStub.MockInvoke("42", inpDataManager.createDefault())
+
+	cases := []struct {
+		hash    string
+		payload string
+		status  int32
+	}{
+		// version 1 is used when the apiVersion is omitted
+		{trainDataSampleHash1, `"testOnly": "false"`, 200},
+		{trainDataSampleHash2, `"testOnly": "false", "apiVersion": 1`, 200},
+		{testDataSampleHash1, `"testOnly": true, "apiVersion": 1`, 200},
+		{testDataSampleHash2, `"testOnly": true, "apiVersion": 2`, 200},
+		{testDataSampleHash2, `"testOnly": "maybe", "apiVersion": 1`, 400},
+		{testDataSampleHash2, `"testOnly": "true", "apiVersion": 2`, 400},
+		{testDataSampleHash2, `"testOnly": true, "apiVersion": 3`, 400},
+	}
+	for _, c := range cases {
+		arg := fmt.Sprintf(`{"hashes": [%q], "dataManagerKeys": [%q], %s}`, c.hash, dataManagerOpenerHash, c.payload)
+		resp := mockStub.MockInvoke("42", [][]byte{[]byte("registerDataSample"), []byte(arg)})
+		assert.EqualValuesf(t, c.status, resp.Status, "when registering dataSample with %s, message %s", c.payload, resp.Message)
+	}
+
+	db := NewLedgerDB(mockStub)
+	dataSample, err := db.GetDataSample(testDataSampleHash1)
+	require.NoError(t, err)
+	assert.True(t, dataSample.TestOnly)
+
+	// Contracts without input accept an envelope with only the apiVersion
+	resp := mockStub.MockInvoke("42", [][]byte{[]byte("queryNodes"), []byte(`{"apiVersion": 2}`)})
+	assert.EqualValues(t, 200, resp.Status, resp.Message)
+}
+
+func TestVersionedOutputs(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "traintuple")
+	inpTesttuple := inputTesttuple{}
+	resp := mockStub.MockInvoke("42", inpTesttuple.createDefault())
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+
+	arg := fmt.Sprintf(`{"key": %q}`, traintupleKey)
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("queryTraintuple"), []byte(arg)})
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	v1 := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &v1))
+	assert.NotContains(t, v1, "algoKey")
+	assert.Equal(t, algoHash, v1["algo"].(map[string]interface{})["hash"])
+
+	arg = fmt.Sprintf(`{"key": %q, "apiVersion": 2}`, traintupleKey)
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("queryTraintuple"), []byte(arg)})
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	v2 := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &v2))
+	assert.Equal(t, algoHash, v2["algoKey"])
+	assert.Equal(t, objectiveDescriptionHash, v2["objectiveKey"])
+	assert.NotContains(t, v2["algo"], "hash")
+	assert.NotContains(t, v2["objective"], "hash")
+	assert.Equal(t, v1["algo"].(map[string]interface{})["name"], v2["algo"].(map[string]interface{})["name"])
+
+	// The tuples nested in other outputs are rendered the same way
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("queryModelDetails"), []byte(arg)})
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	details := map[string]map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &details))
+	assert.Equal(t, algoHash, details["traintuple"]["algoKey"])
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("queryTesttuples"), []byte(`{"apiVersion": 2}`)})
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	testtuples := []map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &testtuples))
+	require.Len(t, testtuples, 1)
+	assert.Equal(t, algoHash, testtuples[0]["algoKey"])
+	assert.Equal(t, objectiveDescriptionHash, testtuples[0]["objectiveKey"])
+}
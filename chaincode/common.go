@@ -15,18 +15,13 @@
 package main
 
 import (
-	"fmt"
+	"chaincode/errors"
 	"strings"
 )
 
 // queryFilter returns all elements of the ledger matching some filters
 // For now, ok for everything. Later returns if the requester has permission to see it
-func queryFilter(db LedgerDB, args []string) (elements interface{}, err error) {
-	inp := inputQueryFilter{}
-	err = AssetFromJSON(args, &inp)
-	if err != nil {
-		return
-	}
+func queryFilter(db LedgerDB, inp inputQueryFilter) (elements interface{}, err error) {
 	// check validity of inputs
 	validIndexNames := []string{
 		"traintuple~worker~status",
@@ -34,7 +29,7 @@ func queryFilter(db LedgerDB, args []string) (elements interface{}, err error) {
 		"testtuple~tag",
 		"traintuple~tag"}
 	if !stringInSlice(inp.IndexName, validIndexNames) {
-		err = fmt.Errorf("invalid indexName filter query: %s", inp.IndexName)
+		err = errors.BadRequest("invalid indexName filter query: %s", inp.IndexName)
 		return
 	}
 	indexName := inp.IndexName + "~key"
@@ -54,3 +49,27 @@ func queryFilter(db LedgerDB, args []string) (elements interface{}, err error) {
 	}
 	return
 }
+
+// checkAssetOwner checks that the transaction requester is the owner of an asset
+func checkAssetOwner(db LedgerDB, assetName, key, owner string) error {
+	txCreator, err := GetTxCreator(db.cc)
+	if err != nil {
+		return err
+	}
+	if txCreator != owner {
+		return errors.Forbidden("%s is not the owner of the %s %s", txCreator, assetName, key)
+	}
+	return nil
+}
+
+// checkArchivable checks that an asset can be archived by the transaction
+// requester: it must be its owner and the asset must not be archived yet
+func checkArchivable(db LedgerDB, assetName, key, owner string, archived bool) error {
+	if err := checkAssetOwner(db, assetName, key, owner); err != nil {
+		return err
+	}
+	if archived {
+		return errors.BadRequest(errors.CodeArchivedAsset, "%s %s is already archived", assetName, key)
+	}
+	return nil
+}
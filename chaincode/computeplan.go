@@ -0,0 +1,449 @@
+// Copyright 2018 Owkin, inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"chaincode/errors"
+	stderrors "errors"
+	"strconv"
+)
+
+// -------------------------------------------------------------------------------------------
+// Methods on receivers compute plan
+// -------------------------------------------------------------------------------------------
+
+// AddTuples creates the traintuples and testtuples of a compute plan and
+// keeps track of their keys. The traintuples' InModelsIDs and the testtuples'
+// TraintupleID can refer to the IDs of the traintuples already part of the
+// compute plan as well as the new ones.
+// If computePlanID is empty, the key of the first traintuple is used as the
+// compute plan ID. The compute plan ID is returned.
+// Traintuples and testtuples ready to be processed are added to the event.
+// All the invalid tuples are reported at once, the tuples depending on an
+// invalid traintuple are skipped.
+func (computePlan *ComputePlan) AddTuples(db LedgerDB, computePlanID string, traintuples []inputComputePlanTraintuple, testtuples []inputComputePlanTesttuple, event *TuplesEvent) (string, error) {
+	traintuples, err := computePlan.sortTraintuples(db, computePlanID, traintuples)
+	if err != nil {
+		return computePlanID, err
+	}
+	errs := errors.MultiError{}
+	failedIDs := map[string]bool{}
+	for _, computeTraintuple := range traintuples {
+		if hasFailedParent(computeTraintuple.InModelsIDs, failedIDs) {
+			failedIDs[computeTraintuple.ID] = true
+			continue
+		}
+		computePlanID, err = computePlan.addTraintuple(db, computePlanID, computeTraintuple, event)
+		if err != nil {
+			errs.Add("traintuple "+computeTraintuple.ID, err)
+			failedIDs[computeTraintuple.ID] = true
+		}
+	}
+
+	for index, computeTesttuple := range testtuples {
+		if failedIDs[computeTesttuple.TraintupleID] {
+			continue
+		}
+		err = computePlan.addTesttuple(db, computePlanID, computeTesttuple, event)
+		if err != nil {
+			errs.Add("testtuple "+strconv.Itoa(index), err)
+		}
+	}
+	return computePlanID, errs.ErrorOrNil()
+}
+
+// addTraintuple creates a traintuple of the compute plan, see AddTuples
+func (computePlan *ComputePlan) addTraintuple(db LedgerDB, computePlanID string, computeTraintuple inputComputePlanTraintuple, event *TuplesEvent) (string, error) {
+	inpTraintuple := inputTraintuple{}
+	inpTraintuple.AlgoKey = computePlan.AlgoKey
+	inpTraintuple.ObjectiveKey = computePlan.ObjectiveKey
+	inpTraintuple.DataManagerKey = computeTraintuple.DataManagerKey
+	inpTraintuple.DataSampleKeys = computeTraintuple.DataSampleKeys
+	inpTraintuple.Tag = computeTraintuple.Tag
+	inpTraintuple.Priority = computeTraintuple.Priority
+
+	traintuple := Traintuple{}
+	err := traintuple.SetFromInput(db, inpTraintuple)
+	if err != nil {
+		return computePlanID, err
+	}
+
+	// Set the inModels by matching the id to traintuples key previously
+	// encontered in this compute plan, they are sorted so that the parents
+	// are always created before their children
+	inModelKeys := []string{}
+	for _, InModelID := range computeTraintuple.InModelsIDs {
+		inModelKey, _, err := computePlan.getTraintupleKey(db, computePlanID, InModelID)
+		if err != nil {
+			return computePlanID, err
+		}
+		inModelKeys = append(inModelKeys, inModelKey)
+	}
+
+	// Set the status depending on the parents: if one of them is not
+	// done yet it's waiting, if not it's todo
+	err = traintuple.SetFromParents(db, inModelKeys)
+	if err != nil {
+		return computePlanID, err
+	}
+	traintuple.Rank, err = getComputePlanRank(db, inModelKeys)
+	if err != nil {
+		return computePlanID, err
+	}
+
+	traintupleKey := traintuple.GetKey()
+
+	// Set the ComputePlanID
+	if computePlanID == "" {
+		computePlanID = traintupleKey
+	}
+	traintuple.ComputePlanID = computePlanID
+
+	err = traintuple.Save(db, traintupleKey)
+	if err != nil {
+		return computePlanID, err
+	}
+	err = db.CreateIndex("traintuple~computeplanid~id~key", []string{"traintuple", computePlanID, computeTraintuple.ID, traintupleKey})
+	if err != nil {
+		return computePlanID, err
+	}
+	if traintuple.Status == StatusTodo {
+		out := outputTraintuple{}
+		err = out.Fill(db, traintuple, traintupleKey)
+		if err != nil {
+			return computePlanID, err
+		}
+		event.AddTraintuple(out)
+	}
+	computePlan.TraintupleKeysByID[computeTraintuple.ID] = traintupleKey
+	computePlan.TraintupleKeys = append(computePlan.TraintupleKeys, traintupleKey)
+	return computePlanID, nil
+}
+
+// addTesttuple creates a testtuple of the compute plan, see AddTuples
+func (computePlan *ComputePlan) addTesttuple(db LedgerDB, computePlanID string, computeTesttuple inputComputePlanTesttuple, event *TuplesEvent) error {
+	traintupleKey, ok, err := computePlan.getTraintupleKey(db, computePlanID, computeTesttuple.TraintupleID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.BadRequest("traintuple ID %s not found", computeTesttuple.TraintupleID)
+	}
+	testtuple := Testtuple{}
+	err = testtuple.SetFromTraintuple(db, traintupleKey)
+	if err != nil {
+		return err
+	}
+
+	inputTesttuple := inputTesttuple{}
+	inputTesttuple.DataManagerKey = computeTesttuple.DataManagerKey
+	inputTesttuple.DataSampleKeys = computeTesttuple.DataSampleKeys
+	inputTesttuple.Tag = computeTesttuple.Tag
+	inputTesttuple.Priority = computeTesttuple.Priority
+	err = testtuple.SetFromInput(db, inputTesttuple)
+	if err != nil {
+		return err
+	}
+	testtupleKey := testtuple.GetKey()
+	err = testtuple.Save(db, testtupleKey)
+	if err != nil {
+		return err
+	}
+	if testtuple.Status == StatusTodo {
+		out := outputTesttuple{}
+		err = out.Fill(db, testtupleKey, testtuple)
+		if err != nil {
+			return err
+		}
+		event.AddTesttuple(out)
+	}
+	computePlan.TesttupleKeys = append(computePlan.TesttupleKeys, testtupleKey)
+	return nil
+}
+
+// sortTraintuples checks the dependencies between the traintuples to add to the
+// compute plan and returns them in an order in which each traintuple comes after
+// all its parents. It fails if an ID is duplicated, if a parent can be found neither
+// in the new traintuples nor in the compute plan, or if there is a cycle. All the
+// invalid IDs are reported at once.
+func (computePlan *ComputePlan) sortTraintuples(db LedgerDB, computePlanID string, traintuples []inputComputePlanTraintuple) ([]inputComputePlanTraintuple, error) {
+	errs := errors.MultiError{}
+	traintuplesByID := map[string]inputComputePlanTraintuple{}
+	for _, computeTraintuple := range traintuples {
+		_, ok, err := computePlan.getTraintupleKey(db, computePlanID, computeTraintuple.ID)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			errs.Add("traintuple "+computeTraintuple.ID, errors.BadRequest("traintuple ID %s already exists in the compute plan", computeTraintuple.ID))
+			continue
+		}
+		if _, ok := traintuplesByID[computeTraintuple.ID]; ok {
+			errs.Add("traintuple "+computeTraintuple.ID, errors.BadRequest("traintuple ID %s is used more than once", computeTraintuple.ID))
+			continue
+		}
+		traintuplesByID[computeTraintuple.ID] = computeTraintuple
+	}
+
+	// count for each traintuple its parents which are not created yet
+	// and list the children of each of them
+	pendingParents := map[string]int{}
+	childrenIDs := map[string][]string{}
+	for _, computeTraintuple := range traintuples {
+		for _, InModelID := range computeTraintuple.InModelsIDs {
+			if _, ok := traintuplesByID[InModelID]; ok {
+				pendingParents[computeTraintuple.ID]++
+				childrenIDs[InModelID] = append(childrenIDs[InModelID], computeTraintuple.ID)
+				continue
+			}
+			_, ok, err := computePlan.getTraintupleKey(db, computePlanID, InModelID)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				errs.Add("traintuple "+computeTraintuple.ID, errors.BadRequest("traintuple ID %s: model ID %s not found", computeTraintuple.ID, InModelID))
+			}
+		}
+	}
+	// the graph can't be sorted if the IDs are not valid
+	if err := errs.ErrorOrNil(); err != nil {
+		return nil, err
+	}
+
+	// Kahn's algorithm, starting from the traintuples in the input order
+	// to keep the result deterministic
+	sorted := []inputComputePlanTraintuple{}
+	readyIDs := []string{}
+	for _, computeTraintuple := range traintuples {
+		if pendingParents[computeTraintuple.ID] == 0 {
+			readyIDs = append(readyIDs, computeTraintuple.ID)
+		}
+	}
+	for len(readyIDs) > 0 {
+		ID := readyIDs[0]
+		readyIDs = readyIDs[1:]
+		sorted = append(sorted, traintuplesByID[ID])
+		for _, childID := range childrenIDs[ID] {
+			pendingParents[childID]--
+			if pendingParents[childID] == 0 {
+				readyIDs = append(readyIDs, childID)
+			}
+		}
+	}
+
+	if len(sorted) != len(traintuples) {
+		cycleIDs := []string{}
+		for _, computeTraintuple := range traintuples {
+			if pendingParents[computeTraintuple.ID] > 0 {
+				cycleIDs = append(cycleIDs, computeTraintuple.ID)
+			}
+		}
+		return nil, errors.BadRequest("cycle detected in the compute plan between the traintuple IDs %s", cycleIDs)
+	}
+	return sorted, nil
+}
+
+// -------------------------------------------------------------------------------------------
+// Smart contracts related to compute plans
+// -------------------------------------------------------------------------------------------
+
+// createComputePlan is the wrapper for the substra smartcontract CreateComputePlan
+func createComputePlan(db LedgerDB, inp inputComputePlan) (resp outputComputePlan, err error) {
+	creator, err := GetTxCreator(db.cc)
+	if err != nil {
+		return
+	}
+	computePlan := ComputePlan{
+		AssetType:          ComputePlanType,
+		AlgoKey:            inp.AlgoKey,
+		Creator:            creator,
+		ObjectiveKey:       inp.ObjectiveKey,
+		TraintupleKeys:     []string{},
+		TraintupleKeysByID: map[string]string{},
+		TesttupleKeys:      []string{},
+		MaxDuration:        inp.MaxDuration,
+	}
+	event := TuplesEvent{}
+	computePlanID, err := computePlan.AddTuples(db, "", inp.Traintuples, inp.Testtuples, &event)
+	if err != nil {
+		return
+	}
+	err = db.Add(getComputePlanKey(computePlanID), computePlan)
+	if err != nil {
+		return
+	}
+
+	err = SendTuplesEvent(db.cc, event)
+	if err != nil {
+		return
+	}
+
+	resp.Fill(computePlanID, computePlan)
+	return
+}
+
+// updateComputePlan appends new traintuples and testtuples to an existing
+// compute plan. Only the creator of the compute plan can call it.
+func updateComputePlan(db LedgerDB, inp inputUpdateComputePlan) (resp outputComputePlan, err error) {
+	if len(inp.Traintuples) == 0 && len(inp.Testtuples) == 0 {
+		err = errors.BadRequest("invalid inputs, at least one traintuple or testtuple should be provided")
+		return
+	}
+	computePlan, err := getComputePlan(db, inp.ComputePlanID)
+	if err != nil {
+		return
+	}
+	if err = checkAssetOwner(db, "compute plan", inp.ComputePlanID, computePlan.Creator); err != nil {
+		return
+	}
+	event := TuplesEvent{}
+	_, err = computePlan.AddTuples(db, inp.ComputePlanID, inp.Traintuples, inp.Testtuples, &event)
+	if err != nil {
+		return
+	}
+
+	err = SendTuplesEvent(db.cc, event)
+	if err != nil {
+		return
+	}
+
+	resp.Fill(inp.ComputePlanID, computePlan)
+	return
+}
+
+// -------------------------------------------------------------------------------------------
+// Utils for compute plans
+// -------------------------------------------------------------------------------------------
+
+// getComputePlanKey returns the key under which a compute plan is stored.
+// The compute plan ID is the key of its first traintuple so it can't be used as is.
+func getComputePlanKey(computePlanID string) string {
+	return HashForKey("computePlan", computePlanID)
+}
+
+// getComputePlan returns a compute plan given its ID, with the keys of its
+// traintuples and testtuples read from their indexes. The compute plans started
+// with createTraintuple before they were stored in the ledger are rebuilt from
+// their first traintuple, whose key is the compute plan ID.
+func getComputePlan(db LedgerDB, computePlanID string) (ComputePlan, error) {
+	computePlan, err := getComputePlanRecord(db, computePlanID)
+	if err != nil {
+		return computePlan, err
+	}
+	computePlan.TraintupleKeys, err = db.GetIndexKeys("traintuple~computeplanid~worker~rank~key", []string{"traintuple", computePlanID})
+	if err != nil {
+		return computePlan, err
+	}
+	computePlan.TraintupleKeysByID = map[string]string{}
+	computePlan.TesttupleKeys = []string{}
+	for _, traintupleKey := range computePlan.TraintupleKeys {
+		testtupleKeys, err := db.GetIndexKeys("testtuple~traintuple~certified~key", []string{"testtuple", traintupleKey})
+		if err != nil {
+			return computePlan, err
+		}
+		computePlan.TesttupleKeys = append(computePlan.TesttupleKeys, testtupleKeys...)
+	}
+	return computePlan, nil
+}
+
+// getComputePlanRecord returns the record of a compute plan given its ID,
+// without the keys of its tuples, see getComputePlan
+func getComputePlanRecord(db LedgerDB, computePlanID string) (ComputePlan, error) {
+	computePlan, err := db.GetComputePlan(computePlanID)
+	if !stderrors.Is(err, errors.NotFound()) {
+		return computePlan, err
+	}
+	firstTraintuple, err := db.GetTraintuple(computePlanID)
+	if err != nil || firstTraintuple.ComputePlanID != computePlanID {
+		return computePlan, errors.NotFound(errors.CodeAssetNotFound, "could not retrieve compute plan %s", computePlanID)
+	}
+	computePlan = ComputePlan{
+		AssetType:    ComputePlanType,
+		AlgoKey:      firstTraintuple.AlgoKey,
+		Creator:      firstTraintuple.Creator,
+		ObjectiveKey: firstTraintuple.ObjectiveKey,
+	}
+	return computePlan, nil
+}
+
+// getTraintupleKey returns the key of a traintuple of the compute plan given
+// the ID it was created with. The traintuples created with createTraintuple
+// have no ID, they are referred to by their key. It returns false if there is
+// no such traintuple.
+func (computePlan *ComputePlan) getTraintupleKey(db LedgerDB, computePlanID, ID string) (string, bool, error) {
+	if traintupleKey, ok := computePlan.TraintupleKeysByID[ID]; ok {
+		return traintupleKey, true, nil
+	}
+	if computePlanID == "" {
+		return "", false, nil
+	}
+	traintupleKeys, err := db.GetIndexKeys("traintuple~computeplanid~id~key", []string{"traintuple", computePlanID, ID})
+	if err != nil {
+		return "", false, err
+	}
+	if len(traintupleKeys) > 0 {
+		return traintupleKeys[0], true, nil
+	}
+	traintuple, err := db.GetTraintuple(ID)
+	if stderrors.Is(err, errors.NotFound()) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return ID, traintuple.ComputePlanID == computePlanID, nil
+}
+
+// startComputePlan stores the compute plan started by a traintuple created
+// with createTraintuple. The traintuples added to it afterwards are only
+// found with their index, so that adding them doesn't rewrite the record.
+func (traintuple *Traintuple) startComputePlan(db LedgerDB, traintupleKey string) error {
+	computePlan := ComputePlan{
+		AssetType:    ComputePlanType,
+		AlgoKey:      traintuple.AlgoKey,
+		Creator:      traintuple.Creator,
+		ObjectiveKey: traintuple.ObjectiveKey,
+	}
+	return db.Add(getComputePlanKey(traintupleKey), computePlan)
+}
+
+// getComputePlanRank returns the rank of a traintuple in a compute plan given
+// its parents: its depth in the graph of the traintuples, 0 being the rank of
+// the traintuples without parent. Independent traintuples of a worker can
+// share a rank.
+func getComputePlanRank(db LedgerDB, inModelKeys []string) (int, error) {
+	rank := 0
+	for _, inModelKey := range inModelKeys {
+		parentTraintuple, err := db.GetTraintuple(inModelKey)
+		if err != nil {
+			return 0, err
+		}
+		if parentTraintuple.Rank+1 > rank {
+			rank = parentTraintuple.Rank + 1
+		}
+	}
+	return rank, nil
+}
+
+// hasFailedParent checks if one of the parents of a traintuple of a compute
+// plan could not be created
+func hasFailedParent(inModelsIDs []string, failedIDs map[string]bool) bool {
+	for _, inModelID := range inModelsIDs {
+		if failedIDs[inModelID] {
+			return true
+		}
+	}
+	return false
+}
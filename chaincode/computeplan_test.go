@@ -0,0 +1,522 @@
+// Copyright 2018 Owkin, inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"chaincode/errors"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// myMockStub is here to simulate the fact that in real condition you cannot read
+// what you just write. It should be improved and more generally used.
+type myMockStub struct {
+	saveWhenWriting bool
+	writtenState    map[string][]byte
+	*MockStub
+}
+
+func (stub *myMockStub) PutState(key string, value []byte) error {
+	if !stub.saveWhenWriting {
+		if stub.writtenState == nil {
+			stub.writtenState = make(map[string][]byte)
+		}
+		stub.writtenState[key] = value
+		return nil
+	}
+	return stub.PutState(key, value)
+}
+
+func (stub *myMockStub) saveWrittenState(t *testing.T) {
+	if stub.writtenState == nil {
+		return
+	}
+	for k, v := range stub.writtenState {
+		err := stub.MockStub.PutState(k, v)
+		if err != nil {
+			t.Fatalf("unable to `PutState` in saveWrittenState %s", err)
+		}
+	}
+	stub.writtenState = nil
+	return
+}
+
+func TestCreateComputePlan(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	myStub := myMockStub{MockStub: mockStub}
+	myStub.saveWhenWriting = true
+	registerItem(t, *mockStub, "algo")
+	myStub.MockTransactionStart("42")
+	myStub.saveWhenWriting = false
+
+	// Simply test method and return values
+	inCP := defaultComputePlan
+	outCP, err := createComputePlan(NewLedgerDB(&myStub), inCP)
+	assert.NoError(t, err)
+	assert.NotNil(t, outCP)
+	assert.EqualValues(t, outCP.ComputePlanID, outCP.TraintupleKeys[0])
+
+	// Save all that was written in the mocked ledger
+	myStub.saveWrittenState(t)
+
+	// Check the traintuples
+	traintuples, err := queryTraintuples(NewLedgerDB(&myStub))
+	assert.NoError(t, err)
+	assert.Len(t, traintuples, 2)
+	require.Contains(t, outCP.TraintupleKeys, traintuples[0].Key)
+	require.Contains(t, outCP.TraintupleKeys, traintuples[1].Key)
+	var first, second outputTraintuple
+	for _, el := range traintuples {
+		switch el.Key {
+		case outCP.TraintupleKeys[0]:
+			first = el
+		case outCP.TraintupleKeys[1]:
+			second = el
+		}
+	}
+	assert.NotZero(t, first)
+	assert.NotZero(t, second)
+	assert.EqualValues(t, first.Key, first.ComputePlanID)
+	assert.EqualValues(t, first.ComputePlanID, second.ComputePlanID)
+	assert.Len(t, second.InModels, 1)
+	assert.EqualValues(t, first.Key, second.InModels[0].TraintupleKey)
+	assert.Equal(t, first.Status, StatusTodo)
+	assert.Equal(t, second.Status, StatusWaiting)
+
+	// Check the testtuples
+	testtuples, err := queryTesttuples(NewLedgerDB(&myStub))
+	assert.NoError(t, err)
+	require.Len(t, testtuples, 1)
+	testtuple := testtuples[0]
+	require.Contains(t, outCP.TesttupleKeys, testtuple.Key)
+	assert.EqualValues(t, second.Key, testtuple.Model.TraintupleKey)
+	assert.True(t, testtuple.Certified)
+}
+
+func TestUpdateComputePlan(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "algo")
+
+	resp := mockStub.MockInvoke("42", methodAndAssetToByte("createComputePlan", defaultComputePlan))
+	require.EqualValuesf(t, 200, resp.Status, "when creating compute plan with status %d and message %s", resp.Status, resp.Message)
+	outCP := outputComputePlan{}
+	err := json.Unmarshal(resp.Payload, &outCP)
+	assert.NoError(t, err, "should unmarshal without problem")
+
+	// Train the first traintuple so that its children can be processed right away
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("logStartTrain"), keyToJSON(outCP.TraintupleKeys[0])})
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	success := inputLogSuccessTrain{}
+	success.Key = outCP.TraintupleKeys[0]
+	resp = mockStub.MockInvoke("42", success.createDefault())
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+
+	// Append a traintuple depending on an existing one and another one
+	// depending on the new one, with its testtuple
+	inpUpdate := inputUpdateComputePlan{
+		ComputePlanID: outCP.ComputePlanID,
+		Traintuples: []inputComputePlanTraintuple{
+			{
+				DataManagerKey: dataManagerOpenerHash,
+				DataSampleKeys: []string{trainDataSampleHash1},
+				ID:             "thirdTraintupleID",
+				InModelsIDs:    []string{traintupleID1},
+			},
+			{
+				DataManagerKey: dataManagerOpenerHash,
+				DataSampleKeys: []string{trainDataSampleHash2},
+				ID:             "fourthTraintupleID",
+				InModelsIDs:    []string{"thirdTraintupleID", traintupleID2},
+			},
+		},
+		Testtuples: []inputComputePlanTesttuple{
+			{
+				DataManagerKey: dataManagerOpenerHash,
+				DataSampleKeys: []string{testDataSampleHash1, testDataSampleHash2},
+				TraintupleID:   "fourthTraintupleID",
+			},
+		},
+	}
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("updateComputePlan", inpUpdate))
+	require.EqualValuesf(t, 200, resp.Status, "when updating compute plan with status %d and message %s", resp.Status, resp.Message)
+	outUpdate := outputComputePlan{}
+	err = json.Unmarshal(resp.Payload, &outUpdate)
+	assert.NoError(t, err, "should unmarshal without problem")
+	assert.Equal(t, outCP.ComputePlanID, outUpdate.ComputePlanID)
+	require.Len(t, outUpdate.TraintupleKeys, 4)
+	assert.Equal(t, outCP.TraintupleKeys, outUpdate.TraintupleKeys[:2])
+	require.Len(t, outUpdate.TesttupleKeys, 2)
+
+	db := NewLedgerDB(mockStub)
+	third, err := db.GetTraintuple(outUpdate.TraintupleKeys[2])
+	assert.NoError(t, err)
+	assert.Equal(t, StatusTodo, third.Status)
+	assert.Equal(t, outCP.ComputePlanID, third.ComputePlanID)
+	assert.Equal(t, []string{outCP.TraintupleKeys[0]}, third.InModelKeys)
+	fourth, err := db.GetTraintuple(outUpdate.TraintupleKeys[3])
+	assert.NoError(t, err)
+	assert.Equal(t, StatusWaiting, fourth.Status)
+	assert.ElementsMatch(t, []string{outUpdate.TraintupleKeys[2], outCP.TraintupleKeys[1]}, fourth.InModelKeys)
+	testtuple, err := db.GetTesttuple(outUpdate.TesttupleKeys[1])
+	assert.NoError(t, err)
+	assert.Equal(t, StatusWaiting, testtuple.Status)
+	assert.Equal(t, outUpdate.TraintupleKeys[3], testtuple.Model.TraintupleKey)
+
+	// IDs already used in the compute plan can't be reused
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("updateComputePlan", inpUpdate))
+	assert.EqualValues(t, 400, resp.Status, resp.Message)
+	assert.Contains(t, resp.Message, "already exists in the compute plan")
+
+	// Unknown IDs are rejected
+	inpUpdate.Traintuples = []inputComputePlanTraintuple{
+		{
+			DataManagerKey: dataManagerOpenerHash,
+			DataSampleKeys: []string{trainDataSampleHash2},
+			ID:             "fifthTraintupleID",
+			InModelsIDs:    []string{"unknownTraintupleID"},
+		},
+	}
+	inpUpdate.Testtuples = nil
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("updateComputePlan", inpUpdate))
+	assert.EqualValues(t, 400, resp.Status, resp.Message)
+
+	// Unknown compute plans are rejected
+	inpUpdate.ComputePlanID = modelHash
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("updateComputePlan", inpUpdate))
+	assert.EqualValues(t, 404, resp.Status, resp.Message)
+}
+
+func TestCreateComputePlanUnordered(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "algo")
+	mockStub.MockTransactionStart("42")
+	db := NewLedgerDB(mockStub)
+
+	// The children are listed before their parents
+	inCP := inputComputePlan{
+		AlgoKey:      algoHash,
+		ObjectiveKey: objectiveDescriptionHash,
+		Traintuples: []inputComputePlanTraintuple{
+			{
+				DataManagerKey: dataManagerOpenerHash,
+				DataSampleKeys: []string{trainDataSampleHash1},
+				ID:             "grandChild",
+				InModelsIDs:    []string{"child", "parent"},
+			},
+			{
+				DataManagerKey: dataManagerOpenerHash,
+				DataSampleKeys: []string{trainDataSampleHash2},
+				ID:             "child",
+				InModelsIDs:    []string{"parent"},
+			},
+			{
+				DataManagerKey: dataManagerOpenerHash,
+				DataSampleKeys: []string{trainDataSampleHash1},
+				ID:             "parent",
+			},
+		},
+	}
+	outCP, err := createComputePlan(db, inCP)
+	require.NoError(t, err)
+	require.Len(t, outCP.TraintupleKeys, 3)
+	assert.Equal(t, outCP.ComputePlanID, outCP.TraintupleKeys[0])
+
+	for i, expected := range []struct {
+		status string
+		rank   int
+	}{
+		{StatusTodo, 0},
+		{StatusWaiting, 1},
+		{StatusWaiting, 2},
+	} {
+		traintuple, err := db.GetTraintuple(outCP.TraintupleKeys[i])
+		assert.NoError(t, err)
+		assert.Equal(t, expected.status, traintuple.Status)
+		assert.Equal(t, expected.rank, traintuple.Rank)
+	}
+}
+
+func TestCreateComputePlanInvalidGraph(t *testing.T) {
+	testTable := []struct {
+		name        string
+		traintuples []inputComputePlanTraintuple
+		message     string
+	}{
+		{
+			name: "missing ID",
+			traintuples: []inputComputePlanTraintuple{
+				{ID: "one", InModelsIDs: []string{"two"}},
+			},
+			message: "model ID two not found",
+		},
+		{
+			name: "duplicated ID",
+			traintuples: []inputComputePlanTraintuple{
+				{ID: "one"},
+				{ID: "one"},
+			},
+			message: "traintuple ID one is used more than once",
+		},
+		{
+			name: "several errors",
+			traintuples: []inputComputePlanTraintuple{
+				{ID: "one", InModelsIDs: []string{"two"}},
+				{ID: "three"},
+				{ID: "three"},
+			},
+			message: "2 invalid items: traintuple three: traintuple ID three is used more than once; traintuple one: traintuple ID one: model ID two not found",
+		},
+		{
+			name: "self reference",
+			traintuples: []inputComputePlanTraintuple{
+				{ID: "one", InModelsIDs: []string{"one"}},
+			},
+			message: "cycle detected",
+		},
+		{
+			name: "cycle",
+			traintuples: []inputComputePlanTraintuple{
+				{ID: "root"},
+				{ID: "one", InModelsIDs: []string{"root", "three"}},
+				{ID: "two", InModelsIDs: []string{"one"}},
+				{ID: "three", InModelsIDs: []string{"two"}},
+			},
+			message: "cycle detected in the compute plan between the traintuple IDs [one two three]",
+		},
+	}
+	for _, test := range testTable {
+		t.Run(test.name, func(t *testing.T) {
+			scc := new(SubstraChaincode)
+			mockStub := NewMockStubWithRegisterNode("substra", scc)
+			registerItem(t, *mockStub, "algo")
+
+			inCP := inputComputePlan{
+				AlgoKey:      algoHash,
+				ObjectiveKey: objectiveDescriptionHash,
+			}
+			for _, traintuple := range test.traintuples {
+				traintuple.DataManagerKey = dataManagerOpenerHash
+				traintuple.DataSampleKeys = []string{trainDataSampleHash1}
+				inCP.Traintuples = append(inCP.Traintuples, traintuple)
+			}
+			resp := mockStub.MockInvoke("42", methodAndAssetToByte("createComputePlan", inCP))
+			assert.EqualValues(t, 400, resp.Status, resp.Message)
+			assert.Contains(t, resp.Message, test.message)
+		})
+	}
+}
+
+func TestCreateComputePlanInvalidTuples(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "algo")
+
+	inCP := inputComputePlan{
+		AlgoKey:      algoHash,
+		ObjectiveKey: objectiveDescriptionHash,
+		Traintuples: []inputComputePlanTraintuple{
+			// trained on test only data
+			{ID: "one", DataManagerKey: dataManagerOpenerHash, DataSampleKeys: []string{testDataSampleHash1}},
+			// skipped since its parent is invalid
+			{ID: "two", DataManagerKey: dataManagerOpenerHash, DataSampleKeys: []string{trainDataSampleHash1}, InModelsIDs: []string{"one"}},
+			{ID: "three", DataManagerKey: dataManagerOpenerHash, DataSampleKeys: []string{trainDataSampleHash1}},
+		},
+		Testtuples: []inputComputePlanTesttuple{
+			{TraintupleID: "two", DataManagerKey: dataManagerOpenerHash, DataSampleKeys: []string{testDataSampleHash1}},
+			{TraintupleID: "unknown", DataManagerKey: dataManagerOpenerHash, DataSampleKeys: []string{testDataSampleHash1}},
+			{TraintupleID: "three", DataManagerKey: dataManagerOpenerHash, DataSampleKeys: []string{testDataSampleHash1}},
+		},
+	}
+	resp := mockStub.MockInvoke("42", methodAndAssetToByte("createComputePlan", inCP))
+	assert.EqualValues(t, 400, resp.Status, resp.Message)
+	respError := struct {
+		Errors []errors.ItemError `json:"errors"`
+	}{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &respError))
+	require.Len(t, respError.Errors, 2)
+	assert.Equal(t, "traintuple one", respError.Errors[0].Item)
+	assert.Equal(t, errors.CodeTestOnlyData, respError.Errors[0].Code)
+	assert.Equal(t, "testtuple 1", respError.Errors[1].Item)
+	assert.Contains(t, respError.Errors[1].Error, "traintuple ID unknown not found")
+}
+
+func TestComputePlanFromTraintuples(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "algo")
+
+	// Start a compute plan with createTraintuple and add a traintuple to it
+	inpTraintuple := inputTraintuple{Rank: "0"}
+	resp := mockStub.MockInvoke("42", inpTraintuple.createDefault())
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	res := map[string]string{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &res))
+	computePlanID := res["key"]
+	inpTraintuple = inputTraintuple{InModels: []string{computePlanID}, Rank: "1", ComputePlanID: computePlanID}
+	resp = mockStub.MockInvoke("42", inpTraintuple.createDefault())
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	require.NoError(t, json.Unmarshal(resp.Payload, &res))
+	secondKey := res["key"]
+
+	inpTesttuple := inputTesttuple{TraintupleKey: secondKey}
+	resp = mockStub.MockInvoke("42", inpTesttuple.createDefault())
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	require.NoError(t, json.Unmarshal(resp.Payload, &res))
+	testtupleKey := res["key"]
+
+	db := NewLedgerDB(mockStub)
+	computePlan, err := getComputePlan(db, computePlanID)
+	require.NoError(t, err)
+	assert.Equal(t, worker, computePlan.Creator)
+	assert.Equal(t, []string{computePlanID, secondKey}, computePlan.TraintupleKeys)
+	assert.Equal(t, []string{testtupleKey}, computePlan.TesttupleKeys)
+
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("queryStats", inputQueryStats{ComputePlanID: computePlanID}))
+	assert.EqualValues(t, 200, resp.Status, resp.Message)
+
+	// The traintuples created with createTraintuple are referred to by their key
+	inpUpdate := inputUpdateComputePlan{
+		ComputePlanID: computePlanID,
+		Traintuples: []inputComputePlanTraintuple{{
+			DataManagerKey: dataManagerOpenerHash,
+			DataSampleKeys: []string{trainDataSampleHash1},
+			ID:             "thirdTraintupleID",
+			InModelsIDs:    []string{secondKey},
+		}},
+	}
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("updateComputePlan", inpUpdate))
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	out := outputComputePlan{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &out))
+	assert.Len(t, out.TraintupleKeys, 3)
+	assert.Equal(t, []string{testtupleKey}, out.TesttupleKeys)
+	thirdKey := out.TraintupleKeys[2]
+
+	// The compute plans started before they were stored are rebuilt from
+	// their traintuples, which keep their IDs
+	mockStub.MockTransactionStart("43")
+	require.NoError(t, mockStub.DelState(getComputePlanKey(computePlanID)))
+	mockStub.MockTransactionEnd("43")
+	inpUpdate.Traintuples[0].ID = "fourthTraintupleID"
+	inpUpdate.Traintuples[0].DataSampleKeys = []string{trainDataSampleHash2}
+	inpUpdate.Traintuples[0].InModelsIDs = []string{"thirdTraintupleID"}
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("updateComputePlan", inpUpdate))
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	out = outputComputePlan{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &out))
+	assert.Len(t, out.TraintupleKeys, 4)
+	assert.Equal(t, []string{testtupleKey}, out.TesttupleKeys)
+	fourthTraintuple, err := db.GetTraintuple(out.TraintupleKeys[3])
+	require.NoError(t, err)
+	assert.Equal(t, []string{thirdKey}, fourthTraintuple.InModelKeys)
+
+	// Only the creator of the compute plan can update it, but any node can
+	// still add traintuples to it with createTraintuple
+	mockStub.MockTransactionStart("44")
+	db = NewLedgerDB(mockStub)
+	computePlan, err = getComputePlanRecord(db, computePlanID)
+	require.NoError(t, err)
+	computePlan.Creator = "AnotherOrg"
+	require.NoError(t, db.Put(getComputePlanKey(computePlanID), computePlan))
+	mockStub.MockTransactionEnd("44")
+	inpUpdate.Traintuples[0].ID = "fifthTraintupleID"
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("updateComputePlan", inpUpdate))
+	assert.EqualValues(t, 403, resp.Status, resp.Message)
+	inpTraintuple = inputTraintuple{InModels: []string{secondKey}, Rank: "9", ComputePlanID: computePlanID}
+	resp = mockStub.MockInvoke("42", inpTraintuple.createDefault())
+	assert.EqualValues(t, 200, resp.Status, resp.Message)
+}
+
+func TestComputePlanRanksByDepth(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "algo")
+
+	// Two independent roots on the same worker
+	inCP := inputComputePlan{
+		AlgoKey:      algoHash,
+		ObjectiveKey: objectiveDescriptionHash,
+		Traintuples: []inputComputePlanTraintuple{
+			{
+				DataManagerKey: dataManagerOpenerHash,
+				DataSampleKeys: []string{trainDataSampleHash1},
+				ID:             "first",
+			},
+			{
+				DataManagerKey: dataManagerOpenerHash,
+				DataSampleKeys: []string{trainDataSampleHash2},
+				ID:             "second",
+			},
+			{
+				DataManagerKey: dataManagerOpenerHash,
+				DataSampleKeys: []string{trainDataSampleHash1},
+				ID:             "child",
+				InModelsIDs:    []string{"first"},
+			},
+		},
+	}
+	resp := mockStub.MockInvoke("42", methodAndAssetToByte("createComputePlan", inCP))
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	outCP := outputComputePlan{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &outCP))
+	db := NewLedgerDB(mockStub)
+	for i, rank := range []int{0, 0, 1} {
+		traintuple, err := db.GetTraintuple(outCP.TraintupleKeys[i])
+		require.NoError(t, err)
+		assert.Equal(t, worker, traintuple.Dataset.Worker)
+		assert.Equal(t, rank, traintuple.Rank)
+	}
+
+	// createTraintuple still can't reuse a rank of the worker
+	inpTraintuple := inputTraintuple{
+		InModels:      []string{outCP.TraintupleKeys[1]},
+		Rank:          "1",
+		ComputePlanID: outCP.ComputePlanID,
+	}
+	resp = mockStub.MockInvoke("42", inpTraintuple.createDefault())
+	assert.EqualValues(t, 400, resp.Status, resp.Message)
+	respError := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &respError))
+	assert.EqualValues(t, errors.CodeComputePlanRankConflict, respError["code"])
+	inpTraintuple.Rank = "2"
+	resp = mockStub.MockInvoke("42", inpTraintuple.createDefault())
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+
+	// The traintuples appended with updateComputePlan are ranked by depth too
+	inpUpdate := inputUpdateComputePlan{
+		ComputePlanID: outCP.ComputePlanID,
+		Traintuples: []inputComputePlanTraintuple{{
+			DataManagerKey: dataManagerOpenerHash,
+			DataSampleKeys: []string{trainDataSampleHash2},
+			ID:             "third",
+			InModelsIDs:    []string{"child"},
+		}},
+	}
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("updateComputePlan", inpUpdate))
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	computePlan := ComputePlan{}
+	thirdKey, ok, err := computePlan.getTraintupleKey(db, outCP.ComputePlanID, "third")
+	require.NoError(t, err)
+	require.True(t, ok)
+	third, err := db.GetTraintuple(thirdKey)
+	require.NoError(t, err)
+	assert.Equal(t, 2, third.Rank)
+}
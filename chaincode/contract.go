@@ -0,0 +1,247 @@
+// Copyright 2018 Owkin, inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"chaincode/errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Role is the role a transaction requester must have to call a contract
+type Role string
+
+// Possible roles of a transaction requester
+const (
+	// RoleAnyone lets any member of the channel call the contract
+	RoleAnyone Role = "anyone"
+	// RoleNode restricts the contract to the nodes registered with registerNode
+	RoleNode Role = "node"
+)
+
+// contract describes a smart contract of the chaincode.
+// Its handler is a function taking the LedgerDB and, if the contract has one,
+// its input struct, and returning its output and an error. The input and
+// output types are read from the handler's signature.
+type contract struct {
+	name    string
+	handler interface{}
+	role    Role
+	// readOnly contracts can't write to the ledger
+	readOnly bool
+	// optionalInput contracts can be called without args, their input has then
+	// its zero value
+	optionalInput bool
+
+	fn     reflect.Value
+	input  reflect.Type
+	output reflect.Type
+}
+
+// contractRegistry holds the smart contracts of the chaincode by name
+type contractRegistry map[string]contract
+
+// contracts are the smart contracts which can be invoked on the chaincode.
+// Adding a smart contract only requires to register it here.
+var contracts contractRegistry
+
+func init() {
+	contracts = newContractRegistry([]contract{
+		{name: "archiveAlgo", handler: archiveAlgo, role: RoleNode},
+		{name: "archiveDataManager", handler: archiveDataManager, role: RoleNode},
+		{name: "archiveObjective", handler: archiveObjective, role: RoleNode},
+		{name: "claimNextTuple", handler: claimNextTuple, role: RoleNode},
+		{name: "createComputePlan", handler: createComputePlan, role: RoleNode},
+		{name: "createDatasetSplit", handler: createDatasetSplit, role: RoleNode},
+		{name: "createTesttuple", handler: createTesttuple, role: RoleNode},
+		{name: "createTraintuple", handler: createTraintuple, role: RoleNode},
+		{name: "describeContracts", handler: describeContracts, role: RoleAnyone, readOnly: true},
+		{name: "logFailTest", handler: logFailTest, role: RoleNode},
+		{name: "logFailTrain", handler: logFailTrain, role: RoleNode},
+		{name: "logStartTest", handler: logStartTest, role: RoleNode},
+		{name: "logStartTrain", handler: logStartTrain, role: RoleNode},
+		{name: "logSuccessTest", handler: logSuccessTest, role: RoleNode},
+		{name: "logSuccessTrain", handler: logSuccessTrain, role: RoleNode},
+		{name: "queryAccessReport", handler: queryAccessReport, role: RoleAnyone, readOnly: true},
+		{name: "queryAlgo", handler: queryAlgo, role: RoleAnyone, readOnly: true},
+		{name: "queryAlgos", handler: queryAlgos, role: RoleAnyone, readOnly: true, optionalInput: true},
+		{name: "queryDataManager", handler: queryDataManager, role: RoleAnyone, readOnly: true},
+		{name: "queryDataManagers", handler: queryDataManagers, role: RoleAnyone, readOnly: true, optionalInput: true},
+		{name: "queryDataSamples", handler: queryDataSamples, role: RoleAnyone, readOnly: true},
+		{name: "queryDataset", handler: queryDataset, role: RoleAnyone, readOnly: true},
+		{name: "queryDatasetSplits", handler: queryDatasetSplits, role: RoleAnyone, readOnly: true},
+		{name: "queryFilter", handler: queryFilter, role: RoleAnyone, readOnly: true},
+		{name: "queryModelDetails", handler: queryModelDetails, role: RoleAnyone, readOnly: true},
+		{name: "queryModels", handler: queryModels, role: RoleAnyone, readOnly: true},
+		{name: "queryNodeGroups", handler: queryNodeGroups, role: RoleAnyone, readOnly: true},
+		{name: "queryNodes", handler: queryNodes, role: RoleAnyone, readOnly: true},
+		{name: "queryObjective", handler: queryObjective, role: RoleAnyone, readOnly: true},
+		{name: "queryObjectiveLeaderboard", handler: queryObjectiveLeaderboard, role: RoleAnyone, readOnly: true},
+		{name: "queryObjectives", handler: queryObjectives, role: RoleAnyone, readOnly: true, optionalInput: true},
+		{name: "queryPermissionsHistory", handler: queryPermissionsHistory, role: RoleAnyone, readOnly: true},
+		{name: "queryQuota", handler: queryQuota, role: RoleAnyone, readOnly: true},
+		{name: "queryStats", handler: queryStats, role: RoleAnyone, readOnly: true, optionalInput: true},
+		{name: "queryTesttuple", handler: queryTesttuple, role: RoleAnyone, readOnly: true},
+		{name: "queryTesttuples", handler: queryTesttuples, role: RoleAnyone, readOnly: true},
+		{name: "queryTraintuple", handler: queryTraintuple, role: RoleAnyone, readOnly: true},
+		{name: "queryTraintuples", handler: queryTraintuples, role: RoleAnyone, readOnly: true},
+		{name: "registerAlgo", handler: registerAlgo, role: RoleNode},
+		{name: "registerDataManager", handler: registerDataManager, role: RoleNode},
+		{name: "registerDataSample", handler: registerDataSample, role: RoleNode},
+		{name: "registerNode", handler: registerNode, role: RoleAnyone},
+		{name: "registerNodeGroup", handler: registerNodeGroup, role: RoleNode},
+		{name: "registerObjective", handler: registerObjective, role: RoleNode},
+		{name: "revokeDataSample", handler: revokeDataSample, role: RoleNode},
+		{name: "setQuota", handler: setQuota, role: RoleNode},
+		{name: "sweepStaleTuples", handler: sweepStaleTuples, role: RoleNode},
+		{name: "updateComputePlan", handler: updateComputePlan, role: RoleNode},
+		{name: "updateDataManager", handler: updateDataManager, role: RoleNode},
+		{name: "updateDataSample", handler: updateDataSample, role: RoleNode},
+		{name: "updateDataSampleTestOnly", handler: updateDataSampleTestOnly, role: RoleNode},
+		{name: "updateNodeGroup", handler: updateNodeGroup, role: RoleNode},
+		{name: "updatePermissions", handler: updatePermissions, role: RoleNode},
+	})
+}
+
+// describeContracts returns the smart contracts of the chaincode sorted by name,
+// with the JSON Schema of their input and output. The inputs are described in
+// the latest apiVersion and the outputs in the version 1.
+func describeContracts(db LedgerDB) ([]outputContract, error) {
+	names := []string{}
+	for name := range contracts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	outContracts := []outputContract{}
+	for _, name := range names {
+		var out outputContract
+		out.Fill(contracts[name])
+		outContracts = append(outContracts, out)
+	}
+	return outContracts, nil
+}
+
+// newContractRegistry indexes the contracts by name and reads their input and
+// output types. It panics if a contract is not properly declared since the
+// chaincode can't work without its contracts.
+func newContractRegistry(list []contract) contractRegistry {
+	registry := contractRegistry{}
+	for _, c := range list {
+		if err := c.setTypes(); err != nil {
+			panic(err)
+		}
+		if _, ok := registry[c.name]; ok {
+			panic(fmt.Sprintf("contract %s registered twice", c.name))
+		}
+		registry[c.name] = c
+	}
+	return registry
+}
+
+// setTypes reads the input and output types of the contract from its handler
+func (c *contract) setTypes() error {
+	if c.name == "" || c.role == "" || c.handler == nil {
+		return errors.Internal("contract %s: name, role and handler are required", c.name)
+	}
+	c.fn = reflect.ValueOf(c.handler)
+	t := c.fn.Type()
+	if t.Kind() != reflect.Func || t.NumIn() < 1 || t.NumIn() > 2 || t.In(0) != reflect.TypeOf(LedgerDB{}) ||
+		t.NumOut() != 2 || t.Out(1) != reflect.TypeOf((*error)(nil)).Elem() {
+		return errors.Internal("contract %s: handler should be a func(LedgerDB[, input]) (output, error), got %s", c.name, t)
+	}
+	if t.NumIn() == 2 {
+		c.input = t.In(1)
+	} else if c.optionalInput {
+		return errors.Internal("contract %s: optional input without input", c.name)
+	}
+	c.output = t.Out(0)
+	return nil
+}
+
+// invoke checks that the transaction requester can call the contract and runs
+// it with the given args. The args are translated from the apiVersion of their
+// envelope and the output is rendered for it.
+func (c contract) invoke(stub shim.ChaincodeStubInterface, args []string) (interface{}, error) {
+	version, args, err := parseAPIVersion(args)
+	if err != nil {
+		return nil, err
+	}
+	if c.readOnly {
+		stub = readOnlyStub{ChaincodeStubInterface: stub, contract: c.name}
+	}
+	db := NewLedgerDB(stub)
+	if err := checkRole(db, c.role); err != nil {
+		return nil, err
+	}
+	in := []reflect.Value{reflect.ValueOf(db)}
+	if c.input != nil {
+		inp := reflect.New(c.input)
+		if len(args) > 0 || !c.optionalInput {
+			if len(args) == 1 && version < latestAPIVersion {
+				arg, err := upgradeInput(c.input, args[0], version)
+				if err != nil {
+					return nil, err
+				}
+				args = []string{arg}
+			}
+			if err := AssetFromJSON(args, inp.Interface()); err != nil {
+				return nil, err
+			}
+		}
+		in = append(in, inp.Elem())
+	} else if strings.Join(args, "") != "" {
+		return nil, errors.BadRequest("incorrect number of arguments, expecting nothing")
+	}
+	out := c.fn.Call(in)
+	if err, _ := out[1].Interface().(error); err != nil {
+		return out[0].Interface(), err
+	}
+	return renderOutput(out[0].Interface(), version)
+}
+
+// checkRole checks that the transaction requester has the role required by a contract
+func checkRole(db LedgerDB, role Role) error {
+	if role == RoleAnyone {
+		return nil
+	}
+	txCreator, err := GetTxCreator(db.cc)
+	if err != nil {
+		return err
+	}
+	if _, err := db.GetNode(txCreator); err != nil {
+		return errors.Forbidden("%s is not a registered node", txCreator)
+	}
+	return nil
+}
+
+// readOnlyStub rejects the writes made while running a read-only contract
+type readOnlyStub struct {
+	shim.ChaincodeStubInterface
+	contract string
+}
+
+// PutState fails since the contract is read-only
+func (stub readOnlyStub) PutState(key string, value []byte) error {
+	return errors.Internal("read-only contract %s can't write the key %s", stub.contract, key)
+}
+
+// DelState fails since the contract is read-only
+func (stub readOnlyStub) DelState(key string) error {
+	return errors.Internal("read-only contract %s can't delete the key %s", stub.contract, key)
+}
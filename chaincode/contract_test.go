@@ -0,0 +1,102 @@
+// Copyright 2018 Owkin, inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"chaincode/errors"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContractTypes(t *testing.T) {
+	c := contracts["queryAlgo"]
+	assert.Equal(t, reflect.TypeOf(inputHash{}), c.input)
+	assert.Equal(t, reflect.TypeOf(outputAlgo{}), c.output)
+	assert.True(t, c.readOnly)
+
+	c = contracts["queryNodes"]
+	assert.Nil(t, c.input)
+
+	invalidContracts := []contract{
+		{name: "noRole", handler: queryNodes},
+		{name: "notAFunc", handler: "queryNodes", role: RoleAnyone},
+		{name: "noError", handler: func(db LedgerDB) string { return "" }, role: RoleAnyone},
+		{name: "noDB", handler: func(inp inputHash) (string, error) { return "", nil }, role: RoleAnyone},
+		{name: "optionalWithoutInput", handler: queryNodes, role: RoleAnyone, optionalInput: true},
+	}
+	for _, c := range invalidContracts {
+		assert.Error(t, c.setTypes(), c.name)
+	}
+}
+
+func TestContractInvoke(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStub("substra", scc)
+
+	resp := mockStub.MockInvoke("42", [][]byte{[]byte("unknownContract")})
+	assert.EqualValues(t, 400, resp.Status, resp.Message)
+	assert.Contains(t, resp.Message, errors.CodeUnknownFunction)
+
+	// Only registered nodes can write to the ledger
+	inpAlgo := inputAlgo{}
+	resp = mockStub.MockInvoke("42", inpAlgo.createDefault())
+	assert.EqualValues(t, 403, resp.Status, resp.Message)
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("queryAlgos")})
+	assert.EqualValues(t, 200, resp.Status, resp.Message)
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("registerNode")})
+	assert.EqualValues(t, 200, resp.Status, resp.Message)
+	resp = mockStub.MockInvoke("42", inpAlgo.createDefault())
+	assert.EqualValues(t, 200, resp.Status, resp.Message)
+
+	// Contracts without input don't accept args, the optional inputs can be omitted
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("queryTraintuples", inputHash{Key: algoHash}))
+	assert.EqualValues(t, 400, resp.Status, resp.Message)
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("queryAlgos", inputQueryAll{IncludeArchived: true}))
+	assert.EqualValues(t, 200, resp.Status, resp.Message)
+}
+
+func TestReadOnlyContract(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStub("substra", scc)
+
+	c := contract{
+		name: "writeInQuery",
+		handler: func(db LedgerDB, inp inputHash) (string, error) {
+			return inp.Key, db.Put(inp.Key, inp)
+		},
+		role:     RoleAnyone,
+		readOnly: true,
+	}
+	require.NoError(t, c.setTypes())
+
+	mockStub.MockTransactionStart("42")
+	_, err := c.invoke(mockStub, []string{string(keyToJSON(algoHash))})
+	mockStub.MockTransactionEnd("42")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "read-only contract writeInQuery")
+	assert.Nil(t, mockStub.State[algoHash])
+
+	c.readOnly = false
+	require.NoError(t, c.setTypes())
+	mockStub.MockTransactionStart("42")
+	out, err := c.invoke(mockStub, []string{string(keyToJSON(algoHash))})
+	mockStub.MockTransactionEnd("42")
+	assert.NoError(t, err)
+	assert.Equal(t, algoHash, out)
+	assert.NotNil(t, mockStub.State[algoHash])
+}
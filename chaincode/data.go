@@ -16,7 +16,10 @@ package main
 
 import (
 	"chaincode/errors"
-	"fmt"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -53,36 +56,52 @@ func (dataManager *DataManager) Set(db LedgerDB, inp inputDataManager) (string,
 // and returning corresponding dataSample hashes, associated dataManagers, testOnly and errors
 func setDataSample(db LedgerDB, inp inputDataSample) (dataSampleHashes []string, dataSample DataSample, err error) {
 	dataSampleHashes = inp.Hashes
-	if err = checkHashes(dataSampleHashes); err != nil {
-		err = errors.BadRequest(err)
-		return
-	}
-	// check dataSample is not already in the ledger
-	if existingKeys := checkDataSamplesExist(db, dataSampleHashes); existingKeys != nil {
-		err = errors.Conflict("data samples with keys %s already exist", existingKeys).WithKeys(existingKeys)
-		return
-	}
-
 	// get transaction owner
 	owner, err := GetTxCreator(db.cc)
 	if err != nil {
 		return
 	}
+	errs := errors.MultiError{}
+	var existingKeys []string
+	for _, dataSampleHash := range dataSampleHashes {
+		if err := checkHashes([]string{dataSampleHash}); err != nil {
+			errs.Add(dataSampleHash, err)
+			continue
+		}
+		// check dataSample is not already in the ledger
+		if _, err := db.GetDataSample(dataSampleHash); err == nil {
+			existingKeys = append(existingKeys, dataSampleHash)
+			errs.Add(dataSampleHash, errors.Conflict("data sample %s already exists", dataSampleHash))
+		}
+	}
 	// check if associated dataManager(s) exists
 	var dataManagerKeys []string
-	if len(inp.DataManagerKeys) > 0 {
-		dataManagerKeys = inp.DataManagerKeys
-		if err = checkDataManagerOwner(db, dataManagerKeys); err != nil {
-			return
+	for _, dataManagerKey := range inp.DataManagerKeys {
+		dataManagerKeys = append(dataManagerKeys, dataManagerKey)
+		errs.Add(dataManagerKey, checkDataManagerOwner(db, []string{dataManagerKey}))
+	}
+	// check metadata are related to the registered dataSample, in a
+	// deterministic order so that all the peers return the same error
+	metadataHashes := []string{}
+	for dataSampleHash := range inp.Metadata {
+		metadataHashes = append(metadataHashes, dataSampleHash)
+	}
+	sort.Strings(metadataHashes)
+	for _, dataSampleHash := range metadataHashes {
+		if !stringInSlice(dataSampleHash, dataSampleHashes) {
+			errs.Add(dataSampleHash, errors.BadRequest("metadata provided for dataSample %s which is not registered", dataSampleHash))
 		}
 	}
-	// convert input testOnly to boolean
-	testOnly, err := strconv.ParseBool(inp.TestOnly)
-
+	if err = errs.ErrorOrNil(); err != nil {
+		if len(existingKeys) > 0 {
+			err = errors.Wrap(err).WithKeys(existingKeys)
+		}
+		return
+	}
 	dataSample = DataSample{
 		AssetType:       DataSampleType,
 		DataManagerKeys: dataManagerKeys,
-		TestOnly:        testOnly,
+		TestOnly:        *inp.TestOnly,
 		Owner:           owner}
 
 	return
@@ -90,18 +109,35 @@ func setDataSample(db LedgerDB, inp inputDataSample) (dataSampleHashes []string,
 
 // validateUpdateDataSample is a method checking the validity of elements sent to update
 // one or more dataSamplef
-func validateUpdateDataSample(db LedgerDB, inp inputUpdateDataSample) (dataSampleHashes []string, dataManagerKeys []string, err error) {
-	// TODO return full dataSample
-	// check validity of dataSampleHashes
-	if err = checkHashes(inp.Hashes); err != nil {
-		err = errors.BadRequest(err)
-		return
-	}
+func validateUpdateDataSample(db LedgerDB, inp inputUpdateDataSample) (dataSamples map[string]DataSample, err error) {
+	errs := errors.MultiError{}
 	// check dataManagers exist and are owned by the transaction requester
-	if err = checkDataManagerOwner(db, inp.DataManagerKeys); err != nil {
-		return
+	for _, dataManagerKey := range inp.DataManagerKeys {
+		errs.Add(dataManagerKey, checkDataManagerOwner(db, []string{dataManagerKey}))
+	}
+	dataSamples = map[string]DataSample{}
+	for _, dataSampleHash := range inp.Hashes {
+		// check validity of dataSampleHashes
+		if err := checkHashes([]string{dataSampleHash}); err != nil {
+			errs.Add(dataSampleHash, err)
+			continue
+		}
+		dataSample, err := db.GetDataSample(dataSampleHash)
+		if err != nil {
+			errs.Add(dataSampleHash, err)
+			continue
+		}
+		if err := checkDataSampleOwner(db, dataSample); err != nil {
+			errs.Add(dataSampleHash, err)
+			continue
+		}
+		if dataSample.Revoked {
+			errs.Add(dataSampleHash, errors.BadRequest(errors.CodeRevokedDataSample, "dataSample %s has been revoked", dataSampleHash))
+			continue
+		}
+		dataSamples[dataSampleHash] = dataSample
 	}
-	return inp.Hashes, inp.DataManagerKeys, nil
+	return dataSamples, errs.ErrorOrNil()
 }
 
 // -----------------------------------------------------------------
@@ -109,12 +145,7 @@ func validateUpdateDataSample(db LedgerDB, inp inputUpdateDataSample) (dataSampl
 // -----------------------------------------------------------------
 
 // registerDataManager stores a new dataManager in the ledger.
-func registerDataManager(db LedgerDB, args []string) (resp map[string]string, err error) {
-	inp := inputDataManager{}
-	err = AssetFromJSON(args, &inp)
-	if err != nil {
-		return
-	}
+func registerDataManager(db LedgerDB, inp inputDataManager) (resp map[string]string, err error) {
 	// check validity of input args and convert it to a DataManager
 	if len(inp.ObjectiveKey) > 0 {
 		if _, err := db.GetObjective(inp.ObjectiveKey); err != nil {
@@ -147,13 +178,7 @@ func registerDataManager(db LedgerDB, args []string) (resp map[string]string, er
 }
 
 // registerDataSample stores new dataSample in the ledger (one or more).
-func registerDataSample(db LedgerDB, args []string) (dataSampleKeys map[string][]string, err error) {
-	// convert input strings args to input struct inputDataSample
-	inp := inputDataSample{}
-	err = AssetFromJSON(args, &inp)
-	if err != nil {
-		return
-	}
+func registerDataSample(db LedgerDB, inp inputDataSample) (dataSampleKeys map[string][]string, err error) {
 	// check validity of input args
 	dataSampleHashes, dataSample, err := setDataSample(db, inp)
 	if err != nil {
@@ -162,6 +187,15 @@ func registerDataSample(db LedgerDB, args []string) (dataSampleKeys map[string][
 
 	// store dataSample in the ledger
 	for _, dataSampleHash := range dataSampleHashes {
+		dataSample.Metadata = nil
+		if metadata, ok := inp.Metadata[dataSampleHash]; ok {
+			dataSample.Metadata = &DataSampleMetadata{
+				Size:                  metadata.Size,
+				RecordCount:           metadata.RecordCount,
+				LabelDistributionHash: metadata.LabelDistributionHash,
+				Tags:                  metadata.Tags,
+			}
+		}
 		if err = db.Add(dataSampleHash, dataSample); err != nil {
 			return
 		}
@@ -182,31 +216,19 @@ func registerDataSample(db LedgerDB, args []string) (dataSampleKeys map[string][
 }
 
 // updateDataSample associates one or more dataManagerKeys to one or more dataSample
-func updateDataSample(db LedgerDB, args []string) (resp map[string]string, err error) {
-	inp := inputUpdateDataSample{}
-	err = AssetFromJSON(args, &inp)
-	if err != nil {
-		return
-	}
+func updateDataSample(db LedgerDB, inp inputUpdateDataSample) (resp map[string]string, err error) {
 	// check validity of input args
-	dataSampleHashes, dataManagerKeys, err := validateUpdateDataSample(db, inp)
+	dataSamples, err := validateUpdateDataSample(db, inp)
 	if err != nil {
 		return
 	}
 	// store dataSample in the ledger
 	var dataSampleKeys string
 	suffix := ", "
-	for _, dataSampleHash := range dataSampleHashes {
+	for _, dataSampleHash := range inp.Hashes {
 		dataSampleKeys = dataSampleKeys + "\"" + dataSampleHash + "\"" + suffix
-		var dataSample DataSample
-		dataSample, err = db.GetDataSample(dataSampleHash)
-		if err != nil {
-			return
-		}
-		if err = checkDataSampleOwner(db, dataSample); err != nil {
-			return
-		}
-		for _, dataManagerKey := range dataManagerKeys {
+		dataSample := dataSamples[dataSampleHash]
+		for _, dataManagerKey := range inp.DataManagerKeys {
 			if !stringInSlice(dataManagerKey, dataSample.DataManagerKeys) {
 				// check data manager is not already associated with this data
 				dataSample.DataManagerKeys = append(dataSample.DataManagerKeys, dataManagerKey)
@@ -231,14 +253,78 @@ func updateDataSample(db LedgerDB, args []string) (resp map[string]string, err e
 	return map[string]string{"key": dataSampleKeys}, nil
 }
 
-// updateDataManager associates a objectiveKey to an existing dataManager
-func updateDataManager(db LedgerDB, args []string) (resp map[string]string, err error) {
-	inp := inputUpdateDataManager{}
-	err = AssetFromJSON(args, &inp)
-	if err != nil {
+// updateDataSampleTestOnly moves one or more dataSample between train and test.
+// It is refused if a dataSample is used in a way which is not compatible with
+// its new role.
+func updateDataSampleTestOnly(db LedgerDB, inp inputUpdateDataSampleTestOnly) (resp map[string][]string, err error) {
+	if err = checkHashes(inp.Hashes); err != nil {
+		err = errors.BadRequest(err)
 		return
 	}
+	testOnly := *inp.TestOnly
+	for _, dataSampleHash := range inp.Hashes {
+		var dataSample DataSample
+		dataSample, err = db.GetDataSample(dataSampleHash)
+		if err != nil {
+			return
+		}
+		if err = checkDataSampleOwner(db, dataSample); err != nil {
+			return
+		}
+		if dataSample.Revoked {
+			err = errors.BadRequest(errors.CodeRevokedDataSample, "dataSample %s has been revoked", dataSampleHash)
+			return
+		}
+		if err = setDataSampleTestOnly(db, dataSampleHash, dataSample, testOnly); err != nil {
+			return
+		}
+	}
+	return map[string][]string{"keys": inp.Hashes}, nil
+}
+
+// revokeDataSample marks one or more dataSample as revoked so that they can't be
+// used anymore in new tuples and objectives
+func revokeDataSample(db LedgerDB, inp inputRevokeDataSample) (resp map[string][]string, err error) {
+	if err = checkHashes(inp.Hashes); err != nil {
+		err = errors.BadRequest(err)
+		return
+	}
+	event := DataSamplesEvent{}
+	for _, dataSampleHash := range inp.Hashes {
+		var dataSample DataSample
+		dataSample, err = db.GetDataSample(dataSampleHash)
+		if err != nil {
+			return
+		}
+		if err = checkDataSampleOwner(db, dataSample); err != nil {
+			return
+		}
+		if dataSample.Revoked {
+			err = errors.BadRequest(errors.CodeRevokedDataSample, "dataSample %s is already revoked", dataSampleHash)
+			return
+		}
+		dataSample.Revoked = true
+		if err = db.Put(dataSampleHash, dataSample); err != nil {
+			return
+		}
+		for _, dataManagerKey := range dataSample.DataManagerKeys {
+			// create composite keys to find all revoked dataSample associated with a dataManager
+			if err = db.CreateIndex("dataSample~dataManager~revoked~key", []string{"dataSample", dataManagerKey, dataSampleHash}); err != nil {
+				return
+			}
+		}
+		var out outputDataSample
+		out.Fill(dataSampleHash, dataSample)
+		event.AddDataSample(out)
+	}
+	if err = SendDataSamplesRevokedEvent(db.cc, event); err != nil {
+		return
+	}
+	return map[string][]string{"keys": inp.Hashes}, nil
+}
 
+// updateDataManager associates a objectiveKey to an existing dataManager
+func updateDataManager(db LedgerDB, inp inputUpdateDataManager) (resp map[string]string, err error) {
 	// update dataManager.ObjectiveKey
 	if err = addObjectiveDataManager(db, inp.DataManagerKey, inp.ObjectiveKey); err != nil {
 		return
@@ -247,12 +333,7 @@ func updateDataManager(db LedgerDB, args []string) (resp map[string]string, err
 }
 
 // queryDataManager returns dataManager and its key
-func queryDataManager(db LedgerDB, args []string) (out outputDataManager, err error) {
-	inp := inputHash{}
-	err = AssetFromJSON(args, &inp)
-	if err != nil {
-		return
-	}
+func queryDataManager(db LedgerDB, inp inputHash) (out outputDataManager, err error) {
 	dataManager, err := db.GetDataManager(inp.Key)
 	if err != nil {
 		return
@@ -265,14 +346,10 @@ func queryDataManager(db LedgerDB, args []string) (out outputDataManager, err er
 	return
 }
 
-// queryDataManagers returns all DataManagers of the ledger
-func queryDataManagers(db LedgerDB, args []string) ([]outputDataManager, error) {
-	var err error
+// queryDataManagers returns all DataManagers of the ledger, archived ones are
+// only returned if includeArchived is set
+func queryDataManagers(db LedgerDB, inp inputQueryAll) ([]outputDataManager, error) {
 	outDataManagers := []outputDataManager{}
-	if len(args) != 0 {
-		err = errors.BadRequest("incorrect number of arguments, expecting nothing")
-		return outDataManagers, err
-	}
 	var indexName = "dataManager~owner~key"
 	elementsKeys, err := db.GetIndexKeys(indexName, []string{"dataManager"})
 	if err != nil {
@@ -283,6 +360,9 @@ func queryDataManagers(db LedgerDB, args []string) ([]outputDataManager, error)
 		if err != nil {
 			return outDataManagers, err
 		}
+		if dataManager.Archived && !inp.IncludeArchived {
+			continue
+		}
 		var out outputDataManager
 		out.Fill(key, dataManager)
 		outDataManagers = append(outDataManagers, out)
@@ -290,15 +370,107 @@ func queryDataManagers(db LedgerDB, args []string) ([]outputDataManager, error)
 	return outDataManagers, nil
 }
 
-// queryDataset returns info about a dataManager and all related dataSample
-func queryDataset(db LedgerDB, args []string) (outputDataset, error) {
-	inp := inputHash{}
-	out := outputDataset{}
-	err := AssetFromJSON(args, &inp)
+// archiveDataManager archives a dataManager so that it can't be used in new tuples anymore.
+// Only the owner of the dataManager can archive it.
+func archiveDataManager(db LedgerDB, inp inputHash) (resp map[string]string, err error) {
+	dataManager, err := db.GetDataManager(inp.Key)
 	if err != nil {
-		return out, err
+		return
+	}
+	if err = checkArchivable(db, "dataManager", inp.Key, dataManager.Owner, dataManager.Archived); err != nil {
+		return
+	}
+	dataManager.Archived = true
+	err = db.Put(inp.Key, dataManager)
+	if err != nil {
+		return
+	}
+	return map[string]string{"key": inp.Key}, nil
+}
+
+// createDatasetSplit splits the dataSample of a dataManager between train and test.
+// Each dataSample is assigned by hashing its key with the seed so that the same
+// inputs always give the same split. Revoked dataSample are left untouched.
+// The split is refused if it changes the role of a dataSample already used
+// by a traintuple or an objective. The split is recorded in the ledger.
+func createDatasetSplit(db LedgerDB, inp inputDatasetSplit) (out outputDatasetSplit, err error) {
+	if err = checkDataManagerOwner(db, []string{inp.DataManagerKey}); err != nil {
+		return
+	}
+	creator, err := GetTxCreator(db.cc)
+	if err != nil {
+		return
+	}
+	datasetSplit := DatasetSplit{
+		AssetType:           DatasetSplitType,
+		DataManagerKey:      inp.DataManagerKey,
+		TestRatio:           inp.TestRatio,
+		Seed:                inp.Seed,
+		Creator:             creator,
+		TrainDataSampleKeys: []string{},
+		TestDataSampleKeys:  []string{},
+	}
+
+	dataSampleKeys, err := db.GetIndexKeys("dataSample~dataManager~key", []string{"dataSample", inp.DataManagerKey})
+	if err != nil {
+		return
+	}
+	for _, dataSampleKey := range dataSampleKeys {
+		var dataSample DataSample
+		dataSample, err = db.GetDataSample(dataSampleKey)
+		if err != nil {
+			return
+		}
+		if dataSample.Revoked {
+			continue
+		}
+		testOnly := isInTestSplit(dataSampleKey, inp.Seed, inp.TestRatio)
+		if testOnly {
+			datasetSplit.TestDataSampleKeys = append(datasetSplit.TestDataSampleKeys, dataSampleKey)
+		} else {
+			datasetSplit.TrainDataSampleKeys = append(datasetSplit.TrainDataSampleKeys, dataSampleKey)
+		}
+		if err = setDataSampleTestOnly(db, dataSampleKey, dataSample, testOnly); err != nil {
+			return
+		}
+	}
+
+	datasetSplitKey := HashForKey("datasetSplit", inp.DataManagerKey, db.cc.GetTxID())
+	if err = db.Add(datasetSplitKey, datasetSplit); err != nil {
+		return
+	}
+	if err = db.CreateIndex("datasetSplit~dataManager~key", []string{"datasetSplit", inp.DataManagerKey, datasetSplitKey}); err != nil {
+		return
+	}
+	out.Fill(datasetSplitKey, datasetSplit)
+	return
+}
+
+// queryDatasetSplits returns all the splits of the dataSample of a dataManager
+func queryDatasetSplits(db LedgerDB, inp inputHash) (outDatasetSplits []outputDatasetSplit, err error) {
+	outDatasetSplits = []outputDatasetSplit{}
+	if _, err = db.GetDataManager(inp.Key); err != nil {
+		return
+	}
+	datasetSplitKeys, err := db.GetIndexKeys("datasetSplit~dataManager~key", []string{"datasetSplit", inp.Key})
+	if err != nil {
+		return
+	}
+	for _, key := range datasetSplitKeys {
+		datasetSplit, err := db.GetDatasetSplit(key)
+		if err != nil {
+			return outDatasetSplits, err
+		}
+		var out outputDatasetSplit
+		out.Fill(key, datasetSplit)
+		outDatasetSplits = append(outDatasetSplits, out)
 	}
+	return
+}
 
+// queryDataset returns info about a dataManager and all related dataSample
+func queryDataset(db LedgerDB, inp inputHash) (outputDataset, error) {
+	out := outputDataset{}
 	dataManager, err := db.GetDataManager(inp.Key)
 	if err != nil {
 		return out, err
@@ -316,16 +488,26 @@ func queryDataset(db LedgerDB, args []string) (outputDataset, error) {
 		return out, err
 	}
 
-	out.Fill(inp.Key, dataManager, trainDataSampleKeys, testDataSampleKeys)
+	// get related revoked dataSample
+	revokedDataSampleKeys, err := db.GetIndexKeys("dataSample~dataManager~revoked~key", []string{"dataSample", inp.Key})
+	if err != nil {
+		return out, err
+	}
+
+	out.Fill(inp.Key, dataManager, trainDataSampleKeys, testDataSampleKeys, revokedDataSampleKeys)
+
+	// aggregate the metadata of the dataSample which can still be used
+	if err = addDataSamplesStats(db, &out.TrainStats, trainDataSampleKeys); err != nil {
+		return out, err
+	}
+	if err = addDataSamplesStats(db, &out.TestStats, testDataSampleKeys); err != nil {
+		return out, err
+	}
 	return out, nil
 }
 
-func queryDataSamples(db LedgerDB, args []string) ([]outputDataSample, error) {
+func queryDataSamples(db LedgerDB) ([]outputDataSample, error) {
 	outDataSamples := []outputDataSample{}
-	if len(args) != 0 {
-		err := fmt.Errorf("incorrect number of arguments, expecting nothing")
-		return outDataSamples, err
-	}
 	elementsKeys, err := db.GetIndexKeys("dataSample~dataManager~key", []string{"dataSample"})
 	if err != nil {
 		return outDataSamples, err
@@ -382,8 +564,8 @@ func checkDataSampleOwner(db LedgerDB, dataSample DataSample) error {
 	return nil
 }
 
-// checkSameDataManager checks if dataSample in a slice exist and are from the same dataManager.
-// If yes, returns two boolean indicating if dataSample are testOnly and trainOnly
+// checkSameDataManager checks if dataSample in a slice exist, are from the same dataManager
+// and have not been revoked. If yes, returns two boolean indicating if dataSample are testOnly and trainOnly
 func checkSameDataManager(db LedgerDB, dataManagerKey string, dataSampleKeys []string) (bool, bool, error) {
 	testOnly := true
 	trainOnly := true
@@ -393,7 +575,11 @@ func checkSameDataManager(db LedgerDB, dataManagerKey string, dataSampleKeys []s
 			return testOnly, trainOnly, err
 		}
 		if !stringInSlice(dataManagerKey, dataSample.DataManagerKeys) {
-			err = fmt.Errorf("dataSample do not belong to the same dataManager")
+			err = errors.BadRequest(errors.CodeDataManagerMismatch, "dataSample do not belong to the same dataManager")
+			return testOnly, trainOnly, err
+		}
+		if dataSample.Revoked {
+			err = errors.BadRequest(errors.CodeRevokedDataSample, "dataSample %s has been revoked", dataSampleKey)
 			return testOnly, trainOnly, err
 		}
 		testOnly = testOnly && dataSample.TestOnly
@@ -413,6 +599,71 @@ func getDataset(db LedgerDB, dataManagerKey string, testOnly bool) ([]string, er
 	return dataSampleKeys, nil
 }
 
+// isInTestSplit returns whether a dataSample belongs to the test part of a split.
+// The dataSample key hashed with the seed is mapped to [0, 1) and compared to the ratio.
+func isInTestSplit(dataSampleKey, seed string, testRatio float64) bool {
+	sum := sha256.Sum256([]byte(seed + "," + dataSampleKey))
+	position := float64(binary.BigEndian.Uint64(sum[:8])) / math.Pow(2, 64)
+	return position < testRatio
+}
+
+// setDataSampleTestOnly updates the testOnly field of a dataSample and the
+// related index for all its dataManagers. A dataSample used by a traintuple
+// can't become test only and a dataSample part of an objective's test dataset
+// can't become train only.
+func setDataSampleTestOnly(db LedgerDB, dataSampleKey string, dataSample DataSample, testOnly bool) error {
+	if dataSample.TestOnly == testOnly {
+		return nil
+	}
+	if err := checkDataSampleRole(db, dataSampleKey, testOnly); err != nil {
+		return err
+	}
+	indexName := "dataSample~dataManager~testOnly~key"
+	for _, dataManagerKey := range dataSample.DataManagerKeys {
+		oldAttributes := []string{"dataSample", dataManagerKey, strconv.FormatBool(dataSample.TestOnly), dataSampleKey}
+		newAttributes := []string{"dataSample", dataManagerKey, strconv.FormatBool(testOnly), dataSampleKey}
+		if err := db.UpdateIndex(indexName, oldAttributes, newAttributes); err != nil {
+			return err
+		}
+	}
+	dataSample.TestOnly = testOnly
+	return db.Put(dataSampleKey, dataSample)
+}
+
+// checkDataSampleRole checks that a dataSample is not used by a traintuple
+// before it becomes test only, or by an objective before it becomes train only
+func checkDataSampleRole(db LedgerDB, dataSampleKey string, testOnly bool) error {
+	indexName := "objective~dataSample~key"
+	if testOnly {
+		indexName = "traintuple~dataSample~key"
+	}
+	assetName := strings.Split(indexName, "~")[0]
+	keys, err := db.GetIndexKeys(indexName, []string{assetName, dataSampleKey})
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return errors.BadRequest(errors.CodeDataSampleInUse, "dataSample %s is used by the %s %s", dataSampleKey, assetName, keys[0]).WithKeys(keys)
+	}
+	return nil
+}
+
+// addDataSamplesStats adds the metadata of the dataSample given their keys to
+// the stats, skipping the revoked ones
+func addDataSamplesStats(db LedgerDB, stats *outputDataSamplesByTag, dataSampleKeys []string) error {
+	for _, dataSampleKey := range dataSampleKeys {
+		dataSample, err := db.GetDataSample(dataSampleKey)
+		if err != nil {
+			return err
+		}
+		if dataSample.Revoked {
+			continue
+		}
+		stats.Add(dataSample)
+	}
+	return nil
+}
+
 // getDataManagerOwner returns the owner of a dataManager given its key
 func getDataManagerOwner(db LedgerDB, dataManagerKey string) (string, error) {
 	dataManager, err := db.GetDataManager(dataManagerKey)
@@ -422,13 +673,3 @@ func getDataManagerOwner(db LedgerDB, dataManagerKey string) (string, error) {
 	return dataManager.Owner, nil
 }
 
-// checkDataSamplesExist checks if keys in a slice correspond to existing elements in the ledger
-// returns the slice of already existing elements
-func checkDataSamplesExist(db LedgerDB, keys []string) (existingKeys []string) {
-	for _, key := range keys {
-		if _, err := db.GetDataSample(key); err == nil {
-			existingKeys = append(existingKeys, key)
-		}
-	}
-	return
-}
@@ -15,10 +15,12 @@
 package main
 
 import (
+	"chaincode/errors"
 	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestJsonInputsDataManager(t *testing.T) {
@@ -122,7 +124,7 @@ func TestGetTestDatasetKeys(t *testing.T) {
 	args = inpDataSample.createDefault()
 	mockStub.MockInvoke("42", args)
 	inpDataSample.Hashes = []string{testDataSampleHash2}
-	inpDataSample.TestOnly = "true"
+	inpDataSample.TestOnly = boolPtr(true)
 	args = inpDataSample.createDefault()
 	mockStub.MockInvoke("42", args)
 
@@ -181,6 +183,32 @@ func TestDataset(t *testing.T) {
 	resp = mockStub.MockInvoke("42", args)
 	assert.EqualValuesf(t, 409, resp.Status, "when adding dataSample which already exist, status %d and message %s", resp.Status, resp.Message)
 
+	// All the invalid dataSample are reported at once
+	inpInvalidDataSample := inputDataSample{Hashes: []string{trainDataSampleHash1, testDataSampleHash1}}
+	resp = mockStub.MockInvoke("42", inpInvalidDataSample.createDefault())
+	assert.EqualValuesf(t, 409, resp.Status, "when adding dataSample which already exist, status %d and message %s", resp.Status, resp.Message)
+	respError := struct {
+		Keys   []string           `json:"keys"`
+		Errors []errors.ItemError `json:"errors"`
+	}{}
+	assert.NoError(t, json.Unmarshal(resp.Payload, &respError))
+	assert.Equal(t, []string{trainDataSampleHash1}, respError.Keys)
+	assert.Len(t, respError.Errors, 1)
+
+	inpUpdate := inputUpdateDataSample{
+		Hashes:          []string{trainDataSampleHash1, testDataSampleHash1, testDataSampleHash2},
+		DataManagerKeys: []string{dataManagerOpenerHash, objectiveDescriptionHash},
+	}
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("updateDataSample", inpUpdate))
+	assert.EqualValuesf(t, 400, resp.Status, "when updating unknown dataSample, status %d and message %s", resp.Status, resp.Message)
+	respError.Errors = nil
+	assert.NoError(t, json.Unmarshal(resp.Payload, &respError))
+	items := []string{}
+	for _, itemError := range respError.Errors {
+		items = append(items, itemError.Item)
+	}
+	assert.Equal(t, []string{objectiveDescriptionHash, testDataSampleHash1, testDataSampleHash2}, items)
+
 	// Query dataSample and check it corresponds to what was input
 	args = [][]byte{[]byte("queryDataset"), keyToJSON(inpDataManager.OpenerHash)}
 	resp = mockStub.MockInvoke("42", args)
@@ -191,3 +219,263 @@ func TestDataset(t *testing.T) {
 	assert.ElementsMatch(t, out.TrainDataSampleKeys, inpDataSample.Hashes, "when querying dataManager dataSample, unexpected train keys")
 
 }
+
+func TestRevokeDataSample(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "algo")
+
+	// Revoke an unknown dataSample
+	inpRevoke := inputRevokeDataSample{Hashes: []string{algoHash}}
+	args := methodAndAssetToByte("revokeDataSample", inpRevoke)
+	resp := mockStub.MockInvoke("42", args)
+	assert.EqualValuesf(t, 404, resp.Status, "when revoking an unknown dataSample, status %d and message %s", resp.Status, resp.Message)
+
+	// Revoke a train dataSample
+	inpRevoke = inputRevokeDataSample{Hashes: []string{trainDataSampleHash1}}
+	args = methodAndAssetToByte("revokeDataSample", inpRevoke)
+	resp = mockStub.MockInvoke("42", args)
+	assert.EqualValuesf(t, 200, resp.Status, "when revoking a dataSample, status %d and message %s", resp.Status, resp.Message)
+
+	// Revoke it again
+	resp = mockStub.MockInvoke("42", args)
+	assert.EqualValuesf(t, 400, resp.Status, "when revoking a dataSample twice, status %d and message %s", resp.Status, resp.Message)
+
+	// The dataset lists the revoked dataSample
+	args = [][]byte{[]byte("queryDataset"), keyToJSON(dataManagerOpenerHash)}
+	resp = mockStub.MockInvoke("42", args)
+	assert.EqualValuesf(t, 200, resp.Status, "when querying the dataset, status %d and message %s", resp.Status, resp.Message)
+	out := outputDataset{}
+	err := json.Unmarshal(resp.Payload, &out)
+	assert.NoError(t, err, "while unmarshalling dataset")
+	assert.Equal(t, []string{trainDataSampleHash1}, out.RevokedDataSampleKeys)
+
+	// The revoked dataSample can't be used in a traintuple anymore
+	inpTraintuple := inputTraintuple{DataSampleKeys: []string{trainDataSampleHash1}}
+	args = inpTraintuple.createDefault()
+	resp = mockStub.MockInvoke("42", args)
+	assert.EqualValuesf(t, 400, resp.Status, "when creating a traintuple with a revoked dataSample, status %d and message %s", resp.Status, resp.Message)
+
+	// nor be associated to another dataManager
+	inpUpdate := inputUpdateDataSample{
+		Hashes:          []string{trainDataSampleHash1},
+		DataManagerKeys: []string{dataManagerOpenerHash},
+	}
+	args = methodAndAssetToByte("updateDataSample", inpUpdate)
+	resp = mockStub.MockInvoke("42", args)
+	assert.EqualValuesf(t, 400, resp.Status, "when updating a revoked dataSample, status %d and message %s", resp.Status, resp.Message)
+
+	// The other dataSample are still usable
+	inpTraintuple = inputTraintuple{DataSampleKeys: []string{trainDataSampleHash2}}
+	args = inpTraintuple.createDefault()
+	resp = mockStub.MockInvoke("42", args)
+	assert.EqualValuesf(t, 200, resp.Status, "when creating a traintuple, status %d and message %s", resp.Status, resp.Message)
+}
+
+func TestDatasetStats(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "objective")
+
+	// Add dataSample with metadata for an unknown hash
+	inpDataSample := inputDataSample{
+		Metadata: map[string]inputDataSampleMetadata{
+			algoHash: inputDataSampleMetadata{Size: 10},
+		},
+	}
+	args := inpDataSample.createDefault()
+	resp := mockStub.MockInvoke("42", args)
+	assert.EqualValuesf(t, 400, resp.Status, "when adding metadata of an unknown dataSample, status %d and message %s", resp.Status, resp.Message)
+
+	// The invalid metadata are always reported in the same order
+	inpDataSample = inputDataSample{
+		Metadata: map[string]inputDataSampleMetadata{
+			trainDataSampleHash1: inputDataSampleMetadata{Size: -1},
+			trainDataSampleHash2: inputDataSampleMetadata{RecordCount: -1},
+			testDataSampleHash1:  inputDataSampleMetadata{Size: -1},
+		},
+	}
+	args = inpDataSample.createDefault()
+	resp = mockStub.MockInvoke("42", args)
+	assert.EqualValues(t, 400, resp.Status, resp.Message)
+	for i := 0; i < 10; i++ {
+		again := mockStub.MockInvoke("42", args)
+		require.Equal(t, resp.Message, again.Message)
+	}
+
+	// Add dataSample with metadata
+	inpDataSample = inputDataSample{
+		Metadata: map[string]inputDataSampleMetadata{
+			trainDataSampleHash1: inputDataSampleMetadata{
+				Size:        100,
+				RecordCount: 5,
+				Tags:        map[string]string{"center": "A"},
+			},
+			trainDataSampleHash2: inputDataSampleMetadata{
+				Size: 50,
+				Tags: map[string]string{"center": "B", "label": "cat"},
+			},
+		},
+	}
+	args = inpDataSample.createDefault()
+	resp = mockStub.MockInvoke("42", args)
+	assert.EqualValuesf(t, 200, resp.Status, "when adding dataSample, status %d and message %s", resp.Status, resp.Message)
+
+	args = [][]byte{[]byte("queryDataset"), keyToJSON(dataManagerOpenerHash)}
+	resp = mockStub.MockInvoke("42", args)
+	assert.EqualValuesf(t, 200, resp.Status, "when querying the dataset, status %d and message %s", resp.Status, resp.Message)
+	out := outputDataset{}
+	err := json.Unmarshal(resp.Payload, &out)
+	assert.NoError(t, err, "while unmarshalling dataset")
+
+	expectedTrainStats := outputDataSamplesByTag{
+		outputDataSamplesStats: outputDataSamplesStats{Count: 2, TotalSize: 150},
+		ByTag: map[string]map[string]outputDataSamplesStats{
+			"center": {
+				"A": {Count: 1, TotalSize: 100},
+				"B": {Count: 1, TotalSize: 50},
+			},
+			"label": {
+				"cat": {Count: 1, TotalSize: 50},
+			},
+		},
+	}
+	assert.Equal(t, expectedTrainStats, out.TrainStats)
+	// test dataSample are registered without metadata
+	assert.Equal(t, 2, out.TestStats.Count)
+	assert.EqualValues(t, 0, out.TestStats.TotalSize)
+
+	// Revoked dataSample are not counted anymore
+	args = methodAndAssetToByte("revokeDataSample", inputRevokeDataSample{Hashes: []string{trainDataSampleHash2}})
+	resp = mockStub.MockInvoke("42", args)
+	assert.EqualValuesf(t, 200, resp.Status, "when revoking a dataSample, status %d and message %s", resp.Status, resp.Message)
+	args = [][]byte{[]byte("queryDataset"), keyToJSON(dataManagerOpenerHash)}
+	resp = mockStub.MockInvoke("42", args)
+	out = outputDataset{}
+	err = json.Unmarshal(resp.Payload, &out)
+	assert.NoError(t, err, "while unmarshalling dataset")
+	assert.Equal(t, 1, out.TrainStats.Count)
+	assert.EqualValues(t, 100, out.TrainStats.TotalSize)
+}
+
+func TestDatasetSplit(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "testDataset")
+	inpDataSample := inputDataSample{}
+	args := inpDataSample.createDefault()
+	resp := mockStub.MockInvoke("42", args)
+	require.EqualValuesf(t, 200, resp.Status, "when adding dataSample, status %d and message %s", resp.Status, resp.Message)
+
+	// Invalid ratio
+	inpSplit := inputDatasetSplit{DataManagerKey: dataManagerOpenerHash, TestRatio: 1.5, Seed: "42"}
+	args = methodAndAssetToByte("createDatasetSplit", inpSplit)
+	resp = mockStub.MockInvoke("42", args)
+	assert.EqualValuesf(t, 400, resp.Status, "when splitting with an invalid ratio, status %d and message %s", resp.Status, resp.Message)
+
+	// Unknown dataManager
+	inpSplit = inputDatasetSplit{DataManagerKey: algoHash, TestRatio: 0.5, Seed: "42"}
+	args = methodAndAssetToByte("createDatasetSplit", inpSplit)
+	resp = mockStub.MockInvoke("42", args)
+	assert.EqualValuesf(t, 400, resp.Status, "when splitting an unknown dataManager, status %d and message %s", resp.Status, resp.Message)
+
+	inpSplit = inputDatasetSplit{DataManagerKey: dataManagerOpenerHash, TestRatio: 0.5, Seed: "42"}
+	args = methodAndAssetToByte("createDatasetSplit", inpSplit)
+	resp = mockStub.MockInvoke("42", args)
+	require.EqualValuesf(t, 200, resp.Status, "when splitting the dataset, status %d and message %s", resp.Status, resp.Message)
+	split := outputDatasetSplit{}
+	err := json.Unmarshal(resp.Payload, &split)
+	assert.NoError(t, err)
+	allKeys := []string{trainDataSampleHash1, trainDataSampleHash2, testDataSampleHash1, testDataSampleHash2}
+	assert.ElementsMatch(t, allKeys, append(split.TrainDataSampleKeys, split.TestDataSampleKeys...))
+
+	// The dataset reflects the split
+	args = [][]byte{[]byte("queryDataset"), keyToJSON(dataManagerOpenerHash)}
+	resp = mockStub.MockInvoke("42", args)
+	dataset := outputDataset{}
+	err = json.Unmarshal(resp.Payload, &dataset)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, split.TrainDataSampleKeys, dataset.TrainDataSampleKeys)
+	assert.ElementsMatch(t, split.TestDataSampleKeys, dataset.TestDataSampleKeys)
+
+	// The same inputs always give the same split
+	args = methodAndAssetToByte("createDatasetSplit", inpSplit)
+	resp = mockStub.MockInvoke("43", args)
+	require.EqualValuesf(t, 200, resp.Status, "when splitting the dataset again, status %d and message %s", resp.Status, resp.Message)
+	otherSplit := outputDatasetSplit{}
+	err = json.Unmarshal(resp.Payload, &otherSplit)
+	assert.NoError(t, err)
+	assert.NotEqual(t, split.Key, otherSplit.Key)
+	assert.Equal(t, split.TrainDataSampleKeys, otherSplit.TrainDataSampleKeys)
+	assert.Equal(t, split.TestDataSampleKeys, otherSplit.TestDataSampleKeys)
+
+	// Both splits are recorded
+	args = [][]byte{[]byte("queryDatasetSplits"), keyToJSON(dataManagerOpenerHash)}
+	resp = mockStub.MockInvoke("42", args)
+	assert.EqualValuesf(t, 200, resp.Status, "when querying the dataset splits, status %d and message %s", resp.Status, resp.Message)
+	splits := []outputDatasetSplit{}
+	err = json.Unmarshal(resp.Payload, &splits)
+	assert.NoError(t, err)
+	assert.Len(t, splits, 2)
+
+	// A split can't move the test dataSample of an objective to train
+	inpObjective := inputObjective{}
+	inpObjective.TestDataset.DataSampleKeys = split.TestDataSampleKeys
+	args = inpObjective.createDefault()
+	resp = mockStub.MockInvoke("42", args)
+	require.EqualValuesf(t, 200, resp.Status, "when adding objective, status %d and message %s", resp.Status, resp.Message)
+	inpSplit.TestRatio = 0.001
+	args = methodAndAssetToByte("createDatasetSplit", inpSplit)
+	resp = mockStub.MockInvoke("44", args)
+	assert.EqualValuesf(t, 400, resp.Status, "when splitting the test dataset of an objective, status %d and message %s", resp.Status, resp.Message)
+}
+
+func TestUpdateDataSampleTestOnly(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "traintuple")
+
+	// A dataSample used by a traintuple can't become test only
+	inp := inputUpdateDataSampleTestOnly{Hashes: []string{trainDataSampleHash1}, TestOnly: boolPtr(true)}
+	args := methodAndAssetToByte("updateDataSampleTestOnly", inp)
+	resp := mockStub.MockInvoke("42", args)
+	assert.EqualValuesf(t, 400, resp.Status, "when moving a dataSample used by a traintuple to test, status %d and message %s", resp.Status, resp.Message)
+	respError := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(resp.Payload, &respError))
+	assert.EqualValues(t, errors.CodeDataSampleInUse, respError["code"])
+
+	// A dataSample of an objective's test dataset can't become train only
+	inp = inputUpdateDataSampleTestOnly{Hashes: []string{testDataSampleHash1}, TestOnly: boolPtr(false)}
+	args = methodAndAssetToByte("updateDataSampleTestOnly", inp)
+	resp = mockStub.MockInvoke("42", args)
+	assert.EqualValuesf(t, 400, resp.Status, "when moving a dataSample of an objective to train, status %d and message %s", resp.Status, resp.Message)
+	respError = map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(resp.Payload, &respError))
+	assert.EqualValues(t, errors.CodeDataSampleInUse, respError["code"])
+
+	// An unused dataSample can be moved both ways
+	unusedDataSampleHash := "cc1bb7c31f62244c0f3a761cc168804227115793d01c270021fe3f7935482dcc"
+	inpDataSample := inputDataSample{Hashes: []string{unusedDataSampleHash}}
+	args = inpDataSample.createDefault()
+	resp = mockStub.MockInvoke("42", args)
+	require.EqualValuesf(t, 200, resp.Status, "when adding dataSample, status %d and message %s", resp.Status, resp.Message)
+	for _, testOnly := range []bool{true, false} {
+		inp = inputUpdateDataSampleTestOnly{Hashes: []string{unusedDataSampleHash}, TestOnly: &testOnly}
+		args = methodAndAssetToByte("updateDataSampleTestOnly", inp)
+		resp = mockStub.MockInvoke("42", args)
+		assert.EqualValuesf(t, 200, resp.Status, "when setting testOnly to %t, status %d and message %s", testOnly, resp.Status, resp.Message)
+
+		args = [][]byte{[]byte("queryDataset"), keyToJSON(dataManagerOpenerHash)}
+		resp = mockStub.MockInvoke("42", args)
+		dataset := outputDataset{}
+		err := json.Unmarshal(resp.Payload, &dataset)
+		assert.NoError(t, err)
+		if testOnly {
+			assert.Contains(t, dataset.TestDataSampleKeys, unusedDataSampleHash)
+			assert.NotContains(t, dataset.TrainDataSampleKeys, unusedDataSampleHash)
+		} else {
+			assert.Contains(t, dataset.TrainDataSampleKeys, unusedDataSampleHash)
+			assert.NotContains(t, dataset.TestDataSampleKeys, unusedDataSampleHash)
+		}
+	}
+}
@@ -15,6 +15,7 @@
 package errors
 
 import (
+	stderrors "errors"
 	"fmt"
 	"net/http"
 )
@@ -26,6 +27,10 @@ type Error struct {
 	Kind Kind
 	// The underlying error if any
 	Err error
+	// code is the machine readable identifier of the error, see the Code method
+	code Code
+	// cause is the first error passed to E, returned by Unwrap
+	cause error
 	// Associated interface through errors methods
 	context map[string]interface{}
 }
@@ -46,8 +51,12 @@ func (e Error) Error() string {
 // The possible arg type are:
 //	errors.Kind
 //		The class of error, such as a key conflict
+//	errors.Code
+//		The machine readable identifier of the error, the code of an
+//		underlying Error is kept if none is given
 //	error
-//		The underlying error
+//		The underlying error, the first one is kept as the cause of the
+//		error so that errors.Is and errors.As can go through the chain
 //	string
 //		The string to add to the existing error message. As mention above
 //		all the args following the first string will be handle as format
@@ -59,14 +68,25 @@ func E(args ...interface{}) Error {
 		switch arg := arg.(type) {
 		case Kind:
 			e.Kind = arg
+		case Code:
+			e.code = arg
 		case Error:
 			e.context = arg.context
+			if e.code == "" {
+				e.code = arg.code
+			}
+			if e.cause == nil {
+				e.cause = arg
+			}
 			if e.Err == nil {
 				e.Err = arg.Err
 			} else {
 				e.Err = fmt.Errorf("%s %s", arg.Error(), e.Error())
 			}
 		case error:
+			if e.cause == nil {
+				e.cause = arg
+			}
 			if e.Err == nil {
 				e.Err = arg
 			} else {
@@ -87,12 +107,70 @@ func E(args ...interface{}) Error {
 }
 
 // Wrap converts an error interface to the internal Error type.
-// Does nothing if an internal Error type is passed.
+// Does nothing if an internal Error type is passed. If an Error is found in
+// the chain of a foreign error, its kind, code and context are used.
 func Wrap(err error) Error {
 	if e, ok := err.(Error); ok {
 		return e
 	}
-	return Error{Err: err}
+	e := Error{Err: err, cause: stderrors.Unwrap(err), context: map[string]interface{}{}}
+	var inner Error
+	if stderrors.As(err, &inner) {
+		e.Kind = inner.Kind
+		e.code = inner.code
+		e.context = inner.context
+	}
+	return e
+}
+
+// Unwrap returns the cause of the error, if any
+func (e Error) Unwrap() error {
+	return e.cause
+}
+
+// Is reports whether the error matches the target. An Error without message,
+// such as NotFound(), matches the errors of the same kind, and of the same
+// code if the target has one: errors.Is(err, NotFound()) tells whether err or
+// one of its causes is a NotFound error.
+func (e Error) Is(target error) bool {
+	t, ok := target.(Error)
+	if !ok || t.Err != nil {
+		return false
+	}
+	return t.Kind == e.Kind && (t.code == "" || t.code == e.Code())
+}
+
+// Cause describes one of the errors of the chain which led to an Error
+type Cause struct {
+	Error string `json:"error"`
+	Kind  string `json:"kind"`
+	Code  Code   `json:"code"`
+}
+
+// Causes returns the chain of errors wrapped by the error, from the closest to
+// the root one. Foreign errors take the kind of the Error they wrap, if any,
+// and are reported as internal ones otherwise.
+func (e Error) Causes() []Cause {
+	causes := []Cause{}
+	for err := e.Unwrap(); err != nil; err = stderrors.Unwrap(err) {
+		cause := Wrap(err)
+		causes = append(causes, Cause{Error: err.Error(), Kind: cause.Kind.String(), Code: cause.Code()})
+	}
+	return causes
+}
+
+// FieldError describes why a field of an input is invalid
+type FieldError struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
+	Param string `json:"param,omitempty"`
+}
+
+// WithFieldErrors associate the invalid fields of an input to the error context
+// It overwrites previous fields' list if any.
+func (e Error) WithFieldErrors(fields []FieldError) Error {
+	e.context["fields"] = fields
+	return e
 }
 
 // Internal returns an Error of a this specific type
@@ -139,6 +217,20 @@ func (e Error) WithKeys(keys []string) Error {
 	return e
 }
 
+// WithCode sets the code of the error
+func (e Error) WithCode(code Code) Error {
+	e.code = code
+	return e
+}
+
+// Code returns the code of the error, the default code of its kind if none was set
+func (e Error) Code() Code {
+	if e.code != "" {
+		return e.code
+	}
+	return e.Kind.Code()
+}
+
 // GetContext return the associated key if there is any
 func (e Error) GetContext() map[string]interface{} {
 	return e.context
@@ -162,6 +254,21 @@ const (
 	forbidden              // Forbidden request
 )
 
+// String returns the name of an error kind
+func (k Kind) String() string {
+	switch k {
+	case notFound:
+		return "notFound"
+	case conflict:
+		return "conflict"
+	case badRequest:
+		return "badRequest"
+	case forbidden:
+		return "forbidden"
+	}
+	return "internal"
+}
+
 // HTTPStatusCode returns for an error kind the associated http status
 func (k Kind) HTTPStatusCode() int {
 	switch k {
@@ -176,3 +283,60 @@ func (k Kind) HTTPStatusCode() int {
 	}
 	return http.StatusInternalServerError
 }
+
+// Code returns the default code of an error kind
+func (k Kind) Code() Code {
+	switch k {
+	case notFound:
+		return CodeAssetNotFound
+	case conflict:
+		return CodeAssetConflict
+	case badRequest:
+		return CodeInvalidRequest
+	case forbidden:
+		return CodePermissionDenied
+	}
+	return CodeInternal
+}
+
+// Code is a stable and machine readable identifier of an error, sent along
+// the error message so that clients don't have to parse it
+type Code string
+
+// Catalogue of the error codes. Beware, they are part of the API: existing
+// values must not be changed.
+const (
+	// CodeInternal is the default code of the internal errors
+	CodeInternal Code = "INTERNAL"
+	// CodeInvalidRequest is the default code of the bad requests
+	CodeInvalidRequest Code = "INVALID_REQUEST"
+	// CodeAssetNotFound is used when an asset doesn't exist in the ledger
+	CodeAssetNotFound Code = "ASSET_NOT_FOUND"
+	// CodeAssetConflict is used when an asset already exists in the ledger
+	CodeAssetConflict Code = "ASSET_CONFLICT"
+	// CodePermissionDenied is used when the requester is not allowed to use an asset
+	CodePermissionDenied Code = "PERMISSION_DENIED"
+	// CodeUnknownFunction is used when the smart contract called doesn't exist
+	CodeUnknownFunction Code = "UNKNOWN_FUNCTION"
+	// CodeUnsupportedAPIVersion is used when the apiVersion requested is not supported
+	CodeUnsupportedAPIVersion Code = "UNSUPPORTED_API_VERSION"
+	// CodeInvalidStatusTransition is used when a tuple can't move to the requested status
+	CodeInvalidStatusTransition Code = "INVALID_STATUS_TRANSITION"
+	// CodeTestOnlyData is used when test only data is used for training or
+	// train data for testing
+	CodeTestOnlyData Code = "TEST_ONLY_DATA"
+	// CodeDataManagerMismatch is used when dataSample don't belong to the given dataManager
+	CodeDataManagerMismatch Code = "DATA_MANAGER_MISMATCH"
+	// CodeRevokedDataSample is used when a revoked dataSample is used
+	CodeRevokedDataSample Code = "REVOKED_DATA_SAMPLE"
+	// CodeArchivedAsset is used when an archived asset is used in a new tuple
+	CodeArchivedAsset Code = "ARCHIVED_ASSET"
+	// CodeComputePlanRankConflict is used when createTraintuple is given a rank
+	// already used by the worker in a compute plan
+	CodeComputePlanRankConflict Code = "COMPUTE_PLAN_RANK_CONFLICT"
+	// CodeQuotaExceeded is used when a node exceeded its quota on a dataManager
+	CodeQuotaExceeded Code = "QUOTA_EXCEEDED"
+	// CodeDataSampleInUse is used when a dataSample can't change its role because
+	// it is already used by a tuple or an objective
+	CodeDataSampleInUse Code = "DATA_SAMPLE_IN_USE"
+)
@@ -15,6 +15,7 @@
 package errors
 
 import (
+	stderrors "errors"
 	"fmt"
 	"net/http"
 	"testing"
@@ -124,3 +125,85 @@ func TestErrorCases(t *testing.T) {
 		})
 	}
 }
+
+func TestErrorCodes(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		err          Error
+		expectedCode Code
+	}{
+		{desc: "Internal default", err: E("msg"), expectedCode: CodeInternal},
+		{desc: "NotFound default", err: NotFound("msg"), expectedCode: CodeAssetNotFound},
+		{desc: "Conflict default", err: Conflict("msg"), expectedCode: CodeAssetConflict},
+		{desc: "BadRequest default", err: BadRequest("msg"), expectedCode: CodeInvalidRequest},
+		{desc: "Forbidden default", err: Forbidden("msg"), expectedCode: CodePermissionDenied},
+		{desc: "explicit code", err: BadRequest(CodeTestOnlyData, "msg"), expectedCode: CodeTestOnlyData},
+		{desc: "code kept when wrapping", err: BadRequest(NotFound(CodeAssetNotFound, "msg"), "wrapped"), expectedCode: CodeAssetNotFound},
+		{desc: "default code not kept when wrapping", err: BadRequest(Internal("msg"), "wrapped"), expectedCode: CodeInvalidRequest},
+		{desc: "explicit code overrides wrapped one", err: BadRequest(CodeArchivedAsset, NotFound(CodeAssetNotFound, "msg")), expectedCode: CodeArchivedAsset},
+		{desc: "WithCode", err: Forbidden("msg").WithCode(CodeQuotaExceeded), expectedCode: CodeQuotaExceeded},
+		{desc: "Wrap standard error", err: Wrap(fmt.Errorf("msg")), expectedCode: CodeInternal},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			assert.Equal(t, tC.expectedCode, tC.err.Code())
+		})
+	}
+}
+
+func TestErrorChain(t *testing.T) {
+	notFound := NotFound("asset not found")
+	e := BadRequest(notFound, "invalid inputs:")
+
+	assert.Equal(t, notFound, stderrors.Unwrap(e))
+	assert.True(t, stderrors.Is(e, BadRequest()))
+	assert.True(t, stderrors.Is(e, NotFound()))
+	assert.True(t, stderrors.Is(e, NotFound(CodeAssetNotFound)))
+	assert.False(t, stderrors.Is(e, NotFound(CodeArchivedAsset)))
+	assert.False(t, stderrors.Is(e, Forbidden()))
+
+	var inner Error
+	assert.True(t, stderrors.As(fmt.Errorf("foreign: %w", e), &inner))
+	assert.Equal(t, BadRequest().Kind, inner.Kind)
+
+	wrapped := Wrap(fmt.Errorf("foreign: %w", notFound))
+	assert.Equal(t, http.StatusNotFound, wrapped.HTTPStatusCode())
+	assert.Equal(t, CodeAssetNotFound, wrapped.Code())
+
+	causes := BadRequest(fmt.Errorf("foreign: %w", NotFound(stderrors.New("root"), "asset not found:")), "invalid inputs:").Causes()
+	assert.Equal(t, []Cause{
+		{Error: "foreign: asset not found: root", Kind: "notFound", Code: CodeAssetNotFound},
+		{Error: "asset not found: root", Kind: "notFound", Code: CodeAssetNotFound},
+		{Error: "root", Kind: "internal", Code: CodeInternal},
+	}, causes)
+	assert.Empty(t, BadRequest("msg").Causes())
+}
+
+func TestMultiError(t *testing.T) {
+	errs := MultiError{}
+	errs.Add("a", nil)
+	assert.Equal(t, 0, errs.Len())
+	assert.NoError(t, errs.ErrorOrNil())
+
+	errs.Add("a", NotFound("asset a not found"))
+	errs.Add("b", NotFound("asset b not found"))
+	err := errs.ErrorOrNil()
+	assert.Error(t, err)
+	e := Wrap(err)
+	assert.Equal(t, http.StatusNotFound, e.HTTPStatusCode())
+	assert.Equal(t, "2 invalid items: a: asset a not found; b: asset b not found", e.Error())
+	assert.Equal(t, []ItemError{
+		{Item: "a", Error: "asset a not found", Kind: "notFound", Code: CodeAssetNotFound},
+		{Item: "b", Error: "asset b not found", Kind: "notFound", Code: CodeAssetNotFound},
+	}, e.GetContext()["errors"])
+
+	errs.Add("c", Forbidden(CodeQuotaExceeded, "quota exceeded"))
+	e = Wrap(errs.ErrorOrNil())
+	assert.Equal(t, http.StatusBadRequest, e.HTTPStatusCode())
+	assert.Equal(t, CodeInvalidRequest, e.Code())
+
+	errs.Add("d", fmt.Errorf("ledger failure"))
+	e = Wrap(errs.ErrorOrNil())
+	assert.Equal(t, http.StatusInternalServerError, e.HTTPStatusCode())
+	assert.Equal(t, 4, errs.Len())
+}
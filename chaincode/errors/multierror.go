@@ -0,0 +1,81 @@
+// Copyright 2018 Owkin, inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errors
+
+import (
+	"strings"
+)
+
+// ItemError describes the error of one of the items of a batch
+type ItemError struct {
+	Item  string `json:"item"`
+	Error string `json:"error"`
+	Kind  string `json:"kind"`
+	Code  Code   `json:"code"`
+}
+
+// MultiError collects the errors of the items of a batch so that all of them
+// are reported at once instead of only the first one.
+// Its zero value is ready to use.
+type MultiError struct {
+	items []string
+	errs  []Error
+}
+
+// Add collects the error of an item identified by its key. Nil errors are ignored.
+func (m *MultiError) Add(item string, err error) {
+	if err == nil {
+		return
+	}
+	m.items = append(m.items, item)
+	m.errs = append(m.errs, Wrap(err))
+}
+
+// Len returns the number of errors collected
+func (m *MultiError) Len() int {
+	return len(m.errs)
+}
+
+// ErrorOrNil returns nil if no error has been collected and an Error listing
+// all of them otherwise, under the "errors" key of its context.
+// The Error has the kind and the code shared by all the collected errors. If
+// they differ, it is internal if one of them is, a bad request otherwise.
+func (m *MultiError) ErrorOrNil() error {
+	if len(m.errs) == 0 {
+		return nil
+	}
+	kind, code := m.errs[0].Kind, m.errs[0].Code()
+	details := []ItemError{}
+	messages := []string{}
+	for i, e := range m.errs {
+		if e.Kind != kind && kind != internal {
+			kind = badRequest
+			if e.Kind == internal {
+				kind = internal
+			}
+		}
+		if e.Code() != code {
+			code = ""
+		}
+		details = append(details, ItemError{Item: m.items[i], Error: e.Error(), Kind: e.Kind.String(), Code: e.Code()})
+		messages = append(messages, m.items[i]+": "+e.Error())
+	}
+	e := E(kind, "%d invalid items: %s", len(m.errs), strings.Join(messages, "; "))
+	if code != "" && code != kind.Code() {
+		e.code = code
+	}
+	e.context["errors"] = details
+	return e
+}
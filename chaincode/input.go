@@ -23,12 +23,13 @@ package main
 type inputObjective struct {
 	Name                      string           `validate:"required,gte=1,lte=100" json:"name"`
 	DescriptionHash           string           `validate:"required,len=64,hexadecimal" json:"descriptionHash"`
-	DescriptionStorageAddress string           `validate:"required,url" json:"descriptionStorageAddress"`
+	DescriptionStorageAddress string           `validate:"required,url" json:"descriptionStorageAddress" log:"sensitive"`
 	MetricsName               string           `validate:"required,gte=1,lte=100" json:"metricsName"`
 	MetricsHash               string           `validate:"required,len=64,hexadecimal" json:"metricsHash"`
-	MetricsStorageAddress     string           `validate:"required,url" json:"metricsStorageAddress"`
+	MetricsStorageAddress     string           `validate:"required,url" json:"metricsStorageAddress" log:"sensitive"`
 	TestDataset               inputDataset     `validate:"omitempty" json:"testDataset"`
 	Permissions               inputPermissions `validate:"required" json:"permissions"`
+	MaxDuration               int64            `validate:"omitempty,gte=0" json:"maxDuration"`
 }
 
 // inputDataset is the representation in input args to register a dataset
@@ -41,9 +42,9 @@ type inputDataset struct {
 type inputAlgo struct {
 	Name                      string           `validate:"required,gte=1,lte=100" json:"name"`
 	Hash                      string           `validate:"required,len=64,hexadecimal" json:"hash"`
-	StorageAddress            string           `validate:"required,url" json:"storageAddress"`
+	StorageAddress            string           `validate:"required,url" json:"storageAddress" log:"sensitive"`
 	DescriptionHash           string           `validate:"required,len=64,hexadecimal" json:"descriptionHash"`
-	DescriptionStorageAddress string           `validate:"required,url" json:"descriptionStorageAddress"`
+	DescriptionStorageAddress string           `validate:"required,url" json:"descriptionStorageAddress" log:"sensitive"`
 	Permissions               inputPermissions `validate:"required" json:"permissions"`
 }
 
@@ -51,10 +52,10 @@ type inputAlgo struct {
 type inputDataManager struct {
 	Name                      string           `validate:"required,gte=1,lte=100" json:"name"`
 	OpenerHash                string           `validate:"required,len=64,hexadecimal" json:"openerHash"`
-	OpenerStorageAddress      string           `validate:"required,url" json:"openerStorageAddress"`
+	OpenerStorageAddress      string           `validate:"required,url" json:"openerStorageAddress" log:"sensitive"`
 	Type                      string           `validate:"required,gte=1,lte=30" json:"type"`
 	DescriptionHash           string           `validate:"required,len=64,hexadecimal" json:"descriptionHash"`
-	DescriptionStorageAddress string           `validate:"required,url" json:"descriptionStorageAddress"`
+	DescriptionStorageAddress string           `validate:"required,url" json:"descriptionStorageAddress" log:"sensitive"`
 	ObjectiveKey              string           `validate:"omitempty" json:"objectiveKey"` //`validate:"required"`
 	Permissions               inputPermissions `validate:"required" json:"permissions"`
 }
@@ -65,11 +66,21 @@ type inputUpdateDataManager struct {
 	ObjectiveKey   string `validate:"required,len=64,hexadecimal" json:"objectiveKey"`
 }
 
-// inputDataSample is the representation of input args to register one or more dataSample
+// inputDataSample is the representation of input args to register one or more dataSample.
+// Metadata is optional and indexed by the dataSample hashes.
 type inputDataSample struct {
-	Hashes          []string `validate:"required,dive,len=64,hexadecimal" json:"hashes"`
-	DataManagerKeys []string `validate:"omitempty,dive,len=64,hexadecimal" json:"dataManagerKeys"`
-	TestOnly        string   `validate:"required,oneof=true false" json:"testOnly"`
+	Hashes          []string                           `validate:"required,dive,len=64,hexadecimal" json:"hashes"`
+	DataManagerKeys []string                           `validate:"omitempty,dive,len=64,hexadecimal" json:"dataManagerKeys"`
+	TestOnly        *bool                              `validate:"required" json:"testOnly"`
+	Metadata        map[string]inputDataSampleMetadata `validate:"omitempty,dive,keys,len=64,hexadecimal,endkeys,required" json:"metadata"`
+}
+
+// inputDataSampleMetadata is the representation of the optional description of a dataSample
+type inputDataSampleMetadata struct {
+	Size                  int64             `validate:"gte=0" json:"size"`
+	RecordCount           int64             `validate:"gte=0" json:"recordCount"`
+	LabelDistributionHash string            `validate:"omitempty,len=64,hexadecimal" json:"labelDistributionHash"`
+	Tags                  map[string]string `validate:"omitempty,lte=20,dive,keys,gte=1,lte=64,endkeys,lte=100" json:"tags"`
 }
 
 // inputUpdateDataSample is the representation of input args to update one or more dataSample
@@ -78,6 +89,26 @@ type inputUpdateDataSample struct {
 	DataManagerKeys []string `validate:"required,dive,len=64,hexadecimal" json:"dataManagerKeys"`
 }
 
+// inputUpdateDataSampleTestOnly is the representation of input args to move one or more
+// dataSample between train and test
+type inputUpdateDataSampleTestOnly struct {
+	Hashes   []string `validate:"required,dive,len=64,hexadecimal" json:"hashes"`
+	TestOnly *bool    `validate:"required" json:"testOnly"`
+}
+
+// inputRevokeDataSample is the representation of input args to revoke one or more dataSample
+type inputRevokeDataSample struct {
+	Hashes []string `validate:"required,dive,len=64,hexadecimal" json:"hashes"`
+}
+
+// inputDatasetSplit is the representation of input args to split the dataSample of a
+// dataManager between train and test. TestRatio is the expected proportion of test dataSample.
+type inputDatasetSplit struct {
+	DataManagerKey string  `validate:"required,len=64,hexadecimal" json:"dataManagerKey"`
+	TestRatio      float64 `validate:"gt=0,lt=1" json:"testRatio"`
+	Seed           string  `validate:"required,lte=64" json:"seed"`
+}
+
 // inputTraintuple is the representation of input args to register a Traintuple
 type inputTraintuple struct {
 	AlgoKey        string   `validate:"required,len=64,hexadecimal" json:"algoKey"`
@@ -88,6 +119,7 @@ type inputTraintuple struct {
 	ComputePlanID  string   `validate:"omitempty" json:"computePlanID"`
 	Rank           string   `validate:"omitempty" json:"rank"`
 	Tag            string   `validate:"omitempty,lte=64" json:"tag"`
+	Priority       int      `validate:"omitempty" json:"priority"`
 }
 
 // inputTestuple is the representation of input args to register a Testtuple
@@ -96,12 +128,27 @@ type inputTesttuple struct {
 	DataManagerKey string   `validate:"omitempty,len=64,hexadecimal" json:"dataManagerKey"`
 	DataSampleKeys []string `validate:"omitempty,dive,len=64,hexadecimal" json:"dataSampleKeys"`
 	Tag            string   `validate:"omitempty,lte=64" json:"tag"`
+	Priority       int      `validate:"omitempty" json:"priority"`
 }
 
 type inputHash struct {
 	Key string `validate:"required,len=64,hexadecimal" json:"key"`
 }
 
+// inputQueryAll is the representation of the optional input args of the
+// queries listing all the assets of a type
+type inputQueryAll struct {
+	IncludeArchived bool `json:"includeArchived"`
+}
+
+// inputQueryStats is the representation of the optional input args of
+// queryStats, restricting the stats to the tuples of an objective or of a
+// compute plan
+type inputQueryStats struct {
+	ObjectiveKey  string `validate:"omitempty,len=64,hexadecimal" json:"objectiveKey"`
+	ComputePlanID string `validate:"omitempty,len=64,hexadecimal" json:"computePlanID"`
+}
+
 type inputLogSuccessTrain struct {
 	inputLog
 	OutModel inputHashDress `validate:"required" json:"outModel"`
@@ -124,7 +171,7 @@ type inputLog struct {
 
 type inputHashDress struct {
 	Hash           string `validate:"required,len=64,hexadecimal" json:"hash"`
-	StorageAddress string `validate:"required" json:"storageAddress"`
+	StorageAddress string `validate:"required" json:"storageAddress" log:"sensitive"`
 }
 
 type inputQueryFilter struct {
@@ -135,22 +182,36 @@ type inputQueryFilter struct {
 
 // inputConputePlan represent a coherent set of tuples uploaded together.
 // They share the same Algo and Objective represented by their respective keys.
-// Traintuples is the list of all the traintuples planed by the compute plan
-// Beware, it's order sensitive since the `InModelsIDs` can only be interpreted
-// if the traintuples matching those IDs have already been created.
+// Traintuples is the list of all the traintuples planed by the compute plan,
+// they can be listed in any order since they are sorted according to their
+// `InModelsIDs` before being created.
 type inputComputePlan struct {
 	AlgoKey      string                       `validate:"required,len=64,hexadecimal" json:"algoKey"`
 	ObjectiveKey string                       `validate:"required,len=64,hexadecimal" json:"objectiveKey"`
 	Traintuples  []inputComputePlanTraintuple `validate:"required,gt=0" json:"traintuples"`
 	Testtuples   []inputComputePlanTesttuple  `validate:"omitempty" json:"testtuples"`
+	MaxDuration  int64                        `validate:"omitempty,gte=0" json:"maxDuration"`
+}
+
+// inputUpdateComputePlan represent a set of tuples to append to an existing compute plan.
+// The `InModelsIDs` and `TraintupleID` can refer to traintuples previously added to
+// the compute plan as well as to the new ones.
+type inputUpdateComputePlan struct {
+	ComputePlanID string                       `validate:"required,len=64,hexadecimal" json:"computePlanID"`
+	Traintuples   []inputComputePlanTraintuple `validate:"omitempty" json:"traintuples"`
+	Testtuples    []inputComputePlanTesttuple  `validate:"omitempty" json:"testtuples"`
 }
 
+// inputComputePlanTraintuple is a traintuple of a compute plan. Its rank is its
+// depth in the graph of the traintuples, so that the independent traintuples of
+// a worker can share a rank, unlike the ones added with createTraintuple.
 type inputComputePlanTraintuple struct {
 	DataManagerKey string   `validate:"required,len=64,hexadecimal" json:"dataManagerKey"`
 	DataSampleKeys []string `validate:"required,dive,len=64,hexadecimal" json:"dataSampleKeys"`
 	ID             string   `validate:"required,lte=64" json:"id"`
 	InModelsIDs    []string `validate:"omitempty,dive,lte=64" json:"inModelsIDs"`
 	Tag            string   `validate:"omitempty,lte=64" json:"tag"`
+	Priority       int      `validate:"omitempty" json:"priority"`
 }
 
 type inputComputePlanTesttuple struct {
@@ -158,6 +219,7 @@ type inputComputePlanTesttuple struct {
 	DataSampleKeys []string `validate:"omitempty,dive,len=64,hexadecimal" json:"dataSampleKeys"`
 	Tag            string   `validate:"omitempty,lte=64" json:"tag"`
 	TraintupleID   string   `validate:"required,lte=64" json:"traintupleID"`
+	Priority       int      `validate:"omitempty" json:"priority"`
 }
 
 type inputLeaderboard struct {
@@ -165,11 +227,52 @@ type inputLeaderboard struct {
 	AscendingOrder bool   `json:"ascendingOrder,required"`
 }
 
+// inputUpdatePermissions is the representation of input args to update the
+// permissions of an algo, a dataManager or an objective
+type inputUpdatePermissions struct {
+	Key         string           `validate:"required,len=64,hexadecimal" json:"key"`
+	Permissions inputPermissions `validate:"required" json:"permissions"`
+}
+
+// inputNodeGroup is the representation of input args to register or update a node group
+type inputNodeGroup struct {
+	Name    string   `validate:"required,gte=1,lte=64" json:"name"`
+	NodeIDs []string `validate:"required,gt=0,dive,required" json:"nodeIDs"`
+}
+
 type inputPermissions struct {
 	Process inputPermission `validate:"required" json:"process"`
 }
 
 type inputPermission struct {
-	Public        bool     `json:"public,required"`
-	AuthorizedIDs []string `validate:"required" json:"authorizedIDs"`
+	Public        bool                     `json:"public,required"`
+	AuthorizedIDs []string                 `validate:"required" json:"authorizedIDs"`
+	Validities    map[string]inputValidity `validate:"omitempty,dive" json:"validities,omitempty"`
+}
+
+// inputValidity is the representation of the optional time window, as unix
+// timestamps in seconds, during which an authorized node can use an asset
+type inputValidity struct {
+	NotBefore int64 `validate:"gte=0" json:"notBefore"`
+	NotAfter  int64 `validate:"gte=0" json:"notAfter"`
+}
+
+// inputQuota is the representation of input args to set the quota of a node on a dataManager
+type inputQuota struct {
+	DataManagerKey  string `validate:"required,len=64,hexadecimal" json:"dataManagerKey"`
+	NodeID          string `validate:"required" json:"nodeID"`
+	MaxActiveTuples int    `validate:"omitempty,gte=0" json:"maxActiveTuples"`
+	MaxTuplesPerDay int    `validate:"omitempty,gte=0" json:"maxTuplesPerDay"`
+}
+
+// inputQuotaKey is the representation of input args to query the quota of a node on a dataManager
+type inputQuotaKey struct {
+	DataManagerKey string `validate:"required,len=64,hexadecimal" json:"dataManagerKey"`
+	NodeID         string `validate:"required" json:"nodeID"`
+}
+
+// inputInit is the representation of the optional settings given when
+// instantiating or upgrading the chaincode
+type inputInit struct {
+	LogLevel string `json:"logLevel"`
 }
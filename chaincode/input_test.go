@@ -81,8 +81,8 @@ func (dataSample *inputDataSample) createDefault() [][]byte {
 	if dataSample.DataManagerKeys == nil || len(dataSample.DataManagerKeys) == 0 {
 		dataSample.DataManagerKeys = []string{dataManagerOpenerHash}
 	}
-	if dataSample.TestOnly == "" {
-		dataSample.TestOnly = "false"
+	if dataSample.TestOnly == nil {
+		dataSample.TestOnly = boolPtr(false)
 	}
 	args := append([][]byte{[]byte("registerDataSample")}, assetToJSON(dataSample))
 	return args
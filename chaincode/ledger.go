@@ -29,63 +29,110 @@ const (
 	AlgoType
 	TraintupleType
 	TesttupleType
+	ComputePlanType
+	DatasetSplitType
+	PermissionsUpdateType
+	NodeGroupType
+	QuotaType
+	StatsType
+	QuotaUsageType
+	SettingsType
 )
 
 // Objective is the representation of one of the element type stored in the ledger
 type Objective struct {
 	Name                      string         `json:"name"`
 	AssetType                 AssetType      `json:"assetType"`
-	DescriptionStorageAddress string         `json:"descriptionStorageAddress"`
+	DescriptionStorageAddress string         `json:"descriptionStorageAddress" log:"sensitive"`
 	Metrics                   *HashDressName `json:"metrics"`
 	Owner                     string         `json:"owner"`
 	TestDataset               *Dataset       `json:"testDataset"`
 	Permissions               Permissions    `json:"permissions"`
+	Archived                  bool           `json:"archived"`
+	MaxDuration               int64          `json:"maxDuration"`
 }
 
 // DataManager is the representation of one of the elements type stored in the ledger
 type DataManager struct {
 	Name                 string      `json:"name"`
 	AssetType            AssetType   `json:"assetType"`
-	OpenerStorageAddress string      `json:"openerStorageAddress"`
+	OpenerStorageAddress string      `json:"openerStorageAddress" log:"sensitive"`
 	Type                 string      `json:"type"`
 	Description          *HashDress  `json:"description"`
 	Owner                string      `json:"owner"`
 	ObjectiveKey         string      `json:"objectiveKey"`
 	Permissions          Permissions `json:"permissions"`
+	Archived             bool        `json:"archived"`
 }
 
 // DataSample is the representation of one of the element type stored in the ledger
 type DataSample struct {
-	AssetType       AssetType `json:"assetType"`
-	DataManagerKeys []string  `json:"dataManagerKeys"`
-	Owner           string    `json:"owner"`
-	TestOnly        bool      `json:"testOnly"`
+	AssetType       AssetType           `json:"assetType"`
+	DataManagerKeys []string            `json:"dataManagerKeys"`
+	Owner           string              `json:"owner"`
+	TestOnly        bool                `json:"testOnly"`
+	Revoked         bool                `json:"revoked"`
+	Metadata        *DataSampleMetadata `json:"metadata"`
+}
+
+// DataSampleMetadata describes the content of a dataSample
+type DataSampleMetadata struct {
+	Size                  int64             `json:"size"`
+	RecordCount           int64             `json:"recordCount"`
+	LabelDistributionHash string            `json:"labelDistributionHash"`
+	Tags                  map[string]string `json:"tags"`
+}
+
+// DatasetSplit is the record of a train/test split of the dataSample of a dataManager
+type DatasetSplit struct {
+	AssetType           AssetType `json:"assetType"`
+	DataManagerKey      string    `json:"dataManagerKey"`
+	TestRatio           float64   `json:"testRatio"`
+	Seed                string    `json:"seed"`
+	Creator             string    `json:"creator"`
+	TrainDataSampleKeys []string  `json:"trainDataSampleKeys"`
+	TestDataSampleKeys  []string  `json:"testDataSampleKeys"`
+}
+
+// PermissionsUpdate is the record of a change of the permissions of an asset.
+// Index is the position of the change in the history of the asset.
+type PermissionsUpdate struct {
+	AssetType           AssetType   `json:"assetType"`
+	AssetKey            string      `json:"assetKey"`
+	Index               int         `json:"index"`
+	Creator             string      `json:"creator"`
+	PreviousPermissions Permissions `json:"previousPermissions"`
+	Permissions         Permissions `json:"permissions"`
 }
 
 // Algo is the representation of one of the element type stored in the ledger
 type Algo struct {
 	Name           string      `json:"name"`
 	AssetType      AssetType   `json:"assetType"`
-	StorageAddress string      `json:"storageAddress"`
+	StorageAddress string      `json:"storageAddress" log:"sensitive"`
 	Description    *HashDress  `json:"description"`
 	Owner          string      `json:"owner"`
 	Permissions    Permissions `json:"permissions"`
+	Archived       bool        `json:"archived"`
 }
 
 // Traintuple is the representation of one the element type stored in the ledger. It describes a training task occuring on the platform
 type Traintuple struct {
 	AssetType     AssetType   `json:"assetType"`
 	AlgoKey       string      `json:"algoKey"`
+	CreationDate  int64       `json:"creationDate"`
 	Creator       string      `json:"creator"`
 	Dataset       *Dataset    `json:"dataset"`
 	ComputePlanID string      `json:"computePlanID"`
 	InModelKeys   []string    `json:"inModels"`
-	Log           string      `json:"log"`
+	Log           string      `json:"log" log:"truncate"`
 	ObjectiveKey  string      `json:"objectiveKey"`
 	OutModel      *HashDress  `json:"outModel"`
 	Perf          float32     `json:"perf"`
 	Permissions   Permissions `json:"permissions"`
+	Priority      int         `json:"priority"`
 	Rank          int         `json:"rank"`
+	StartDate     int64       `json:"startDate"`
 	Status        string      `json:"status"`
 	Tag           string      `json:"tag"`
 }
@@ -95,16 +142,36 @@ type Testtuple struct {
 	AssetType    AssetType   `json:"assetType"`
 	AlgoKey      string      `json:"algo"`
 	Certified    bool        `json:"certified"`
+	CreationDate int64       `json:"creationDate"`
 	Creator      string      `json:"creator"`
 	Dataset      *TtDataset  `json:"dataset"`
-	Log          string      `json:"log"`
+	Log          string      `json:"log" log:"truncate"`
 	Model        *Model      `json:"model"`
 	ObjectiveKey string      `json:"objective"`
 	Permissions  Permissions `json:"permissions"`
+	Priority     int         `json:"priority"`
+	StartDate    int64       `json:"startDate"`
 	Status       string      `json:"status"`
 	Tag          string      `json:"tag"`
 }
 
+// ComputePlan is the representation of one of the element type stored in the ledger.
+// The keys of its tuples are not stored with it but read from the indexes, so that
+// appending tuples doesn't rewrite the record: the traintuples are referenced by the
+// ID given by the user to be able to append new tuples later on, or by their key when
+// added with createTraintuple. TraintupleKeysByID only holds the traintuples added by
+// the current transaction.
+type ComputePlan struct {
+	AssetType          AssetType         `json:"assetType"`
+	AlgoKey            string            `json:"algoKey"`
+	Creator            string            `json:"creator"`
+	ObjectiveKey       string            `json:"objectiveKey"`
+	TraintupleKeys     []string          `json:"-"`
+	TraintupleKeysByID map[string]string `json:"-"`
+	TesttupleKeys      []string          `json:"-"`
+	MaxDuration        int64             `json:"maxDuration"`
+}
+
 // ---------------------------------------------------------------------------------
 // Struct used in the representation of elements stored in the ledger
 // ---------------------------------------------------------------------------------
@@ -112,21 +179,21 @@ type Testtuple struct {
 // HashDress stores a hash and a Storage Address
 type HashDress struct {
 	Hash           string `json:"hash"`
-	StorageAddress string `json:"storageAddress"`
+	StorageAddress string `json:"storageAddress" log:"sensitive"`
 }
 
 // HashDressName stores a hash, storage address and a name
 type HashDressName struct {
 	Name           string `json:"name"`
 	Hash           string `json:"hash"`
-	StorageAddress string `json:"storageAddress"`
+	StorageAddress string `json:"storageAddress" log:"sensitive"`
 }
 
 // Model stores the traintupleKey leading to the model, its hash and storage addressl
 type Model struct {
 	TraintupleKey  string `json:"traintupleKey"`
 	Hash           string `json:"hash"`
-	StorageAddress string `json:"storageAddress"`
+	StorageAddress string `json:"storageAddress" log:"sensitive"`
 }
 
 // Dataset stores info about a dataManagerKey and a list of associated dataSample
@@ -159,3 +226,56 @@ type TtObjective struct {
 type Node struct {
 	ID string `json:"id"`
 }
+
+// NodeGroup is a named set of nodes which can be referenced in the permissions
+type NodeGroup struct {
+	AssetType AssetType `json:"assetType"`
+	Name      string    `json:"name"`
+	Owner     string    `json:"owner"`
+	NodeIDs   []string  `json:"nodeIDs"`
+}
+
+// Quota limits the tuples a node can submit on the data of a dataManager. It is
+// set by the owner of the dataManager. A zero maximum means there is no limit.
+type Quota struct {
+	AssetType       AssetType `json:"assetType"`
+	DataManagerKey  string    `json:"dataManagerKey"`
+	NodeID          string    `json:"nodeID"`
+	Owner           string    `json:"owner"`
+	MaxActiveTuples int       `json:"maxActiveTuples"`
+	MaxTuplesPerDay int       `json:"maxTuplesPerDay"`
+}
+
+// QuotaUsage is the usage of a quota: the active tuples, i.e. todo or doing,
+// and the tuples submitted on Day
+type QuotaUsage struct {
+	AssetType    AssetType `json:"assetType"`
+	ActiveTuples int       `json:"activeTuples"`
+	Day          int64     `json:"day"`
+	DayTuples    int       `json:"dayTuples"`
+}
+
+// Stats is a shard of the counters of a scope of tuples: all the tuples, the
+// ones of an objective or the ones of a compute plan. A shard only holds the
+// updates of some transactions, so its counts can be negative; the counts of
+// the scope are the sums of its shards.
+type Stats struct {
+	AssetType   AssetType   `json:"assetType"`
+	Scope       string      `json:"scope"`
+	Traintuples TupleCounts `json:"traintuples"`
+	Testtuples  TupleCounts `json:"testtuples"`
+}
+
+// TupleCounts counts tuples in total, by status and by worker
+type TupleCounts struct {
+	Total    int            `json:"total"`
+	ByStatus map[string]int `json:"byStatus"`
+	ByWorker map[string]int `json:"byWorker"`
+}
+
+// Settings are the chaincode settings given to Init. They are stored so that
+// every peer running the chaincode applies them.
+type Settings struct {
+	AssetType AssetType `json:"assetType"`
+	LogLevel  string    `json:"logLevel"`
+}
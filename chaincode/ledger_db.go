@@ -17,7 +17,7 @@ package main
 import (
 	"chaincode/errors"
 	"encoding/json"
-	"fmt"
+	"reflect"
 	"sync"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
@@ -26,6 +26,10 @@ import (
 // State is a in-memory representation of the db state
 type State struct {
 	items map[string]([]byte)
+	// decoded holds the objects decoded by the typed getters during the
+	// transaction, so that an asset used by many others, such as the algo of
+	// a compute plan, is unmarshaled only once. It is nil when disabled.
+	decoded map[string]interface{}
 }
 
 // LedgerDB to access the chaincode database during the lifetime of a SmartContract
@@ -40,7 +44,8 @@ func NewLedgerDB(stub shim.ChaincodeStubInterface) LedgerDB {
 	return LedgerDB{
 		cc: stub,
 		transactionState: State{
-			items: make(map[string]([]byte)),
+			items:   make(map[string]([]byte)),
+			decoded: make(map[string]interface{}),
 		},
 		mutex: &sync.RWMutex{},
 	}
@@ -63,11 +68,21 @@ func (db *LedgerDB) getTransactionState(key string) ([]byte, bool) {
 	return state, true
 }
 
-// putTransactionState stores an object during a transaction lifetime
+// hasTransactionState checks if an object has been read, updated or created during the transaction
+func (db *LedgerDB) hasTransactionState(key string) bool {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	_, ok := db.transactionState.items[key]
+	return ok
+}
+
+// putTransactionState stores an object during a transaction lifetime. The
+// object decoded from its previous state, if any, is dropped.
 func (db *LedgerDB) putTransactionState(key string, state []byte) {
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
 	db.transactionState.items[key] = state
+	delete(db.transactionState.decoded, key)
 }
 
 // Get retrieves an object stored in the chaincode db and set the input object value
@@ -78,8 +93,11 @@ func (db *LedgerDB) Get(key string, object interface{}) error {
 	buff, ok := db.getTransactionState(key)
 	if !ok {
 		buff, err = db.cc.GetState(key)
-		if err != nil || buff == nil {
-			return errors.NotFound(err)
+		if err != nil {
+			return errors.NotFound(errors.CodeAssetNotFound, err)
+		}
+		if buff == nil {
+			return errors.NotFound(errors.CodeAssetNotFound, "no asset with key %s", key)
 		}
 		db.putTransactionState(key, buff)
 	}
@@ -90,8 +108,99 @@ func (db *LedgerDB) Get(key string, object interface{}) error {
 	return nil
 }
 
-// KeyExists checks if a key is stored in the chaincode db
+// getDecoded sets object, a pointer to a struct, like Get but decodes the
+// object only once per transaction. The object is a deep copy of the decoded
+// one, so that the callers can modify it, its slices, maps and pointers
+// without affecting the following calls.
+func (db *LedgerDB) getDecoded(key string, object interface{}) error {
+	target := reflect.ValueOf(object).Elem()
+	db.mutex.RLock()
+	decoded, ok := db.transactionState.decoded[key]
+	db.mutex.RUnlock()
+	if ok && reflect.TypeOf(decoded) == target.Type() {
+		target.Set(deepCopy(reflect.ValueOf(decoded)))
+		return nil
+	}
+	if err := db.Get(key, object); err != nil {
+		return err
+	}
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if db.transactionState.decoded != nil {
+		db.transactionState.decoded[key] = deepCopy(target).Interface()
+	}
+	return nil
+}
+
+// deepCopy returns a copy of value which doesn't share its slices, maps and
+// pointers. The unexported fields of the structs are copied as is since they
+// are not decoded from the ledger.
+func deepCopy(value reflect.Value) reflect.Value {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.New(value.Type().Elem())
+		copied.Elem().Set(deepCopy(value.Elem()))
+		return copied
+	case reflect.Interface:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.New(value.Type()).Elem()
+		copied.Set(deepCopy(value.Elem()))
+		return copied
+	case reflect.Struct:
+		copied := reflect.New(value.Type()).Elem()
+		copied.Set(value)
+		for i := 0; i < value.NumField(); i++ {
+			if field := copied.Field(i); field.CanSet() && hasReferences(field.Kind()) {
+				field.Set(deepCopy(value.Field(i)))
+			}
+		}
+		return copied
+	case reflect.Slice:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		reflect.Copy(copied, value)
+		if hasReferences(value.Type().Elem().Kind()) {
+			for i := 0; i < value.Len(); i++ {
+				copied.Index(i).Set(deepCopy(value.Index(i)))
+			}
+		}
+		return copied
+	case reflect.Map:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.MakeMapWithSize(value.Type(), value.Len())
+		for _, k := range value.MapKeys() {
+			copied.SetMapIndex(k, deepCopy(value.MapIndex(k)))
+		}
+		return copied
+	default:
+		return value
+	}
+}
+
+// hasReferences checks if the values of a kind can share memory once copied
+func hasReferences(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Ptr, reflect.Interface, reflect.Struct, reflect.Slice, reflect.Map:
+		return true
+	}
+	return false
+}
+
+// KeyExists checks if a key is stored in the chaincode db, including the
+// objects created earlier in the transaction
 func (db *LedgerDB) KeyExists(key string) (bool, error) {
+	if db.hasTransactionState(key) {
+		return true, nil
+	}
 	buff, err := db.cc.GetState(key)
 	return buff != nil, err
 }
@@ -131,11 +240,11 @@ func (db *LedgerDB) Add(key string, object interface{}) error {
 func (db *LedgerDB) CreateIndex(index string, attributes []string) error {
 	compositeKey, err := db.cc.CreateCompositeKey(index, attributes)
 	if err != nil {
-		return fmt.Errorf("cannot create index %s: %s", index, err.Error())
+		return errors.Internal(err, "cannot create index %s:", index)
 	}
 	value := []byte{0x00}
 	if err = db.cc.PutState(compositeKey, value); err != nil {
-		return fmt.Errorf("cannot create index %s: %s", index, err.Error())
+		return errors.Internal(err, "cannot create index %s:", index)
 	}
 	return nil
 }
@@ -168,7 +277,7 @@ func (db *LedgerDB) GetIndexKeys(index string, attributes []string) ([]string, e
 	keys := make([]string, 0)
 	iterator, err := db.cc.GetStateByPartialCompositeKey(index, attributes)
 	if err != nil {
-		return nil, fmt.Errorf("get index %s failed: %s", index, err.Error())
+		return nil, errors.Internal(err, "get index %s failed:", index)
 	}
 	defer iterator.Close()
 	for iterator.HasNext() {
@@ -178,7 +287,7 @@ func (db *LedgerDB) GetIndexKeys(index string, attributes []string) ([]string, e
 		}
 		_, keyParts, err := db.cc.SplitCompositeKey(compositeKey.Key)
 		if err != nil {
-			return nil, fmt.Errorf("get index %s failed: cannot split key %s: %s", index, compositeKey.Key, err.Error())
+			return nil, errors.Internal(err, "get index %s failed: cannot split key %s:", index, compositeKey.Key)
 		}
 		keys = append(keys, keyParts[len(keyParts)-1])
 	}
@@ -192,11 +301,11 @@ func (db *LedgerDB) GetIndexKeys(index string, attributes []string) ([]string, e
 // GetAlgo fetches an Algo from the ledger using its unique key
 func (db *LedgerDB) GetAlgo(key string) (Algo, error) {
 	algo := Algo{}
-	if err := db.Get(key, &algo); err != nil {
+	if err := db.getDecoded(key, &algo); err != nil {
 		return algo, err
 	}
 	if algo.AssetType != AlgoType {
-		return algo, errors.NotFound("algo %s not found", key)
+		return algo, errors.NotFound(errors.CodeAssetNotFound, "algo %s not found", key)
 	}
 	return algo, nil
 }
@@ -204,11 +313,11 @@ func (db *LedgerDB) GetAlgo(key string) (Algo, error) {
 // GetObjective fetches an Objective from the ledger using its unique key
 func (db *LedgerDB) GetObjective(key string) (Objective, error) {
 	objective := Objective{}
-	if err := db.Get(key, &objective); err != nil {
+	if err := db.getDecoded(key, &objective); err != nil {
 		return objective, err
 	}
 	if objective.AssetType != ObjectiveType {
-		return objective, errors.NotFound("objective %s not found", key)
+		return objective, errors.NotFound(errors.CodeAssetNotFound, "objective %s not found", key)
 	}
 	return objective, nil
 }
@@ -216,11 +325,11 @@ func (db *LedgerDB) GetObjective(key string) (Objective, error) {
 // GetDataManager fetches a DataManager from the ledger using its unique key
 func (db *LedgerDB) GetDataManager(key string) (DataManager, error) {
 	dataManager := DataManager{}
-	if err := db.Get(key, &dataManager); err != nil {
+	if err := db.getDecoded(key, &dataManager); err != nil {
 		return dataManager, err
 	}
 	if dataManager.AssetType != DataManagerType {
-		return dataManager, errors.NotFound("dataManager %s not found", key)
+		return dataManager, errors.NotFound(errors.CodeAssetNotFound, "dataManager %s not found", key)
 	}
 	return dataManager, nil
 }
@@ -228,23 +337,47 @@ func (db *LedgerDB) GetDataManager(key string) (DataManager, error) {
 // GetDataSample fetches a DataSample from the ledger using its unique key
 func (db *LedgerDB) GetDataSample(key string) (DataSample, error) {
 	dataSample := DataSample{}
-	if err := db.Get(key, &dataSample); err != nil {
+	if err := db.getDecoded(key, &dataSample); err != nil {
 		return dataSample, err
 	}
 	if dataSample.AssetType != DataSampleType {
-		return dataSample, errors.NotFound("dataSample %s not found", key)
+		return dataSample, errors.NotFound(errors.CodeAssetNotFound, "dataSample %s not found", key)
 	}
 	return dataSample, nil
 }
 
+// GetDatasetSplit fetches a DatasetSplit from the ledger using its unique key
+func (db *LedgerDB) GetDatasetSplit(key string) (DatasetSplit, error) {
+	datasetSplit := DatasetSplit{}
+	if err := db.getDecoded(key, &datasetSplit); err != nil {
+		return datasetSplit, err
+	}
+	if datasetSplit.AssetType != DatasetSplitType {
+		return datasetSplit, errors.NotFound(errors.CodeAssetNotFound, "dataset split %s not found", key)
+	}
+	return datasetSplit, nil
+}
+
+// GetPermissionsUpdate fetches a PermissionsUpdate from the ledger using its unique key
+func (db *LedgerDB) GetPermissionsUpdate(key string) (PermissionsUpdate, error) {
+	permissionsUpdate := PermissionsUpdate{}
+	if err := db.getDecoded(key, &permissionsUpdate); err != nil {
+		return permissionsUpdate, err
+	}
+	if permissionsUpdate.AssetType != PermissionsUpdateType {
+		return permissionsUpdate, errors.NotFound(errors.CodeAssetNotFound, "permissions update %s not found", key)
+	}
+	return permissionsUpdate, nil
+}
+
 // GetTraintuple fetches a Traintuple from the ledger using its unique key
 func (db *LedgerDB) GetTraintuple(key string) (Traintuple, error) {
 	traintuple := Traintuple{}
-	if err := db.Get(key, &traintuple); err != nil {
+	if err := db.getDecoded(key, &traintuple); err != nil {
 		return traintuple, err
 	}
 	if traintuple.AssetType != TraintupleType {
-		return traintuple, errors.NotFound("traintuple %s not found", key)
+		return traintuple, errors.NotFound(errors.CodeAssetNotFound, "traintuple %s not found", key)
 	}
 	return traintuple, nil
 }
@@ -252,23 +385,99 @@ func (db *LedgerDB) GetTraintuple(key string) (Traintuple, error) {
 // GetTesttuple fetches a Testtuple from the ledger using its unique key
 func (db *LedgerDB) GetTesttuple(key string) (Testtuple, error) {
 	testtuple := Testtuple{}
-	if err := db.Get(key, &testtuple); err != nil {
+	if err := db.getDecoded(key, &testtuple); err != nil {
 		return testtuple, err
 	}
 	if testtuple.AssetType != TesttupleType {
-		return testtuple, errors.NotFound("testtuple %s not found", key)
+		return testtuple, errors.NotFound(errors.CodeAssetNotFound, "testtuple %s not found", key)
 	}
 	return testtuple, nil
 }
 
+// GetComputePlan fetches a ComputePlan from the ledger using its ID
+func (db *LedgerDB) GetComputePlan(computePlanID string) (ComputePlan, error) {
+	computePlan := ComputePlan{}
+	if err := db.getDecoded(getComputePlanKey(computePlanID), &computePlan); err != nil {
+		return computePlan, err
+	}
+	if computePlan.AssetType != ComputePlanType {
+		return computePlan, errors.NotFound(errors.CodeAssetNotFound, "compute plan %s not found", computePlanID)
+	}
+	return computePlan, nil
+}
+
+// GetNodeGroup fetches a NodeGroup from the ledger using its name
+func (db *LedgerDB) GetNodeGroup(name string) (NodeGroup, error) {
+	nodeGroup := NodeGroup{}
+	if err := db.getDecoded(getNodeGroupKey(name), &nodeGroup); err != nil {
+		return nodeGroup, err
+	}
+	if nodeGroup.AssetType != NodeGroupType {
+		return nodeGroup, errors.NotFound(errors.CodeAssetNotFound, "node group %s not found", name)
+	}
+	return nodeGroup, nil
+}
+
+// GetQuota fetches the Quota of a node on a dataManager from the ledger
+func (db *LedgerDB) GetQuota(dataManagerKey, nodeID string) (Quota, error) {
+	quota := Quota{}
+	if err := db.getDecoded(getQuotaKey(dataManagerKey, nodeID), &quota); err != nil {
+		return quota, err
+	}
+	if quota.AssetType != QuotaType {
+		return quota, errors.NotFound(errors.CodeAssetNotFound, "quota of node %s on dataManager %s not found", nodeID, dataManagerKey)
+	}
+	return quota, nil
+}
+
+// GetQuotaUsage fetches the usage of the quota of a node on a dataManager
+func (db *LedgerDB) GetQuotaUsage(dataManagerKey, nodeID string) (QuotaUsage, error) {
+	usage := QuotaUsage{}
+	if err := db.getDecoded(getQuotaUsageKey(dataManagerKey, nodeID), &usage); err != nil {
+		return usage, err
+	}
+	if usage.AssetType != QuotaUsageType {
+		return usage, errors.NotFound(errors.CodeAssetNotFound, "quota usage of node %s on dataManager %s not found", nodeID, dataManagerKey)
+	}
+	return usage, nil
+}
+
+// GetStats fetches a shard of the Stats of a scope from the ledger
+func (db *LedgerDB) GetStats(scope string, shard int) (Stats, error) {
+	stats := Stats{}
+	if err := db.getDecoded(getStatsKey(scope, shard), &stats); err != nil {
+		return stats, err
+	}
+	if stats.AssetType != StatsType {
+		return stats, errors.NotFound(errors.CodeAssetNotFound, "stats of %s not found", scope)
+	}
+	return stats, nil
+}
+
+// GetSettings fetches the chaincode Settings from the ledger
+func (db *LedgerDB) GetSettings() (Settings, error) {
+	settings := Settings{}
+	if err := db.getDecoded(settingsKey, &settings); err != nil {
+		return settings, err
+	}
+	if settings.AssetType != SettingsType {
+		return settings, errors.NotFound(errors.CodeAssetNotFound, "chaincode settings not found")
+	}
+	return settings, nil
+}
+
 // GetNode fetches a Node from the ledger based on its unique key
 func (db *LedgerDB) GetNode(key string) (Node, error) {
 	node := Node{}
 
-	err := db.Get(key, &node)
+	err := db.getDecoded(key, &node)
 	if err != nil {
 		return node, err
 	}
+	// nodes have no asset type, they are stored under their ID
+	if node.ID != key {
+		return node, errors.NotFound(errors.CodeAssetNotFound, "node %s not found", key)
+	}
 
 	return node, nil
 }
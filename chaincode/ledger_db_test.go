@@ -0,0 +1,128 @@
+// Copyright 2018 Owkin, inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"chaincode/errors"
+	stderrors "errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodedCache(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	mockStub.MockTransactionStart("42")
+	defer mockStub.MockTransactionEnd("42")
+	db := NewLedgerDB(mockStub)
+
+	algo := Algo{AssetType: AlgoType, Name: "algo", Description: &HashDress{Hash: algoHash}}
+	algo.Permissions.Process.AuthorizedIDs = make([]string, 1, 2)
+	algo.Permissions.Process.AuthorizedIDs[0] = worker
+	require.NoError(t, db.Put(algoHash, algo))
+	out, err := db.GetAlgo(algoHash)
+	require.NoError(t, err)
+	assert.Equal(t, algo, out)
+	assert.Equal(t, out, db.transactionState.decoded[algoHash])
+	out, err = db.GetAlgo(algoHash)
+	require.NoError(t, err)
+	assert.Equal(t, algo, out)
+
+	// The slices and pointers of the decoded object are not shared
+	out.Description.Hash = "modified"
+	out.Permissions.Process.AuthorizedIDs[0] = "modified"
+	out.Permissions.Process.AuthorizedIDs = append(out.Permissions.Process.AuthorizedIDs, "appended")
+	other, err := db.GetAlgo(algoHash)
+	require.NoError(t, err)
+	assert.Equal(t, algo, other)
+	other.Permissions.Process.AuthorizedIDs = append(other.Permissions.Process.AuthorizedIDs, "other")
+	assert.Equal(t, []string{"modified", "appended"}, out.Permissions.Process.AuthorizedIDs)
+
+	// The decoded object of another type is not used
+	_, err = db.GetObjective(algoHash)
+	assert.True(t, stderrors.Is(err, errors.NotFound()))
+	out, err = db.GetAlgo(algoHash)
+	require.NoError(t, err)
+	assert.Equal(t, algo, out)
+
+	// Putting an object drops the decoded one
+	algo.Name = "renamed algo"
+	require.NoError(t, db.Put(algoHash, algo))
+	assert.NotContains(t, db.transactionState.decoded, algoHash)
+	out, err = db.GetAlgo(algoHash)
+	require.NoError(t, err)
+	assert.Equal(t, "renamed algo", out.Name)
+}
+
+func TestKeyExistsInTransaction(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStub("substra", scc)
+	db := NewLedgerDB(mockStub)
+
+	exists, err := db.KeyExists(algoHash)
+	require.NoError(t, err)
+	assert.False(t, exists)
+	db.putTransactionState(algoHash, []byte(`{}`))
+	exists, err = db.KeyExists(algoHash)
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+// BenchmarkQueryTraintuples measures the queries of the traintuples of a large
+// compute plan, whose traintuples share the same algo and objective, with and
+// without the cache of the decoded objects
+func BenchmarkQueryTraintuples(b *testing.B) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(b, *mockStub, "algo")
+
+	const size = 500
+	inpCP := inputComputePlan{AlgoKey: algoHash, ObjectiveKey: objectiveDescriptionHash}
+	for i := 0; i < size; i++ {
+		traintuple := inputComputePlanTraintuple{
+			DataManagerKey: dataManagerOpenerHash,
+			DataSampleKeys: []string{trainDataSampleHash1},
+			ID:             fmt.Sprintf("traintuple%d", i),
+		}
+		if i > 0 {
+			traintuple.InModelsIDs = []string{fmt.Sprintf("traintuple%d", i-1)}
+		}
+		inpCP.Traintuples = append(inpCP.Traintuples, traintuple)
+	}
+	resp := mockStub.MockInvoke("42", methodAndAssetToByte("createComputePlan", inpCP))
+	require.EqualValues(b, 200, resp.Status, resp.Message)
+
+	for _, cached := range []bool{true, false} {
+		b.Run(fmt.Sprintf("cached=%t", cached), func(b *testing.B) {
+			mockStub.MockTransactionStart("43")
+			defer mockStub.MockTransactionEnd("43")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// each query runs in a new transaction
+				db := NewLedgerDB(mockStub)
+				if !cached {
+					db.transactionState.decoded = nil
+				}
+				out, err := queryTraintuples(db)
+				if err != nil || len(out) != size {
+					b.Fatalf("got %d traintuples and error %v", len(out), err)
+				}
+			}
+		})
+	}
+}
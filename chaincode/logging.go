@@ -0,0 +1,215 @@
+// Copyright 2018 Owkin, inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"chaincode/errors"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// logLevelEnv is the environment variable setting the level of the chaincode
+// logs. The level of the shim is used when it is not set, the one given to Init
+// takes precedence.
+const logLevelEnv = "SUBSTRA_CHAINCODE_LOG_LEVEL"
+
+// settingsKey is the key of the chaincode settings given to Init
+var settingsKey = HashForKey("settings")
+
+// storedLogLevel records if the container applied the level given to Init, so
+// that the settings are only read by its first transaction
+var storedLogLevel struct {
+	sync.Mutex
+	applied bool
+}
+
+const (
+	// maxLoggedPayloadLength is the length above which args and payloads are
+	// truncated in the logs
+	maxLoggedPayloadLength = 2048
+	// maxLoggedFieldLength is the length above which the fields tagged with
+	// log:"truncate" are truncated in the logs
+	maxLoggedFieldLength = 64
+	// redacted replaces the value of the fields tagged with log:"sensitive"
+	redacted = "[REDACTED]"
+)
+
+// setLogLevel sets the level of the chaincode logs from a case-insensitive
+// name such as DEBUG or INFO
+func setLogLevel(level string) error {
+	logLevel, err := shim.LogLevel(level)
+	if err != nil {
+		return errors.BadRequest("invalid log level %s, expecting CRITICAL, ERROR, WARNING, NOTICE, INFO or DEBUG", level)
+	}
+	logger.SetLevel(logLevel)
+	return nil
+}
+
+// storeLogLevel sets the level of the chaincode logs and stores it in the
+// ledger, so that the containers of the peers which didn't run Init apply it
+// as well
+func storeLogLevel(db LedgerDB, level string) error {
+	if err := setLogLevel(level); err != nil {
+		return err
+	}
+	storedLogLevel.Lock()
+	defer storedLogLevel.Unlock()
+	storedLogLevel.applied = true
+	settings := Settings{AssetType: SettingsType, LogLevel: level}
+	return db.Put(settingsKey, settings)
+}
+
+// applyStoredLogLevel sets the level of the chaincode logs to the one given to
+// Init, if any. The settings are read once per container: Init is only run
+// when the chaincode is instantiated or upgraded, which starts new containers.
+func applyStoredLogLevel(db LedgerDB) {
+	storedLogLevel.Lock()
+	defer storedLogLevel.Unlock()
+	if storedLogLevel.applied {
+		return
+	}
+	settings, err := db.GetSettings()
+	if err != nil && !stderrors.Is(err, errors.NotFound()) {
+		logger.Warningf("could not read the chaincode settings: %s", err)
+		return
+	}
+	storedLogLevel.applied = true
+	if err == nil {
+		if err := setLogLevel(settings.LogLevel); err != nil {
+			logger.Warning(err)
+		}
+	}
+}
+
+// logField is a key and its value in a structured log line
+type logField struct {
+	key   string
+	value interface{}
+}
+
+// logLine is a structured log line, formatted as space separated key=value
+// pairs. The values containing spaces, quotes or equal signs are quoted.
+type logLine []logField
+
+func (line logLine) String() string {
+	fields := make([]string, 0, len(line))
+	for _, field := range line {
+		value := fmt.Sprint(field.value)
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		fields = append(fields, field.key+"="+value)
+	}
+	return strings.Join(fields, " ")
+}
+
+// logRequest logs the args received by the chaincode at the debug level
+func logRequest(stub shim.ChaincodeStubInterface, fn string, inputType reflect.Type, args []string) {
+	if !logger.IsEnabledFor(shim.LogDebug) {
+		return
+	}
+	loggedArgs := make([]string, len(args))
+	for i, arg := range args {
+		loggedArgs[i] = redactPayload(inputType, arg)
+	}
+	logger.Debug(logLine{
+		{"txID", stub.GetTxID()},
+		{"function", fn},
+		{"args", strings.Join(loggedArgs, " ")},
+	})
+}
+
+// logResponse logs the status, error code and duration of a transaction. The
+// error and the payload are only logged at the debug level.
+func logResponse(stub shim.ChaincodeStubInterface, fn string, outputType reflect.Type, resp peer.Response, err error, duration time.Duration) {
+	line := logLine{
+		{"txID", stub.GetTxID()},
+		{"function", fn},
+		{"status", resp.Status},
+		{"duration", duration},
+	}
+	if err != nil {
+		line = append(line, logField{"code", errors.Wrap(err).Code()})
+	}
+	switch {
+	case resp.Status >= 500:
+		logger.Error(line)
+	case resp.Status >= 400:
+		logger.Warning(line)
+	default:
+		logger.Info(line)
+	}
+	if !logger.IsEnabledFor(shim.LogDebug) {
+		return
+	}
+	if err != nil {
+		logger.Debug(logLine{{"txID", stub.GetTxID()}, {"error", truncate(err.Error(), maxLoggedPayloadLength)}})
+		return
+	}
+	logger.Debug(logLine{{"txID", stub.GetTxID()}, {"payload", redactPayload(outputType, string(resp.Payload))}})
+}
+
+// redactPayload returns the json payload of a value of type t as it should be
+// logged: the fields tagged with log:"sensitive" are redacted, the ones tagged
+// with log:"truncate" are truncated, and so is the whole payload if too long.
+// Payloads which are not valid json are only truncated.
+func redactPayload(t reflect.Type, payload string) string {
+	var data interface{}
+	if t != nil && decodeJSON([]byte(payload), &data) == nil {
+		walkJSON(t, data, func(t reflect.Type, obj map[string]interface{}) error {
+			for _, field := range jsonFields(t) {
+				value, ok := obj[field.name]
+				if !ok || value == nil || value == "" {
+					continue
+				}
+				switch field.Tag.Get("log") {
+				case "sensitive":
+					obj[field.name] = redacted
+				case "truncate":
+					if s, ok := value.(string); ok {
+						obj[field.name] = truncate(s, maxLoggedFieldLength)
+					}
+				}
+			}
+			return nil
+		})
+		if b, err := json.Marshal(data); err == nil {
+			payload = string(b)
+		}
+	}
+	return truncate(payload, maxLoggedPayloadLength)
+}
+
+// truncate shortens a string to at most max bytes, without splitting a
+// character, and tells how many bytes were removed
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return fmt.Sprintf("%s...(%d more bytes)", s[:max], len(s)-max)
+}
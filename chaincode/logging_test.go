@@ -0,0 +1,103 @@
+// Copyright 2018 Owkin, inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/op/go-logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogLine(t *testing.T) {
+	line := logLine{
+		{"txID", "42"},
+		{"function", "queryAlgo"},
+		{"duration", 1500 * time.Microsecond},
+		{"error", `algo "abc" not found`},
+		{"code", ""},
+	}
+	assert.Equal(t, `txID=42 function=queryAlgo duration=1.5ms error="algo \"abc\" not found" code=""`, line.String())
+}
+
+func TestRedactPayload(t *testing.T) {
+	inp := inputAlgo{}
+	inp.createDefault()
+	arg, err := json.Marshal(inp)
+	require.NoError(t, err)
+	logged := redactPayload(reflect.TypeOf(inp), string(arg))
+	assert.NotContains(t, logged, inp.StorageAddress)
+	assert.NotContains(t, logged, inp.DescriptionStorageAddress)
+	assert.Contains(t, logged, inp.Hash)
+	assert.Contains(t, logged, redacted)
+
+	// Nested and listed structs are redacted too
+	out := []outputTraintuple{{
+		Algo: &HashDressName{Hash: algoHash, StorageAddress: "https://algo.org"},
+		Log:  strings.Repeat("a", 1000),
+	}}
+	payload, err := json.Marshal(out)
+	require.NoError(t, err)
+	logged = redactPayload(reflect.TypeOf(out), string(payload))
+	assert.NotContains(t, logged, "https://algo.org")
+	assert.Contains(t, logged, algoHash)
+	assert.Contains(t, logged, strings.Repeat("a", maxLoggedFieldLength)+"...(936 more bytes)")
+
+	// Unknown or invalid payloads are only truncated
+	long := strings.Repeat("é", maxLoggedPayloadLength)
+	logged = redactPayload(nil, long)
+	assert.True(t, strings.HasPrefix(logged, strings.Repeat("é", maxLoggedPayloadLength/2)+"..."), logged[:10])
+	assert.Contains(t, logged, "more bytes")
+}
+
+func TestInitLogLevel(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStub("substra", scc)
+	defer logger.SetLevel(shim.LoggingLevel(logging.GetLevel("substra-chaincode")))
+
+	resp := mockStub.MockInit("42", [][]byte{[]byte("init"), []byte(`{"logLevel": "debug"}`)})
+	assert.EqualValues(t, 200, resp.Status, resp.Message)
+	assert.True(t, logger.IsEnabledFor(shim.LogDebug))
+
+	resp = mockStub.MockInit("42", [][]byte{[]byte("init"), []byte(`{"logLevel": "WARNING"}`)})
+	assert.EqualValues(t, 200, resp.Status, resp.Message)
+	assert.False(t, logger.IsEnabledFor(shim.LogInfo))
+	assert.True(t, logger.IsEnabledFor(shim.LogWarning))
+
+	// The containers which didn't run Init apply the stored level on their
+	// first Invoke only
+	storedLogLevel.applied = false
+	logger.SetLevel(shim.LogDebug)
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("queryNodes")})
+	assert.EqualValues(t, 200, resp.Status, resp.Message)
+	assert.False(t, logger.IsEnabledFor(shim.LogInfo))
+	assert.True(t, logger.IsEnabledFor(shim.LogWarning))
+	logger.SetLevel(shim.LogDebug)
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("queryNodes")})
+	assert.EqualValues(t, 200, resp.Status, resp.Message)
+	assert.True(t, logger.IsEnabledFor(shim.LogDebug))
+	logger.SetLevel(shim.LogWarning)
+
+	resp = mockStub.MockInit("42", [][]byte{[]byte("init"), []byte(`{"logLevel": "verbose"}`)})
+	assert.EqualValues(t, 400, resp.Status, resp.Message)
+	assert.True(t, logger.IsEnabledFor(shim.LogWarning))
+	assert.False(t, logger.IsEnabledFor(shim.LogInfo))
+}
@@ -18,6 +18,9 @@ import (
 	"chaincode/errors"
 	"encoding/json"
 	"fmt"
+	"os"
+	"reflect"
+	"time"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"github.com/hyperledger/fabric/protos/peer"
@@ -27,116 +30,80 @@ import (
 type SubstraChaincode struct {
 }
 
-// Create a global logger for the chaincode. Its default level is Info
+// Create a global logger for the chaincode. Its level is the one of the shim
+// unless set with the SUBSTRA_CHAINCODE_LOG_LEVEL environment variable or the
+// Init args. The level given to Init takes precedence over the environment
+// variable: it is stored in the ledger and applied by the first Invoke of the
+// containers which didn't run Init.
 var logger = shim.NewLogger("substra-chaincode")
 
 // Init is called during chaincode instantiation to initialize any
 // data. Note that chaincode upgrade also calls this function to reset
-// or to migrate data.
-// TODO!!!!
+// or to migrate data: the indexes added since the previous version are
+// created for the assets already in the ledger.
+// It takes optional settings as a json arg, such as {"logLevel": "DEBUG"}.
 func (t *SubstraChaincode) Init(stub shim.ChaincodeStubInterface) peer.Response {
 	// Get the args from the transaction proposal
 	args := stub.GetStringArgs()
-	if len(args) != 1 {
-		return shim.Error("Incorrect arguments. Expecting nothing...")
+	if len(args) < 1 || len(args) > 2 {
+		return shim.Error("Incorrect arguments. Expecting nothing or the chaincode settings...")
+	}
+	if len(args) == 2 {
+		inp := inputInit{}
+		if err := AssetFromJSON(args[1:], &inp); err != nil {
+			return formatErrorResponse(err)
+		}
+		if inp.LogLevel != "" {
+			if err := storeLogLevel(NewLedgerDB(stub), inp.LogLevel); err != nil {
+				return formatErrorResponse(err)
+			}
+		}
+	}
+	if err := migrateIndexes(NewLedgerDB(stub)); err != nil {
+		return formatErrorResponse(err)
 	}
 	return shim.Success(nil)
 }
 
 // Invoke is called per transaction on the chaincode.
 func (t *SubstraChaincode) Invoke(stub shim.ChaincodeStubInterface) peer.Response {
-	// Log all input for potential debug later on.
-	logger.Infof("Args received by the chaincode: %#v", stub.GetStringArgs())
+	start := time.Now()
 
 	// Extract the function and args from the transaction proposal
 	fn, args := stub.GetFunctionAndParameters()
-
-	db := NewLedgerDB(stub)
+	// Only the first transaction of the container reads the stored level
+	applyStoredLogLevel(NewLedgerDB(stub))
+	c, ok := contracts[fn]
+	// Log the input for potential debug later on
+	logRequest(stub, fn, c.input, args)
 
 	var result interface{}
 	var err error
-	switch fn {
-	case "createComputePlan":
-		result, err = createComputePlan(db, args)
-	case "createTesttuple":
-		result, err = createTesttuple(db, args)
-	case "createTraintuple":
-		result, err = createTraintuple(db, args)
-	case "logFailTest":
-		result, err = logFailTest(db, args)
-	case "logFailTrain":
-		result, err = logFailTrain(db, args)
-	case "logStartTest":
-		result, err = logStartTest(db, args)
-	case "logStartTrain":
-		result, err = logStartTrain(db, args)
-	case "logSuccessTest":
-		result, err = logSuccessTest(db, args)
-	case "logSuccessTrain":
-		result, err = logSuccessTrain(db, args)
-	case "queryAlgo":
-		result, err = queryAlgo(db, args)
-	case "queryAlgos":
-		result, err = queryAlgos(db, args)
-	case "queryDataManager":
-		result, err = queryDataManager(db, args)
-	case "queryDataManagers":
-		result, err = queryDataManagers(db, args)
-	case "queryDataSamples":
-		result, err = queryDataSamples(db, args)
-	case "queryDataset":
-		result, err = queryDataset(db, args)
-	case "queryFilter":
-		result, err = queryFilter(db, args)
-	case "queryModelDetails":
-		result, err = queryModelDetails(db, args)
-	case "queryModels":
-		result, err = queryModels(db, args)
-	case "queryObjective":
-		result, err = queryObjective(db, args)
-	case "queryObjectiveLeaderboard":
-		result, err = queryObjectiveLeaderboard(db, args)
-	case "queryObjectives":
-		result, err = queryObjectives(db, args)
-	case "queryTesttuple":
-		result, err = queryTesttuple(db, args)
-	case "queryTesttuples":
-		result, err = queryTesttuples(db, args)
-	case "queryTraintuple":
-		result, err = queryTraintuple(db, args)
-	case "queryTraintuples":
-		result, err = queryTraintuples(db, args)
-	case "registerAlgo":
-		result, err = registerAlgo(db, args)
-	case "registerDataManager":
-		result, err = registerDataManager(db, args)
-	case "registerDataSample":
-		result, err = registerDataSample(db, args)
-	case "registerObjective":
-		result, err = registerObjective(db, args)
-	case "updateDataManager":
-		result, err = updateDataManager(db, args)
-	case "updateDataSample":
-		result, err = updateDataSample(db, args)
-	case "registerNode":
-		result, err = registerNode(db, args)
-	case "queryNodes":
-		result, err = queryNodes(db, args)
-	default:
-		err = fmt.Errorf("function not implemented")
+	if ok {
+		result, err = c.invoke(stub, args)
+	} else {
+		err = errors.BadRequest(errors.CodeUnknownFunction, "function not implemented")
 	}
-	logger.Infof("Response from chaincode: %#v, error: %s", result, err)
-	// Return the result as success payload
-	if err != nil {
-		return formatErrorResponse(err)
+	var resp peer.Response
+	if err == nil {
+		// Marshal to json the smartcontract result
+		var payload []byte
+		payload, err = json.Marshal(result)
+		if err != nil {
+			err = errors.Internal("could not format response for unknown reason")
+		}
+		resp = shim.Success(payload)
 	}
-	// Marshal to json the smartcontract result
-	resp, err := json.Marshal(result)
 	if err != nil {
-		return formatErrorResponse(fmt.Errorf("could not format response for unknown reason"))
+		resp = formatErrorResponse(err)
 	}
 
-	return shim.Success(resp)
+	outputType := c.output
+	if outputType != nil && outputType.Kind() == reflect.Interface && result != nil {
+		outputType = reflect.TypeOf(result)
+	}
+	logResponse(stub, fn, outputType, resp, err, time.Since(start))
+	return resp
 }
 
 func formatErrorResponse(err error) peer.Response {
@@ -148,6 +115,10 @@ func formatErrorResponse(err error) peer.Response {
 		// Serialize status in the message until fabric-sdk-py allows subtrabac to
 		// access the status
 		"status": status,
+		"code":   e.Code(),
+	}
+	if causes := e.Causes(); len(causes) > 0 {
+		errStruct["causes"] = causes
 	}
 	for k, v := range e.GetContext() {
 		errStruct[k] = v
@@ -163,8 +134,13 @@ func formatErrorResponse(err error) peer.Response {
 
 // main function starts up the chaincode in the container during instantiate
 func main() {
-	// TODO use the same level as the shim or an env variable
-	logger.SetLevel(shim.LogDebug)
+	// Set up the shim logging first, it resets the level of all the loggers
+	shim.SetupChaincodeLogging()
+	if level := os.Getenv(logLevelEnv); level != "" {
+		if err := setLogLevel(level); err != nil {
+			logger.Warning(err)
+		}
+	}
 	if err := shim.Start(new(SubstraChaincode)); err != nil {
 		fmt.Printf("Error starting SubstraChaincode chaincode: %s", err)
 	}
@@ -25,6 +25,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	peer "github.com/hyperledger/fabric/protos/peer"
 	"github.com/stretchr/testify/assert"
@@ -75,15 +76,15 @@ func methodAndKeyToByte(key string, asset interface{}) [][]byte {
 	return [][]byte{[]byte("queryAlgo"), keyToJSON(key)}
 }
 
-func assetToArgs(asset interface{}) []string {
-	return []string{string(assetToJSON(asset))}
-}
-
 func assetToJSON(asset interface{}) []byte {
 	assetjson, _ := json.Marshal(asset)
 	return assetjson
 }
 
+func boolPtr(b bool) *bool {
+	return &b
+}
+
 func keyToJSON(key string) []byte {
 	return assetToJSON(inputHash{Key: key})
 }
@@ -129,6 +130,8 @@ func prettyPrintStructElements(buf io.Writer, margin string, strucType reflect.T
 				continue
 			}
 			fieldStr = fmt.Sprintf("[%s]", f.Type.Elem().Kind())
+		case reflect.Ptr:
+			fieldStr = fmt.Sprint(f.Type.Elem().Kind())
 		default:
 			fieldStr = fmt.Sprint(fieldType)
 		}
@@ -146,7 +149,7 @@ func printInputStuct(buf io.Writer, fnName string, inputType reflect.Type) {
 	fmt.Fprint(buf, "\n```\n")
 }
 
-func registerItem(t *testing.T, mockStub MockStub, itemType string) (peer.Response, interface{}) {
+func registerItem(t testing.TB, mockStub MockStub, itemType string) (peer.Response, interface{}) {
 	// 1. add dataManager
 	inpDataManager := inputDataManager{}
 	args := inpDataManager.createDefault()
@@ -159,7 +162,7 @@ func registerItem(t *testing.T, mockStub MockStub, itemType string) (peer.Respon
 	inpDataSample := inputDataSample{
 		Hashes:          []string{testDataSampleHash1, testDataSampleHash2},
 		DataManagerKeys: []string{dataManagerOpenerHash},
-		TestOnly:        "true",
+		TestOnly:        boolPtr(true),
 	}
 	args = inpDataSample.createDefault()
 	resp = mockStub.MockInvoke("42", args)
@@ -218,6 +221,8 @@ func printResp(buf io.Writer, payload []byte) {
 func TestPipeline(t *testing.T) {
 	scc := new(SubstraChaincode)
 	mockStub := NewMockStub("substra", scc)
+	// Use a fixed timestamp so that the examples don't change between runs
+	mockStub.FixedTxTimestamp = &timestamp.Timestamp{Seconds: 1570000000}
 	var out strings.Builder
 	callAssertAndPrint := func(peerCmd, smartContract string, inputAsset interface{}) peer.Response {
 		var args [][]byte
@@ -254,7 +259,7 @@ func TestPipeline(t *testing.T) {
 	fmt.Fprintln(&out, "#### ------------ Add test DataSample ------------")
 	inpDataSample := inputDataSample{
 		Hashes:   []string{testDataSampleHash1, testDataSampleHash2},
-		TestOnly: "true",
+		TestOnly: boolPtr(true),
 	}
 	inpDataSample.createDefault()
 	callAssertAndPrint("invoke", "registerDataSample", inpDataSample)
@@ -270,7 +275,15 @@ func TestPipeline(t *testing.T) {
 	callAssertAndPrint("invoke", "registerAlgo", inpAlgo)
 
 	fmt.Fprintln(&out, "#### ------------ Add Train DataSample ------------")
-	inpDataSample = inputDataSample{}
+	inpDataSample = inputDataSample{
+		Metadata: map[string]inputDataSampleMetadata{
+			trainDataSampleHash1: inputDataSampleMetadata{
+				Size:        1024,
+				RecordCount: 10,
+				Tags:        map[string]string{"center": "A"},
+			},
+		},
+	}
 	inpDataSample.createDefault()
 	callAssertAndPrint("invoke", "registerDataSample", inpDataSample)
 
@@ -427,7 +440,29 @@ func TestPipeline(t *testing.T) {
 	callAssertAndPrint("query", "queryDataset", inputHash{newDataManagerKey})
 
 	fmt.Fprintln(&out, "#### ------------ Create a ComputePlan ------------")
-	callAssertAndPrint("invoke", "createComputePlan", defaultComputePlan)
+	resp = callAssertAndPrint("invoke", "createComputePlan", defaultComputePlan)
+	outCP := outputComputePlan{}
+	err = json.Unmarshal(resp.Payload, &outCP)
+	assert.NoError(t, err, "should unmarshal without problem")
+
+	fmt.Fprintln(&out, "#### ------------ Update a ComputePlan ------------")
+	inpUpdateCP := inputUpdateComputePlan{
+		ComputePlanID: outCP.ComputePlanID,
+		Traintuples: []inputComputePlanTraintuple{
+			{
+				DataManagerKey: dataManagerOpenerHash,
+				DataSampleKeys: []string{trainDataSampleHash1},
+				ID:             "thirdTraintupleID",
+				InModelsIDs:    []string{traintupleID2},
+			},
+		},
+		Testtuples: []inputComputePlanTesttuple{
+			{
+				TraintupleID: "thirdTraintupleID",
+			},
+		},
+	}
+	callAssertAndPrint("invoke", "updateComputePlan", inpUpdateCP)
 
 	fmt.Fprintln(&out, "#### ------------ Query an ObjectiveLeaderboard ------------")
 	inpLeaderboard := inputLeaderboard{
@@ -436,6 +471,9 @@ func TestPipeline(t *testing.T) {
 	}
 	callAssertAndPrint("invoke", "queryObjectiveLeaderboard", inpLeaderboard)
 
+	fmt.Fprintln(&out, "#### ------------ Query the Stats of a ComputePlan ------------")
+	callAssertAndPrint("query", "queryStats", inputQueryStats{ComputePlanID: outCP.ComputePlanID})
+
 	// Use the output to check the README file and if asked update it
 	doc := out.String()
 	fromFile, err := ioutil.ReadFile(*readme)
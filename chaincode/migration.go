@@ -0,0 +1,82 @@
+// Copyright 2018 Owkin, inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// migrateIndexes creates the indexes added to the chaincode after some assets
+// were stored, so that the checks and the queries relying on them take these
+// assets into account. It is run by Init when the chaincode is upgraded.
+// Creating an index which already exists has no effect. The stats are counted
+// again from all the tuples, so that they include the older ones.
+func migrateIndexes(db LedgerDB) error {
+	stats := map[string]Stats{}
+	traintupleKeys, err := db.GetIndexKeys("traintuple~algo~key", []string{"traintuple"})
+	if err != nil {
+		return err
+	}
+	for _, traintupleKey := range traintupleKeys {
+		traintuple, err := db.GetTraintuple(traintupleKey)
+		if err != nil {
+			return err
+		}
+		countTuple(stats, TraintupleType, traintuple.getStatsScopes(), traintuple.Dataset.Worker, traintuple.Status)
+		if err := db.CreateIndex("traintuple~dataManager~key", []string{"traintuple", traintuple.Dataset.DataManagerKey, traintupleKey}); err != nil {
+			return err
+		}
+		for _, dataSampleKey := range traintuple.Dataset.DataSampleKeys {
+			if err := db.CreateIndex("traintuple~dataSample~key", []string{"traintuple", dataSampleKey, traintupleKey}); err != nil {
+				return err
+			}
+		}
+	}
+
+	testtupleKeys, err := db.GetIndexKeys("testtuple~algo~key", []string{"testtuple"})
+	if err != nil {
+		return err
+	}
+	for _, testtupleKey := range testtupleKeys {
+		testtuple, err := db.GetTesttuple(testtupleKey)
+		if err != nil {
+			return err
+		}
+		scopes, err := testtuple.getStatsScopes(db)
+		if err != nil {
+			return err
+		}
+		countTuple(stats, TesttupleType, scopes, testtuple.Dataset.Worker, testtuple.Status)
+		if err := db.CreateIndex("testtuple~dataManager~key", []string{"testtuple", testtuple.Dataset.OpenerHash, testtupleKey}); err != nil {
+			return err
+		}
+	}
+
+	objectiveKeys, err := db.GetIndexKeys("objective~owner~key", []string{"objective"})
+	if err != nil {
+		return err
+	}
+	for _, objectiveKey := range objectiveKeys {
+		objective, err := db.GetObjective(objectiveKey)
+		if err != nil {
+			return err
+		}
+		if objective.TestDataset == nil {
+			continue
+		}
+		for _, dataSampleKey := range objective.TestDataset.DataSampleKeys {
+			if err := db.CreateIndex("objective~dataSample~key", []string{"objective", dataSampleKey, objectiveKey}); err != nil {
+				return err
+			}
+		}
+	}
+	return resetStats(db, stats)
+}
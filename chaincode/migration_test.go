@@ -0,0 +1,107 @@
+// Copyright 2018 Owkin, inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deleteIndex removes all the entries of an index, as if the assets had been
+// stored before it was introduced
+func deleteIndex(t *testing.T, mockStub *MockStub, index string) {
+	mockStub.MockTransactionStart("deleteIndex")
+	defer mockStub.MockTransactionEnd("deleteIndex")
+	iterator, err := mockStub.GetStateByPartialCompositeKey(index, []string{})
+	require.NoError(t, err)
+	keys := []string{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		require.NoError(t, err)
+		keys = append(keys, kv.Key)
+	}
+	require.NoError(t, iterator.Close())
+	require.NotEmpty(t, keys, "index %s is empty", index)
+	for _, key := range keys {
+		require.NoError(t, mockStub.DelState(key))
+	}
+}
+
+func TestMigrateIndexes(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "traintuple")
+	deleteIndex(t, mockStub, "traintuple~dataSample~key")
+	deleteIndex(t, mockStub, "objective~dataSample~key")
+	deleteIndex(t, mockStub, "traintuple~dataManager~key")
+
+	resp := mockStub.MockInit("42", [][]byte{[]byte("init")})
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+
+	// The dataSample used before the indexes were introduced can't be moved
+	inp := inputUpdateDataSampleTestOnly{Hashes: []string{trainDataSampleHash1}, TestOnly: boolPtr(true)}
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("updateDataSampleTestOnly", inp))
+	assert.EqualValues(t, 400, resp.Status, resp.Message)
+	inp = inputUpdateDataSampleTestOnly{Hashes: []string{testDataSampleHash1}, TestOnly: boolPtr(false)}
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("updateDataSampleTestOnly", inp))
+	assert.EqualValues(t, 400, resp.Status, resp.Message)
+
+	// The traintuples created before the indexes were introduced are part
+	// of the access reports
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("queryAccessReport"), keyToJSON(dataManagerOpenerHash)})
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	report := outputAccessReport{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &report))
+	require.Len(t, report.Nodes, 1)
+	assert.Equal(t, []string{traintupleKey}, report.Nodes[0].Path)
+}
+
+func TestMigrateStats(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "traintuple")
+	// The traintuple was created before the stats were introduced
+	scopes := []string{statsScopeAll, getStatsScope(objectiveDescriptionHash, "")}
+	mockStub.MockTransactionStart("deleteStats")
+	for _, scope := range scopes {
+		for shard := 0; shard < statsShards; shard++ {
+			require.NoError(t, mockStub.DelState(getStatsKey(scope, shard)))
+		}
+	}
+	mockStub.MockTransactionEnd("deleteStats")
+
+	// Running Init several times doesn't count the tuples twice
+	for _, txID := range []string{"42", "43"} {
+		resp := mockStub.MockInit(txID, [][]byte{[]byte("init")})
+		require.EqualValues(t, 200, resp.Status, resp.Message)
+	}
+	resp := mockStub.MockInvoke("44", [][]byte{[]byte("logStartTrain"), keyToJSON(traintupleKey)})
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+
+	for _, inp := range []inputQueryStats{{}, {ObjectiveKey: objectiveDescriptionHash}} {
+		resp = mockStub.MockInvoke("45", methodAndAssetToByte("queryStats", inp))
+		require.EqualValues(t, 200, resp.Status, resp.Message)
+		stats := outputStats{}
+		require.NoError(t, json.Unmarshal(resp.Payload, &stats))
+		assert.Equal(t, TupleCounts{
+			Total:    1,
+			ByStatus: map[string]int{StatusDoing: 1},
+			ByWorker: map[string]int{worker: 1},
+		}, stats.Traintuples, "stats of %+v", inp)
+	}
+}
@@ -69,6 +69,9 @@ type MockStub struct {
 
 	TxTimestamp *timestamp.Timestamp
 
+	// FixedTxTimestamp, if set, is used as the timestamp of all the transactions
+	FixedTxTimestamp *timestamp.Timestamp
+
 	// mocked signedProposal
 	signedProposal *pb.SignedProposal
 
@@ -124,6 +127,10 @@ func (stub *MockStub) GetFunctionAndParameters() (function string, params []stri
 func (stub *MockStub) MockTransactionStart(txid string) {
 	stub.TxID = txid
 	stub.setSignedProposal(&pb.SignedProposal{})
+	if stub.FixedTxTimestamp != nil {
+		stub.setTxTimestamp(stub.FixedTxTimestamp)
+		return
+	}
 	stub.setTxTimestamp(util.CreateUtcTimestamp())
 }
 
@@ -642,12 +649,12 @@ func newCompositeKey(objectType string, attributes []string) (string, error) {
 	if err := validateCompositeKeyAttribute(objectType); err != nil {
 		return "", err
 	}
-	ck := compositeKeyNamespace + objectType + string(minUnicodeRuneValue)
+	ck := compositeKeyNamespace + objectType + string(rune(minUnicodeRuneValue))
 	for _, att := range attributes {
 		if err := validateCompositeKeyAttribute(att); err != nil {
 			return "", err
 		}
-		ck += att + string(minUnicodeRuneValue)
+		ck += att + string(rune(minUnicodeRuneValue))
 	}
 	return ck, nil
 }
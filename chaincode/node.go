@@ -14,7 +14,11 @@
 
 package main
 
-func registerNode(db LedgerDB, args []string) (Node, error) {
+import (
+	"chaincode/errors"
+)
+
+func registerNode(db LedgerDB) (Node, error) {
 	txCreator, err := GetTxCreator(db.cc)
 	if err != nil {
 		return Node{}, err
@@ -46,7 +50,7 @@ func registerNode(db LedgerDB, args []string) (Node, error) {
 	return node, nil
 }
 
-func queryNodes(db LedgerDB, args []string) (resp []Node, err error) {
+func queryNodes(db LedgerDB) (resp []Node, err error) {
 	elementsKeys, err := db.GetIndexKeys("node~key", []string{"node"})
 	if err != nil {
 		return nil, err
@@ -64,3 +68,108 @@ func queryNodes(db LedgerDB, args []string) (resp []Node, err error) {
 
 	return nodes, nil
 }
+
+// registerNodeGroup stores a new node group in the ledger
+func registerNodeGroup(db LedgerDB, inp inputNodeGroup) (out outputNodeGroup, err error) {
+	if err = checkNodeIDs(db, inp.NodeIDs); err != nil {
+		return
+	}
+	owner, err := GetTxCreator(db.cc)
+	if err != nil {
+		return
+	}
+	nodeGroup := NodeGroup{
+		AssetType: NodeGroupType,
+		Name:      inp.Name,
+		Owner:     owner,
+		NodeIDs:   inp.NodeIDs,
+	}
+	if err = db.Add(getNodeGroupKey(inp.Name), nodeGroup); err != nil {
+		return
+	}
+	if err = db.CreateIndex("nodeGroup~name", []string{"nodeGroup", inp.Name}); err != nil {
+		return
+	}
+	out.Fill(nodeGroup)
+	return
+}
+
+// updateNodeGroup replaces the nodes of a node group. Only its owner can update it.
+// The assets shared with the group are then shared with its new nodes.
+func updateNodeGroup(db LedgerDB, inp inputNodeGroup) (out outputNodeGroup, err error) {
+	nodeGroup, err := db.GetNodeGroup(inp.Name)
+	if err != nil {
+		return
+	}
+	if err = checkAssetOwner(db, "node group", inp.Name, nodeGroup.Owner); err != nil {
+		return
+	}
+	if err = checkNodeIDs(db, inp.NodeIDs); err != nil {
+		return
+	}
+	nodeGroup.NodeIDs = inp.NodeIDs
+	if err = db.Put(getNodeGroupKey(inp.Name), nodeGroup); err != nil {
+		return
+	}
+	out.Fill(nodeGroup)
+	return
+}
+
+// queryNodeGroups returns all the node groups of the ledger
+func queryNodeGroups(db LedgerDB) (outNodeGroups []outputNodeGroup, err error) {
+	outNodeGroups = []outputNodeGroup{}
+	names, err := db.GetIndexKeys("nodeGroup~name", []string{"nodeGroup"})
+	if err != nil {
+		return
+	}
+	for _, name := range names {
+		nodeGroup, err := db.GetNodeGroup(name)
+		if err != nil {
+			return outNodeGroups, err
+		}
+		var out outputNodeGroup
+		out.Fill(nodeGroup)
+		outNodeGroups = append(outNodeGroups, out)
+	}
+	return
+}
+
+// getNodeGroups returns the nodes of all the node groups of the ledger
+func getNodeGroups(db LedgerDB) (NodeGroups, error) {
+	groups := NodeGroups{}
+	names, err := db.GetIndexKeys("nodeGroup~name", []string{"nodeGroup"})
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		nodeGroup, err := db.GetNodeGroup(name)
+		if err != nil {
+			return nil, err
+		}
+		groups[name] = nodeGroup.NodeIDs
+	}
+	return groups, nil
+}
+
+// checkNodeIDs checks that the nodes are registered
+func checkNodeIDs(db LedgerDB, nodeIDs []string) error {
+	nodes, err := queryNodes(db)
+	if err != nil {
+		return err
+	}
+	registeredIDs := []string{}
+	for _, node := range nodes {
+		registeredIDs = append(registeredIDs, node.ID)
+	}
+	for _, nodeID := range nodeIDs {
+		if !stringInSlice(nodeID, registeredIDs) {
+			return errors.BadRequest("node %s not found", nodeID)
+		}
+	}
+	return nil
+}
+
+// getNodeGroupKey returns the key under which a node group is stored
+func getNodeGroupKey(name string) string {
+	return HashForKey("nodeGroup", name)
+}
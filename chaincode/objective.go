@@ -16,7 +16,6 @@ package main
 
 import (
 	"chaincode/errors"
-	"fmt"
 	"sort"
 )
 
@@ -32,7 +31,7 @@ func (objective *Objective) Set(db LedgerDB, inp inputObjective) (objectiveKey s
 			err = errors.BadRequest(err, "invalid test dataSample")
 			return
 		} else if !testOnly {
-			err = errors.BadRequest("test dataSample are not tagged as testOnly dataSample")
+			err = errors.BadRequest(errors.CodeTestOnlyData, "test dataSample are not tagged as testOnly dataSample")
 			return
 		}
 		objective.TestDataset = &Dataset{
@@ -60,6 +59,7 @@ func (objective *Objective) Set(db LedgerDB, inp inputObjective) (objectiveKey s
 	}
 	objective.Owner = owner
 	objective.Permissions = permissions
+	objective.MaxDuration = inp.MaxDuration
 	objectiveKey = inp.DescriptionHash
 	return
 }
@@ -70,14 +70,7 @@ func (objective *Objective) Set(db LedgerDB, inp inputObjective) (objectiveKey s
 
 // registerObjective stores a new objective in the ledger.
 // If the key exists, it will override the value with the new one
-func registerObjective(db LedgerDB, args []string) (resp map[string]string, err error) {
-	// convert input strings args to input struct inputObjective
-	inp := inputObjective{}
-	err = AssetFromJSON(args, &inp)
-	if err != nil {
-		return
-	}
-
+func registerObjective(db LedgerDB, inp inputObjective) (resp map[string]string, err error) {
 	// check validity of input args and convert it to Objective
 	objective := Objective{}
 	objectiveKey, dataManagerKey, err := objective.Set(db, inp)
@@ -92,18 +85,20 @@ func registerObjective(db LedgerDB, args []string) (resp map[string]string, err
 	if err = db.CreateIndex("objective~owner~key", []string{"objective", objective.Owner, objectiveKey}); err != nil {
 		return
 	}
+	if objective.TestDataset != nil {
+		for _, dataSampleKey := range objective.TestDataset.DataSampleKeys {
+			if err = db.CreateIndex("objective~dataSample~key", []string{"objective", dataSampleKey, objectiveKey}); err != nil {
+				return
+			}
+		}
+	}
 	// add objective to dataManager
 	err = addObjectiveDataManager(db, dataManagerKey, objectiveKey)
 	return map[string]string{"key": objectiveKey}, err
 }
 
 // queryObjective returns a objective of the ledger given its key
-func queryObjective(db LedgerDB, args []string) (out outputObjective, err error) {
-	inp := inputHash{}
-	err = AssetFromJSON(args, &inp)
-	if err != nil {
-		return
-	}
+func queryObjective(db LedgerDB, inp inputHash) (out outputObjective, err error) {
 	objective, err := db.GetObjective(inp.Key)
 	if err != nil {
 		return
@@ -112,13 +107,10 @@ func queryObjective(db LedgerDB, args []string) (out outputObjective, err error)
 	return
 }
 
-// queryObjectives returns all objectives of the ledger
-func queryObjectives(db LedgerDB, args []string) (outObjectives []outputObjective, err error) {
+// queryObjectives returns all objectives of the ledger, archived ones are only
+// returned if includeArchived is set
+func queryObjectives(db LedgerDB, inp inputQueryAll) (outObjectives []outputObjective, err error) {
 	outObjectives = []outputObjective{}
-	if len(args) != 0 {
-		err = fmt.Errorf("incorrect number of arguments, expecting nothing")
-		return
-	}
 	elementsKeys, err := db.GetIndexKeys("objective~owner~key", []string{"objective"})
 	if err != nil {
 		return
@@ -128,6 +120,9 @@ func queryObjectives(db LedgerDB, args []string) (outObjectives []outputObjectiv
 		if err != nil {
 			return outObjectives, err
 		}
+		if objective.Archived && !inp.IncludeArchived {
+			continue
+		}
 		var out outputObjective
 		out.Fill(key, objective)
 		outObjectives = append(outObjectives, out)
@@ -135,15 +130,27 @@ func queryObjectives(db LedgerDB, args []string) (outObjectives []outputObjectiv
 	return
 }
 
-// getObjectiveLeaderboard returns for an objective, all its certified testtuples with a done status, ordered by their perf
-// It can be an ascending sort or not depending on the ascendingOrder value.
-func queryObjectiveLeaderboard(db LedgerDB, args []string) (outputLeaderboard, error) {
-	inp := inputLeaderboard{}
-	err := AssetFromJSON(args, &inp)
+// archiveObjective archives an objective so that it can't be used in new tuples anymore.
+// Only the owner of the objective can archive it.
+func archiveObjective(db LedgerDB, inp inputHash) (resp map[string]string, err error) {
+	objective, err := db.GetObjective(inp.Key)
 	if err != nil {
-		return outputLeaderboard{}, err
+		return
+	}
+	if err = checkArchivable(db, "objective", inp.Key, objective.Owner, objective.Archived); err != nil {
+		return
 	}
+	objective.Archived = true
+	err = db.Put(inp.Key, objective)
+	if err != nil {
+		return
+	}
+	return map[string]string{"key": inp.Key}, nil
+}
 
+// getObjectiveLeaderboard returns for an objective, all its certified testtuples with a done status, ordered by their perf
+// It can be an ascending sort or not depending on the ascendingOrder value.
+func queryObjectiveLeaderboard(db LedgerDB, inp inputLeaderboard) (outputLeaderboard, error) {
 	objective, err := db.GetObjective(inp.ObjectiveKey)
 	if err != nil {
 		return outputLeaderboard{}, err
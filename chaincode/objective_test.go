@@ -33,7 +33,7 @@ func TestLeaderBoard(t *testing.T) {
 	inputTest := inputTesttuple{
 		TraintupleKey: traintupleKey,
 	}
-	keyMap, err := createTesttuple(db, assetToArgs(inputTest))
+	keyMap, err := createTesttuple(db, inputTest)
 	assert.NoError(t, err)
 
 	inpLeaderboard := inputLeaderboard{
@@ -41,7 +41,7 @@ func TestLeaderBoard(t *testing.T) {
 		AscendingOrder: true,
 	}
 	// leaderboard should be empty since there is no testtuple done
-	leaderboard, err := queryObjectiveLeaderboard(db, assetToArgs(inpLeaderboard))
+	leaderboard, err := queryObjectiveLeaderboard(db, inpLeaderboard)
 	assert.NoError(t, err)
 	assert.Len(t, leaderboard.Testtuples, 0)
 
@@ -53,7 +53,7 @@ func TestLeaderBoard(t *testing.T) {
 	err = db.Put(keyMap["key"], testtuple)
 	assert.NoError(t, err)
 
-	leaderboard, err = queryObjectiveLeaderboard(db, assetToArgs(inpLeaderboard))
+	leaderboard, err = queryObjectiveLeaderboard(db, inpLeaderboard)
 	assert.NoError(t, err)
 	assert.Equal(t, objectiveDescriptionHash, leaderboard.Objective.Key)
 	require.Len(t, leaderboard.Testtuples, 1)
@@ -81,14 +81,14 @@ func TestRegisterObjectiveWithDataSampleKeyNotDataManagerKey(t *testing.T) {
 	inpDataSample := inputDataSample{
 		Hashes:          []string{testDataSampleHash1},
 		DataManagerKeys: []string{dataManagerOpenerHash},
-		TestOnly:        "true",
+		TestOnly:        boolPtr(true),
 	}
 	args = inpDataSample.createDefault()
 	mockStub.MockInvoke("42", args)
 	inpDataSample = inputDataSample{
 		Hashes:          []string{testDataSampleHash2},
 		DataManagerKeys: []string{dataManagerOpenerHash},
-		TestOnly:        "true",
+		TestOnly:        boolPtr(true),
 	}
 	args = inpDataSample.createDefault()
 	r := mockStub.MockInvoke("42", args)
@@ -15,7 +15,7 @@
 package main
 
 import (
-	"fmt"
+	"chaincode/errors"
 )
 
 // Struct use as output representation of ledger data
@@ -28,6 +28,8 @@ type outputObjective struct {
 	Owner       string            `json:"owner"`
 	TestDataset *Dataset          `json:"testDataset"`
 	Permissions outputPermissions `json:"permissions"`
+	Archived    bool              `json:"archived"`
+	MaxDuration int64             `json:"maxDuration"`
 }
 
 func (out *outputObjective) Fill(key string, in Objective) {
@@ -39,6 +41,8 @@ func (out *outputObjective) Fill(key string, in Objective) {
 	out.Owner = in.Owner
 	out.TestDataset = in.TestDataset
 	out.Permissions.Fill(in.Permissions)
+	out.Archived = in.Archived
+	out.MaxDuration = in.MaxDuration
 }
 
 // outputDataManager is the return representation of the DataManager type stored in the ledger
@@ -51,6 +55,7 @@ type outputDataManager struct {
 	Owner        string            `json:"owner"`
 	Permissions  outputPermissions `json:"permissions"`
 	Type         string            `json:"type"`
+	Archived     bool              `json:"archived"`
 }
 
 func (out *outputDataManager) Fill(key string, in DataManager) {
@@ -63,30 +68,99 @@ func (out *outputDataManager) Fill(key string, in DataManager) {
 	out.Owner = in.Owner
 	out.Permissions.Fill(in.Permissions)
 	out.Type = in.Type
+	out.Archived = in.Archived
 }
 
 type outputDataSample struct {
-	DataManagerKeys []string `json:"dataManagerKeys"`
-	Owner           string   `json:"owner"`
-	Key             string   `json:"key"`
+	DataManagerKeys []string            `json:"dataManagerKeys"`
+	Owner           string              `json:"owner"`
+	Key             string              `json:"key"`
+	Revoked         bool                `json:"revoked"`
+	Metadata        *DataSampleMetadata `json:"metadata"`
 }
 
 func (out *outputDataSample) Fill(key string, in DataSample) {
 	out.Key = key
 	out.DataManagerKeys = in.DataManagerKeys
 	out.Owner = in.Owner
+	out.Revoked = in.Revoked
+	out.Metadata = in.Metadata
 }
 
 type outputDataset struct {
 	outputDataManager
-	TrainDataSampleKeys []string `json:"trainDataSampleKeys"`
-	TestDataSampleKeys  []string `json:"testDataSampleKeys"`
+	TrainDataSampleKeys   []string               `json:"trainDataSampleKeys"`
+	TestDataSampleKeys    []string               `json:"testDataSampleKeys"`
+	RevokedDataSampleKeys []string               `json:"revokedDataSampleKeys"`
+	TrainStats            outputDataSamplesByTag `json:"trainStats"`
+	TestStats             outputDataSamplesByTag `json:"testStats"`
 }
 
-func (out *outputDataset) Fill(key string, in DataManager, trainKeys []string, testKeys []string) {
+func (out *outputDataset) Fill(key string, in DataManager, trainKeys []string, testKeys []string, revokedKeys []string) {
 	out.outputDataManager.Fill(key, in)
 	out.TrainDataSampleKeys = trainKeys
 	out.TestDataSampleKeys = testKeys
+	out.RevokedDataSampleKeys = revokedKeys
+	out.TrainStats.ByTag = map[string]map[string]outputDataSamplesStats{}
+	out.TestStats.ByTag = map[string]map[string]outputDataSamplesStats{}
+}
+
+// outputDataSamplesStats aggregates the metadata of a set of dataSample
+type outputDataSamplesStats struct {
+	Count     int   `json:"count"`
+	TotalSize int64 `json:"totalSize"`
+}
+
+// Add adds a dataSample to the stats. DataSample registered without metadata
+// are counted with a null size.
+func (out *outputDataSamplesStats) Add(in DataSample) {
+	out.Count++
+	if in.Metadata != nil {
+		out.TotalSize += in.Metadata.Size
+	}
+}
+
+// outputDataSamplesByTag aggregates the metadata of a set of dataSample, in
+// total and broken down by tag name and value
+type outputDataSamplesByTag struct {
+	outputDataSamplesStats
+	ByTag map[string]map[string]outputDataSamplesStats `json:"byTag"`
+}
+
+// Add adds a dataSample to the total and to the stats of each of its tags
+func (out *outputDataSamplesByTag) Add(in DataSample) {
+	out.outputDataSamplesStats.Add(in)
+	if in.Metadata == nil {
+		return
+	}
+	for name, value := range in.Metadata.Tags {
+		if _, ok := out.ByTag[name]; !ok {
+			out.ByTag[name] = map[string]outputDataSamplesStats{}
+		}
+		stats := out.ByTag[name][value]
+		stats.Add(in)
+		out.ByTag[name][value] = stats
+	}
+}
+
+type outputDatasetSplit struct {
+	Key                 string   `json:"key"`
+	DataManagerKey      string   `json:"dataManagerKey"`
+	TestRatio           float64  `json:"testRatio"`
+	Seed                string   `json:"seed"`
+	Creator             string   `json:"creator"`
+	TrainDataSampleKeys []string `json:"trainDataSampleKeys"`
+	TestDataSampleKeys  []string `json:"testDataSampleKeys"`
+}
+
+func (out *outputDatasetSplit) Fill(key string, in DatasetSplit) {
+	out.Key = key
+	out.DataManagerKey = in.DataManagerKey
+	out.TestRatio = in.TestRatio
+	out.Seed = in.Seed
+	out.Creator = in.Creator
+	out.TrainDataSampleKeys = in.TrainDataSampleKeys
+	out.TestDataSampleKeys = in.TestDataSampleKeys
 }
 
 type outputAlgo struct {
@@ -96,6 +170,7 @@ type outputAlgo struct {
 	Description *HashDress        `json:"description"`
 	Owner       string            `json:"owner"`
 	Permissions outputPermissions `json:"permissions"`
+	Archived    bool              `json:"archived"`
 }
 
 func (out *outputAlgo) Fill(key string, in Algo) {
@@ -106,6 +181,7 @@ func (out *outputAlgo) Fill(key string, in Algo) {
 	out.Description = in.Description
 	out.Owner = in.Owner
 	out.Permissions.Fill(in.Permissions)
+	out.Archived = in.Archived
 }
 
 // outputTraintuple is the representation of one the element type stored in the
@@ -113,15 +189,18 @@ func (out *outputAlgo) Fill(key string, in Algo) {
 type outputTraintuple struct {
 	Key           string            `json:"key"`
 	Algo          *HashDressName    `json:"algo"`
+	CreationDate  int64             `json:"creationDate"`
 	Creator       string            `json:"creator"`
 	Dataset       *TtDataset        `json:"dataset"`
 	ComputePlanID string            `json:"computePlanID"`
 	InModels      []*Model          `json:"inModels"`
-	Log           string            `json:"log"`
+	Log           string            `json:"log" log:"truncate"`
 	Objective     *TtObjective      `json:"objective"`
 	OutModel      *HashDress        `json:"outModel"`
 	Permissions   outputPermissions `json:"permissions"`
+	Priority      int               `json:"priority"`
 	Rank          int               `json:"rank"`
+	StartDate     int64             `json:"startDate"`
 	Status        string            `json:"status"`
 	Tag           string            `json:"tag"`
 }
@@ -130,18 +209,21 @@ type outputTraintuple struct {
 func (outputTraintuple *outputTraintuple) Fill(db LedgerDB, traintuple Traintuple, traintupleKey string) (err error) {
 
 	outputTraintuple.Key = traintupleKey
+	outputTraintuple.CreationDate = traintuple.CreationDate
 	outputTraintuple.Creator = traintuple.Creator
 	outputTraintuple.Permissions.Fill(traintuple.Permissions)
 	outputTraintuple.Log = traintuple.Log
 	outputTraintuple.Status = traintuple.Status
+	outputTraintuple.Priority = traintuple.Priority
 	outputTraintuple.Rank = traintuple.Rank
+	outputTraintuple.StartDate = traintuple.StartDate
 	outputTraintuple.ComputePlanID = traintuple.ComputePlanID
 	outputTraintuple.OutModel = traintuple.OutModel
 	outputTraintuple.Tag = traintuple.Tag
 	// fill algo
 	algo, err := db.GetAlgo(traintuple.AlgoKey)
 	if err != nil {
-		err = fmt.Errorf("could not retrieve algo with key %s - %s", traintuple.AlgoKey, err.Error())
+		err = errors.Internal(err, "could not retrieve algo with key %s -", traintuple.AlgoKey)
 		return
 	}
 	outputTraintuple.Algo = &HashDressName{
@@ -152,11 +234,11 @@ func (outputTraintuple *outputTraintuple) Fill(db LedgerDB, traintuple Traintupl
 	// fill objective
 	objective, err := db.GetObjective(traintuple.ObjectiveKey)
 	if err != nil {
-		err = fmt.Errorf("could not retrieve associated objective with key %s- %s", traintuple.ObjectiveKey, err.Error())
+		err = errors.Internal(err, "could not retrieve associated objective with key %s-", traintuple.ObjectiveKey)
 		return
 	}
 	if objective.Metrics == nil {
-		err = fmt.Errorf("objective %s is missing metrics values", traintuple.ObjectiveKey)
+		err = errors.Internal("objective %s is missing metrics values", traintuple.ObjectiveKey)
 		return
 	}
 	metrics := HashDress{
@@ -175,7 +257,7 @@ func (outputTraintuple *outputTraintuple) Fill(db LedgerDB, traintuple Traintupl
 		}
 		parentTraintuple, err := db.GetTraintuple(inModelKey)
 		if err != nil {
-			return fmt.Errorf("could not retrieve parent traintuple with key %s - %s", inModelKey, err.Error())
+			return errors.Internal(err, "could not retrieve parent traintuple with key %s -", inModelKey)
 		}
 		inModel := &Model{
 			TraintupleKey: inModelKey,
@@ -199,32 +281,47 @@ func (outputTraintuple *outputTraintuple) Fill(db LedgerDB, traintuple Traintupl
 }
 
 type outputTesttuple struct {
-	Key       string         `json:"key"`
-	Algo      *HashDressName `json:"algo"`
-	Certified bool           `json:"certified"`
-	Creator   string         `json:"creator"`
-	Dataset   *TtDataset     `json:"dataset"`
-	Log       string         `json:"log"`
-	Model     *Model         `json:"model"`
-	Objective *TtObjective   `json:"objective"`
-	Status    string         `json:"status"`
-	Tag       string         `json:"tag"`
+	Key          string            `json:"key"`
+	Algo         *HashDressName    `json:"algo"`
+	Certified    bool              `json:"certified"`
+	CreationDate int64             `json:"creationDate"`
+	Creator      string            `json:"creator"`
+	Dataset      *TtDataset        `json:"dataset"`
+	Log          string            `json:"log" log:"truncate"`
+	Model        *Model            `json:"model"`
+	Objective    *TtObjective      `json:"objective"`
+	Permissions  outputPermissions `json:"permissions"`
+	Priority     int               `json:"priority"`
+	StartDate    int64             `json:"startDate"`
+	Status       string            `json:"status"`
+	Tag          string            `json:"tag"`
+}
+
+// outputClaimedTuple is the tuple claimed by a worker, only one of its
+// fields is set and both are empty when there was nothing to claim
+type outputClaimedTuple struct {
+	Traintuple *outputTraintuple `json:"traintuple,omitempty"`
+	Testtuple  *outputTesttuple  `json:"testtuple,omitempty"`
 }
 
 func (out *outputTesttuple) Fill(db LedgerDB, key string, in Testtuple) error {
 	out.Key = key
 	out.Certified = in.Certified
+	out.CreationDate = in.CreationDate
 	out.Creator = in.Creator
 	out.Dataset = in.Dataset
 	out.Log = in.Log
 	out.Model = in.Model
+	out.Permissions.Fill(in.Permissions)
+	out.Priority = in.Priority
+	out.StartDate = in.StartDate
 	out.Status = in.Status
 	out.Tag = in.Tag
 
 	// fill algo
 	algo, err := db.GetAlgo(in.AlgoKey)
 	if err != nil {
-		return fmt.Errorf("could not retrieve algo with key %s - %s", in.AlgoKey, err.Error())
+		return errors.Internal(err, "could not retrieve algo with key %s -", in.AlgoKey)
 	}
 	out.Algo = &HashDressName{
 		Name:           algo.Name,
@@ -234,10 +331,10 @@ func (out *outputTesttuple) Fill(db LedgerDB, key string, in Testtuple) error {
 	// fill objective
 	objective, err := db.GetObjective(in.ObjectiveKey)
 	if err != nil {
-		return fmt.Errorf("could not retrieve associated objective with key %s- %s", in.ObjectiveKey, err.Error())
+		return errors.Internal(err, "could not retrieve associated objective with key %s-", in.ObjectiveKey)
 	}
 	if objective.Metrics == nil {
-		return fmt.Errorf("objective %s is missing metrics values", in.ObjectiveKey)
+		return errors.Internal("objective %s is missing metrics values", in.ObjectiveKey)
 	}
 	metrics := HashDress{
 		Hash:           objective.Metrics.Hash,
@@ -287,10 +384,34 @@ func (te *TuplesEvent) AddTesttuple(out outputTesttuple) {
 	te.Testtuples = append(te.Testtuples, out)
 }
 
+// DataSamplesEvent is the collection of data samples sent in an event
+type DataSamplesEvent struct {
+	DataSamples []outputDataSample `json:"dataSamples"`
+}
+
+// AddDataSample add one data sample to the event struct
+func (de *DataSamplesEvent) AddDataSample(out outputDataSample) {
+	de.DataSamples = append(de.DataSamples, out)
+}
+
 type outputComputePlan struct {
 	ComputePlanID  string   `json:"computePlanID"`
 	TraintupleKeys []string `json:"traintupleKeys"`
 	TesttupleKeys  []string `json:"testtupleKeys"`
+	MaxDuration    int64    `json:"maxDuration"`
+}
+
+func (out *outputComputePlan) Fill(key string, in ComputePlan) {
+	out.ComputePlanID = key
+	out.TraintupleKeys = in.TraintupleKeys
+	out.TesttupleKeys = in.TesttupleKeys
+	out.MaxDuration = in.MaxDuration
+}
+
+// outputStaleTuples lists the tuples marked as failed by a sweep
+type outputStaleTuples struct {
+	TraintupleKeys []string `json:"traintupleKeys"`
+	TesttupleKeys  []string `json:"testtupleKeys"`
 }
 
 type outputPermissions struct {
@@ -302,9 +423,86 @@ func (out *outputPermissions) Fill(in Permissions) {
 	out.Process.AuthorizedIDs = []string{}
 	if !in.Process.Public {
 		out.Process.AuthorizedIDs = in.Process.AuthorizedIDs
+		out.Process.Validities = in.Process.Validities
 	}
 }
 
+type outputPermissionsUpdate struct {
+	Key                 string            `json:"key"`
+	AssetKey            string            `json:"assetKey"`
+	Index               int               `json:"index"`
+	Creator             string            `json:"creator"`
+	PreviousPermissions outputPermissions `json:"previousPermissions"`
+	Permissions         outputPermissions `json:"permissions"`
+}
+
+func (out *outputPermissionsUpdate) Fill(key string, in PermissionsUpdate) {
+	out.Key = key
+	out.AssetKey = in.AssetKey
+	out.Index = in.Index
+	out.Creator = in.Creator
+	out.PreviousPermissions.Fill(in.PreviousPermissions)
+	out.Permissions.Fill(in.Permissions)
+}
+
+type outputNodeGroup struct {
+	Name    string   `json:"name"`
+	Owner   string   `json:"owner"`
+	NodeIDs []string `json:"nodeIDs"`
+}
+
+func (out *outputNodeGroup) Fill(in NodeGroup) {
+	out.Name = in.Name
+	out.Owner = in.Owner
+	out.NodeIDs = in.NodeIDs
+}
+
+// outputQuota is the quota of a node on a dataManager with its current usage.
+// The remaining allowances are null when there is no limit.
+type outputQuota struct {
+	DataManagerKey        string `json:"dataManagerKey"`
+	NodeID                string `json:"nodeID"`
+	Owner                 string `json:"owner"`
+	MaxActiveTuples       int    `json:"maxActiveTuples"`
+	MaxTuplesPerDay       int    `json:"maxTuplesPerDay"`
+	ActiveTuples          int    `json:"activeTuples"`
+	DayTuples             int    `json:"dayTuples"`
+	RemainingActiveTuples *int   `json:"remainingActiveTuples"`
+	RemainingDayTuples    *int   `json:"remainingDayTuples"`
+}
+
+// Fill sets the output from the quota and the usage of the node today
+func (out *outputQuota) Fill(in Quota, activeTuples, dayTuples int) {
+	out.DataManagerKey = in.DataManagerKey
+	out.NodeID = in.NodeID
+	out.Owner = in.Owner
+	out.MaxActiveTuples = in.MaxActiveTuples
+	out.MaxTuplesPerDay = in.MaxTuplesPerDay
+	out.ActiveTuples = activeTuples
+	out.DayTuples = dayTuples
+	if in.MaxActiveTuples > 0 {
+		remaining := in.MaxActiveTuples - out.ActiveTuples
+		out.RemainingActiveTuples = &remaining
+	}
+	if in.MaxTuplesPerDay > 0 {
+		remaining := in.MaxTuplesPerDay - out.DayTuples
+		out.RemainingDayTuples = &remaining
+	}
+}
+
+// outputAccessReport lists the nodes which can process a model derived from an asset
+type outputAccessReport struct {
+	AssetKey string             `json:"assetKey"`
+	Nodes    []outputNodeAccess `json:"nodes"`
+}
+
+// outputNodeAccess gives the path of traintuples, from one using the asset to the
+// one whose model the node can process
+type outputNodeAccess struct {
+	NodeID string   `json:"nodeID"`
+	Path   []string `json:"path"`
+}
+
 type outputLeaderboard struct {
 	Objective  outputObjective   `json:"objective"`
 	Testtuples outputBoardTuples `json:"testtuples"`
@@ -350,3 +548,38 @@ func (out *outputBoardTuple) Fill(db LedgerDB, in Testtuple, testtupleKey string
 	out.Tag = in.Tag
 	return nil
 }
+
+// outputStats is the number of tuples of a scope by asset type. The statuses
+// and workers without tuples are omitted.
+type outputStats struct {
+	Traintuples TupleCounts `json:"traintuples"`
+	Testtuples  TupleCounts `json:"testtuples"`
+}
+
+// Fill sets the output from the sum of the shards of the stats of a scope
+func (out *outputStats) Fill(in Stats) {
+	out.Traintuples = in.Traintuples.withoutZeros()
+	out.Testtuples = in.Testtuples.withoutZeros()
+}
+
+// outputContract describes a smart contract, its inputs and outputs are
+// described with JSON Schema
+type outputContract struct {
+	Name          string      `json:"name"`
+	Role          Role        `json:"role"`
+	ReadOnly      bool        `json:"readOnly"`
+	OptionalInput bool        `json:"optionalInput"`
+	Input         *jsonSchema `json:"input"`
+	Output        *jsonSchema `json:"output"`
+}
+
+func (out *outputContract) Fill(in contract) {
+	out.Name = in.name
+	out.Role = in.role
+	out.ReadOnly = in.readOnly
+	out.OptionalInput = in.optionalInput
+	if in.input != nil {
+		out.Input = newJSONSchema(in.input)
+	}
+	out.Output = newJSONSchema(in.output)
+}
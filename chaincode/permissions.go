@@ -15,7 +15,10 @@
 package main
 
 import (
-	"fmt"
+	"chaincode/errors"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 // Action is the the type of an action
@@ -27,13 +30,52 @@ const (
 	Download Action = "download"
 )
 
+// NodeGroupPrefix is the prefix of the references to a node group in the AuthorizedIDs
+const NodeGroupPrefix = "group:"
+
+// NodeGroups gives for each node group name the IDs of its nodes
+type NodeGroups map[string][]string
+
+// PermissionsContext holds the state of the ledger needed to check permissions:
+// the current node groups and the timestamp of the transaction in seconds
+type PermissionsContext struct {
+	Groups    NodeGroups
+	Timestamp int64
+}
+
+// Validity is the time window during which an authorized node can use an asset.
+// Bounds are unix timestamps in seconds, a null bound means no limit.
+type Validity struct {
+	NotBefore int64 `json:"notBefore,omitempty"`
+	NotAfter  int64 `json:"notAfter,omitempty"`
+}
+
+// contains checks if a timestamp is within the validity window
+func (v Validity) contains(timestamp int64) bool {
+	return (v.NotBefore == 0 || timestamp >= v.NotBefore) && (v.NotAfter == 0 || timestamp <= v.NotAfter)
+}
+
+// intersect returns the window during which both validities are satisfied
+func (v Validity) intersect(other Validity) Validity {
+	if other.NotBefore > v.NotBefore {
+		v.NotBefore = other.NotBefore
+	}
+	if other.NotAfter != 0 && (v.NotAfter == 0 || other.NotAfter < v.NotAfter) {
+		v.NotAfter = other.NotAfter
+	}
+	return v
+}
+
 // Permission represents one permission based on an action type
 type Permission struct {
 	// Public is true if this permission is given to the asset's owner only and
 	// the nodes listed in AuthorizedIDs (open to all nodes if false)
 	Public bool `json:"public"`
-	// AuthorizedIDs list all authorised nodes other than the asset's owner
-	AuthorizedIDs []string `json:"authorizedIDs", omitempty`
+	// AuthorizedIDs list all authorised nodes other than the asset's owner,
+	// node groups can be referenced with the NodeGroupPrefix followed by their name
+	AuthorizedIDs []string `json:"authorizedIDs"`
+	// Validities optionally limits in time the access of some of the AuthorizedIDs
+	Validities map[string]Validity `json:"validities,omitempty"`
 }
 
 // Permissions represents all permissions associated with an asset
@@ -44,8 +86,10 @@ type Permissions struct {
 	Process Permission `json:"process"`
 }
 
-// CanProcess checks if a node can process the asset with the current permissions
-func (perms Permissions) CanProcess(owner, node string) bool {
+// CanProcess checks if a node can process the asset with the current permissions,
+// the node groups are expanded with their current nodes and the validity windows
+// are compared to the transaction timestamp
+func (perms Permissions) CanProcess(owner, node string, ctx PermissionsContext) bool {
 	if owner == node {
 		return true
 	}
@@ -54,17 +98,34 @@ func (perms Permissions) CanProcess(owner, node string) bool {
 		return true
 	}
 
-	for _, authorizedNode := range perms.Process.AuthorizedIDs {
-		if node == authorizedNode {
+	for _, id := range perms.Process.AuthorizedIDs {
+		if !perms.Process.isGrantedBy(id, node, ctx.Groups) {
+			continue
+		}
+		if perms.Process.Validities[id].contains(ctx.Timestamp) {
 			return true
 		}
 	}
 	return false
 }
 
+// getPermissionsContext returns the context in which the permissions are checked
+// during the current transaction
+func getPermissionsContext(db LedgerDB) (PermissionsContext, error) {
+	groups, err := getNodeGroups(db)
+	if err != nil {
+		return PermissionsContext{}, err
+	}
+	timestamp, err := GetTxTimestamp(db.cc)
+	if err != nil {
+		return PermissionsContext{}, err
+	}
+	return PermissionsContext{Groups: groups, Timestamp: timestamp}, nil
+}
+
 // NewPermissions create the Permissions according to the arg received
 func NewPermissions(db LedgerDB, in inputPermissions) (Permissions, error) {
-	nodes, err := queryNodes(db, []string{})
+	nodes, err := queryNodes(db)
 	if err != nil {
 		return Permissions{}, err
 	}
@@ -73,6 +134,10 @@ func NewPermissions(db LedgerDB, in inputPermissions) (Permissions, error) {
 	for _, node := range nodes {
 		nodesIDs = append(nodesIDs, node.ID)
 	}
+	groups, err := getNodeGroups(db)
+	if err != nil {
+		return Permissions{}, err
+	}
 
 	// Validate Process inputPermissions
 	// @TODO Validate Download inputPermissions when implemented
@@ -81,8 +146,22 @@ func NewPermissions(db LedgerDB, in inputPermissions) (Permissions, error) {
 			continue
 		}
 
+		if groupName, ok := getNodeGroupName(authorizedID); ok {
+			if _, ok := groups[groupName]; !ok {
+				return Permissions{}, errors.BadRequest("invalid permission input values: node group %s not found", groupName)
+			}
+			continue
+		}
 		if !stringInSlice(authorizedID, nodesIDs) {
-			return Permissions{}, fmt.Errorf("Invalid permission input values")
+			return Permissions{}, errors.BadRequest("invalid permission input values: node %s not found", authorizedID)
+		}
+	}
+	for authorizedID, validity := range in.Process.Validities {
+		if !stringInSlice(authorizedID, in.Process.AuthorizedIDs) {
+			return Permissions{}, errors.BadRequest("invalid permission input values: validity given for %s which is not authorized", authorizedID)
+		}
+		if validity.NotBefore != 0 && validity.NotAfter != 0 && validity.NotAfter < validity.NotBefore {
+			return Permissions{}, errors.BadRequest("invalid permission input values: validity of %s ends before it starts", authorizedID)
 		}
 	}
 
@@ -105,10 +184,17 @@ func newPermission(in inputPermission, owner string) Permission {
 	if !stringInSlice(owner, in.AuthorizedIDs) {
 		in.AuthorizedIDs = append([]string{owner}, in.AuthorizedIDs...)
 	}
-	return Permission{
+	permission := Permission{
 		Public:        in.Public,
 		AuthorizedIDs: in.AuthorizedIDs,
 	}
+	if len(in.Validities) > 0 {
+		permission.Validities = map[string]Validity{}
+		for authorizedID, validity := range in.Validities {
+			permission.Validities[authorizedID] = Validity{NotBefore: validity.NotBefore, NotAfter: validity.NotAfter}
+		}
+	}
+	return permission
 }
 
 func (priv Permission) include(other Permission) bool {
@@ -127,36 +213,294 @@ func (priv Permission) include(other Permission) bool {
 }
 
 // MergePermissions returns the intersection of input permissions
-func MergePermissions(x, y Permissions) Permissions {
+func MergePermissions(x, y Permissions, groups NodeGroups) Permissions {
 	perm := Permissions{}
-	perm.Process = mergePermissions(x.Process, y.Process)
-	perm.Download = mergePermissions(x.Download, y.Download)
+	perm.Process = mergePermissions(x.Process, y.Process, groups)
+	perm.Download = mergePermissions(x.Download, y.Download, groups)
 	return perm
 }
 
-func mergePermissions(x, y Permission) Permission {
+func mergePermissions(x, y Permission, groups NodeGroups) Permission {
 	priv := Permission{}
 	priv.Public = x.Public && y.Public
 
 	if !x.Public && y.Public {
 		priv.AuthorizedIDs = x.AuthorizedIDs
+		priv.Validities = x.Validities
 	} else if x.Public && !y.Public {
 		priv.AuthorizedIDs = y.AuthorizedIDs
+		priv.Validities = y.Validities
 	} else {
-		priv.AuthorizedIDs = x.getNodesIntersection(y)
+		priv.AuthorizedIDs = x.getNodesIntersection(y, groups)
+		// the access of each node is limited by both validity windows
+		for _, id := range priv.AuthorizedIDs {
+			validity := x.getValidity(id, groups).intersect(y.getValidity(id, groups))
+			if validity == (Validity{}) {
+				continue
+			}
+			if priv.Validities == nil {
+				priv.Validities = map[string]Validity{}
+			}
+			priv.Validities[id] = validity
+		}
 	}
 	return priv
 }
 
-func (priv Permission) getNodesIntersection(p Permission) []string {
+// isGrantedBy checks if the authorized ID grants the access to the node,
+// either directly or through a node group
+func (priv Permission) isGrantedBy(id, node string, groups NodeGroups) bool {
+	if id == node {
+		return true
+	}
+	groupName, ok := getNodeGroupName(id)
+	return ok && stringInSlice(node, groups[groupName])
+}
+
+// getValidity returns the validity window of an authorized ID. For a node only
+// authorized through a node group, it is the validity of the node group.
+func (priv Permission) getValidity(id string, groups NodeGroups) Validity {
+	if stringInSlice(id, priv.AuthorizedIDs) {
+		return priv.Validities[id]
+	}
+	for _, authorizedID := range priv.AuthorizedIDs {
+		if priv.isGrantedBy(authorizedID, id, groups) {
+			return priv.Validities[authorizedID]
+		}
+	}
+	return Validity{}
+}
+
+// getNodesIntersection returns the node groups referenced by both permissions
+// and the other nodes authorized by both of them
+func (priv Permission) getNodesIntersection(p Permission, groups NodeGroups) []string {
 	nodes := []string{}
-	for _, i := range priv.AuthorizedIDs {
-		for _, j := range p.AuthorizedIDs {
-			if i == j {
-				nodes = append(nodes, i)
-				break
+	covered := []string{}
+	for _, id := range priv.AuthorizedIDs {
+		if groupName, ok := getNodeGroupName(id); ok && stringInSlice(id, p.AuthorizedIDs) {
+			nodes = append(nodes, id)
+			covered = append(covered, groups[groupName]...)
+		}
+	}
+	otherNodes := p.expand(groups)
+	for _, node := range priv.expand(groups) {
+		if stringInSlice(node, otherNodes) && !stringInSlice(node, covered) {
+			nodes = append(nodes, node)
+			covered = append(covered, node)
+		}
+	}
+	return nodes
+}
+
+// expand returns the authorized node IDs, the node groups being replaced by their nodes
+func (priv Permission) expand(groups NodeGroups) []string {
+	nodes := []string{}
+	for _, id := range priv.AuthorizedIDs {
+		ids := []string{id}
+		if groupName, ok := getNodeGroupName(id); ok {
+			ids = groups[groupName]
+		}
+		for _, node := range ids {
+			if !stringInSlice(node, nodes) {
+				nodes = append(nodes, node)
 			}
 		}
 	}
 	return nodes
 }
+
+// getNodeGroupName returns the name of the node group if the ID is a reference to a node group
+func getNodeGroupName(id string) (string, bool) {
+	if !strings.HasPrefix(id, NodeGroupPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(id, NodeGroupPrefix), true
+}
+
+// -------------------------------------------------------------------------------------------
+// Smart contracts related to permissions
+// -------------------------------------------------------------------------------------------
+
+// updatePermissions replaces the permissions of an algo, a dataManager or an
+// objective. Only the owner of the asset can update them. The tuples already
+// created keep their permissions, the new ones use the updated permissions.
+// Each change is recorded in the history of the asset.
+func updatePermissions(db LedgerDB, inp inputUpdatePermissions) (out outputPermissionsUpdate, err error) {
+	permissions, err := NewPermissions(db, inp.Permissions)
+	if err != nil {
+		return
+	}
+
+	var asset struct {
+		AssetType AssetType `json:"assetType"`
+	}
+	if err = db.Get(inp.Key, &asset); err != nil {
+		return
+	}
+	var previousPermissions Permissions
+	switch asset.AssetType {
+	case AlgoType:
+		var algo Algo
+		if algo, err = db.GetAlgo(inp.Key); err != nil {
+			return
+		}
+		if err = checkAssetOwner(db, "algo", inp.Key, algo.Owner); err != nil {
+			return
+		}
+		previousPermissions = algo.Permissions
+		algo.Permissions = permissions
+		err = db.Put(inp.Key, algo)
+	case DataManagerType:
+		var dataManager DataManager
+		if dataManager, err = db.GetDataManager(inp.Key); err != nil {
+			return
+		}
+		if err = checkAssetOwner(db, "dataManager", inp.Key, dataManager.Owner); err != nil {
+			return
+		}
+		previousPermissions = dataManager.Permissions
+		dataManager.Permissions = permissions
+		err = db.Put(inp.Key, dataManager)
+	case ObjectiveType:
+		var objective Objective
+		if objective, err = db.GetObjective(inp.Key); err != nil {
+			return
+		}
+		if err = checkAssetOwner(db, "objective", inp.Key, objective.Owner); err != nil {
+			return
+		}
+		previousPermissions = objective.Permissions
+		objective.Permissions = permissions
+		err = db.Put(inp.Key, objective)
+	default:
+		err = errors.BadRequest("the permissions of the asset %s can't be updated", inp.Key)
+	}
+	if err != nil {
+		return
+	}
+
+	// record the change at the end of the asset's history
+	historyKeys, err := db.GetIndexKeys("permissionsUpdate~asset~key", []string{"permissionsUpdate", inp.Key})
+	if err != nil {
+		return
+	}
+	creator, err := GetTxCreator(db.cc)
+	if err != nil {
+		return
+	}
+	permissionsUpdate := PermissionsUpdate{
+		AssetType:           PermissionsUpdateType,
+		AssetKey:            inp.Key,
+		Index:               len(historyKeys),
+		Creator:             creator,
+		PreviousPermissions: previousPermissions,
+		Permissions:         permissions,
+	}
+	permissionsUpdateKey := HashForKey("permissionsUpdate", inp.Key, strconv.Itoa(permissionsUpdate.Index))
+	if err = db.Add(permissionsUpdateKey, permissionsUpdate); err != nil {
+		return
+	}
+	if err = db.CreateIndex("permissionsUpdate~asset~key", []string{"permissionsUpdate", inp.Key, permissionsUpdateKey}); err != nil {
+		return
+	}
+	out.Fill(permissionsUpdateKey, permissionsUpdate)
+	return
+}
+
+// queryPermissionsHistory returns the changes of the permissions of an asset,
+// from the oldest to the most recent one
+func queryPermissionsHistory(db LedgerDB, inp inputHash) (outUpdates []outputPermissionsUpdate, err error) {
+	outUpdates = []outputPermissionsUpdate{}
+	keys, err := db.GetIndexKeys("permissionsUpdate~asset~key", []string{"permissionsUpdate", inp.Key})
+	if err != nil {
+		return
+	}
+	for _, key := range keys {
+		permissionsUpdate, err := db.GetPermissionsUpdate(key)
+		if err != nil {
+			return outUpdates, err
+		}
+		var out outputPermissionsUpdate
+		out.Fill(key, permissionsUpdate)
+		outUpdates = append(outUpdates, out)
+	}
+	sort.Slice(outUpdates, func(i, j int) bool {
+		return outUpdates[i].Index < outUpdates[j].Index
+	})
+	return
+}
+
+// queryAccessReport lists the nodes which can process a model derived from an
+// algo or a dataManager: trained by a traintuple using the asset, or by one of
+// its descendants. For each node, the shortest path of traintuples granting
+// the access is given.
+func queryAccessReport(db LedgerDB, inp inputHash) (out outputAccessReport, err error) {
+	var asset struct {
+		AssetType AssetType `json:"assetType"`
+	}
+	if err = db.Get(inp.Key, &asset); err != nil {
+		return
+	}
+	var indexName string
+	switch asset.AssetType {
+	case AlgoType:
+		indexName = "traintuple~algo~key"
+	case DataManagerType:
+		indexName = "traintuple~dataManager~key"
+	default:
+		err = errors.BadRequest("no access report for the asset %s, it should be an algo or a dataManager", inp.Key)
+		return
+	}
+	traintupleKeys, err := db.GetIndexKeys(indexName, []string{"traintuple", inp.Key})
+	if err != nil {
+		return
+	}
+	nodes, err := queryNodes(db)
+	if err != nil {
+		return
+	}
+	permissionsContext, err := getPermissionsContext(db)
+	if err != nil {
+		return
+	}
+
+	out.AssetKey = inp.Key
+	out.Nodes = []outputNodeAccess{}
+	paths := map[string][]string{}
+	for _, traintupleKey := range traintupleKeys {
+		paths[traintupleKey] = []string{traintupleKey}
+	}
+	grantedNodes := map[string]bool{}
+	// walk the traintuples breadth first so that the shortest path is found first
+	for len(traintupleKeys) > 0 {
+		traintupleKey := traintupleKeys[0]
+		traintupleKeys = traintupleKeys[1:]
+		traintuple, err := db.GetTraintuple(traintupleKey)
+		if err != nil {
+			return out, err
+		}
+		for _, node := range nodes {
+			if grantedNodes[node.ID] || !traintuple.Permissions.CanProcess(traintuple.Creator, node.ID, permissionsContext) {
+				continue
+			}
+			grantedNodes[node.ID] = true
+			out.Nodes = append(out.Nodes, outputNodeAccess{NodeID: node.ID, Path: paths[traintupleKey]})
+		}
+		childrenKeys, err := db.GetIndexKeys("traintuple~inModel~key", []string{"traintuple", traintupleKey})
+		if err != nil {
+			return out, err
+		}
+		for _, childKey := range childrenKeys {
+			if _, ok := paths[childKey]; ok {
+				continue
+			}
+			path := append([]string{}, paths[traintupleKey]...)
+			paths[childKey] = append(path, childKey)
+			traintupleKeys = append(traintupleKeys, childKey)
+		}
+	}
+	sort.Slice(out.Nodes, func(i, j int) bool {
+		return out.Nodes[i].NodeID < out.Nodes[j].NodeID
+	})
+	return
+}
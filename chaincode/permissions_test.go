@@ -15,9 +15,11 @@
 package main
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var (
@@ -51,7 +53,7 @@ func TestPermissionsCanProcess(t *testing.T) {
 			perms.Process.Public = test.public
 			perms.Process.AuthorizedIDs = test.authorizedIDs
 
-			access := perms.CanProcess(defaultOwner, test.node)
+			access := perms.CanProcess(defaultOwner, test.node, PermissionsContext{})
 			assert.Equal(t, test.expectedAccess, access)
 		})
 	}
@@ -103,19 +105,296 @@ func TestMergingMechanism(t *testing.T) {
 				Public:        test.toMergeINR,
 				AuthorizedIDs: test.toMergeRU,
 			}
-			mergedPriv := mergePermissions(defaultPermission, toMerge)
+			mergedPriv := mergePermissions(defaultPermission, toMerge, nil)
 			assert.Equal(t, test.expectedINR, mergedPriv.Public)
 			assert.ElementsMatch(t, test.expectedRU, mergedPriv.AuthorizedIDs)
-			privMerged := mergePermissions(toMerge, defaultPermission)
+			privMerged := mergePermissions(toMerge, defaultPermission, nil)
 			assert.Equal(t, mergedPriv.Public, privMerged.Public, "merging should be transitif")
 			assert.ElementsMatch(t, mergedPriv.AuthorizedIDs, privMerged.AuthorizedIDs, "merging should be transitif")
 
-			theSamePriv := mergePermissions(Permission{Public: true}, toMerge)
+			theSamePriv := mergePermissions(Permission{Public: true}, toMerge, nil)
 			assert.Equal(t, toMerge.Public, theSamePriv.Public, "a non restrictive permission should be neutral")
 			assert.ElementsMatch(t, toMerge.AuthorizedIDs, theSamePriv.AuthorizedIDs, "a non restrictive permission should be neutral")
-			theSamePriv = mergePermissions(toMerge, Permission{Public: true})
+			theSamePriv = mergePermissions(toMerge, Permission{Public: true}, nil)
 			assert.Equal(t, toMerge.Public, theSamePriv.Public, "neutral element should be transitive")
 			assert.ElementsMatch(t, toMerge.AuthorizedIDs, theSamePriv.AuthorizedIDs, "neutral element should be transitive")
 		})
 	}
 }
+
+func TestUpdatePermissions(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "traintuple")
+
+	private := inputPermissions{Process: inputPermission{Public: false, AuthorizedIDs: []string{worker}}}
+
+	// Unknown node
+	inp := inputUpdatePermissions{
+		Key:         algoHash,
+		Permissions: inputPermissions{Process: inputPermission{Public: false, AuthorizedIDs: []string{"unknownOrg"}}},
+	}
+	resp := mockStub.MockInvoke("42", methodAndAssetToByte("updatePermissions", inp))
+	assert.EqualValuesf(t, 400, resp.Status, "when authorizing an unknown node, status %d and message %s", resp.Status, resp.Message)
+
+	// Unknown asset
+	inp = inputUpdatePermissions{Key: modelHash, Permissions: private}
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("updatePermissions", inp))
+	assert.EqualValuesf(t, 404, resp.Status, "when updating the permissions of an unknown asset, status %d and message %s", resp.Status, resp.Message)
+
+	// Asset without permissions to update
+	inp = inputUpdatePermissions{Key: trainDataSampleHash1, Permissions: private}
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("updatePermissions", inp))
+	assert.EqualValuesf(t, 400, resp.Status, "when updating the permissions of a dataSample, status %d and message %s", resp.Status, resp.Message)
+
+	for _, key := range []string{algoHash, dataManagerOpenerHash, objectiveDescriptionHash} {
+		inp = inputUpdatePermissions{Key: key, Permissions: private}
+		resp = mockStub.MockInvoke("42", methodAndAssetToByte("updatePermissions", inp))
+		assert.EqualValuesf(t, 200, resp.Status, "when updating the permissions of %s, status %d and message %s", key, resp.Status, resp.Message)
+	}
+
+	// The algo has the new permissions
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("queryAlgo"), keyToJSON(algoHash)})
+	algo := outputAlgo{}
+	err := json.Unmarshal(resp.Payload, &algo)
+	assert.NoError(t, err)
+	assert.Equal(t, Permission{Public: false, AuthorizedIDs: []string{worker}}, algo.Permissions.Process)
+
+	// The existing traintuple keeps its permissions
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("queryTraintuple"), keyToJSON(traintupleKey)})
+	traintuple := outputTraintuple{}
+	err = json.Unmarshal(resp.Payload, &traintuple)
+	assert.NoError(t, err)
+	assert.True(t, traintuple.Permissions.Process.Public)
+
+	// The changes are recorded in the history
+	inp = inputUpdatePermissions{Key: algoHash, Permissions: inputPermissions{Process: inputPermission{Public: true, AuthorizedIDs: []string{}}}}
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("updatePermissions", inp))
+	require.EqualValuesf(t, 200, resp.Status, "when updating the permissions of the algo, status %d and message %s", resp.Status, resp.Message)
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("queryPermissionsHistory"), keyToJSON(algoHash)})
+	require.EqualValuesf(t, 200, resp.Status, "when querying the permissions history, status %d and message %s", resp.Status, resp.Message)
+	history := []outputPermissionsUpdate{}
+	err = json.Unmarshal(resp.Payload, &history)
+	assert.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, 0, history[0].Index)
+	assert.True(t, history[0].PreviousPermissions.Process.Public)
+	assert.False(t, history[0].Permissions.Process.Public)
+	assert.Equal(t, 1, history[1].Index)
+	assert.False(t, history[1].PreviousPermissions.Process.Public)
+	assert.True(t, history[1].Permissions.Process.Public)
+}
+
+func TestNodeGroupsInPermissions(t *testing.T) {
+	groups := NodeGroups{
+		"hospitals": []string{"foo", "bar"},
+		"labs":      []string{"baz"},
+	}
+	perms := Permissions{Process: Permission{AuthorizedIDs: []string{NodeGroupPrefix + "hospitals"}}}
+	assert.True(t, perms.CanProcess(defaultOwner, "bar", PermissionsContext{Groups: groups}))
+	assert.False(t, perms.CanProcess(defaultOwner, "baz", PermissionsContext{Groups: groups}))
+	groups["hospitals"] = append(groups["hospitals"], "baz")
+	assert.True(t, perms.CanProcess(defaultOwner, "baz", PermissionsContext{Groups: groups}), "a node added to a group should be authorized")
+
+	testTable := []struct {
+		name       string
+		x          []string
+		y          []string
+		expectedRU []string
+	}{
+		{"Same group", []string{"group:hospitals"}, []string{"group:hospitals"}, []string{"group:hospitals"}},
+		{"Group and node", []string{"group:hospitals"}, []string{"bar", "qux"}, []string{"bar"}},
+		{"Different groups", []string{"group:hospitals"}, []string{"group:labs"}, []string{"baz"}},
+		{"Same group and other nodes", []string{"group:labs", "foo"}, []string{"group:labs", "foo", "bar"}, []string{"group:labs", "foo"}},
+	}
+	for _, test := range testTable {
+		t.Run(test.name, func(t *testing.T) {
+			x := Permission{AuthorizedIDs: test.x}
+			y := Permission{AuthorizedIDs: test.y}
+			assert.ElementsMatch(t, test.expectedRU, mergePermissions(x, y, groups).AuthorizedIDs)
+			assert.ElementsMatch(t, test.expectedRU, mergePermissions(y, x, groups).AuthorizedIDs)
+		})
+	}
+}
+
+func TestNodeGroup(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "algo")
+
+	// Unknown node
+	inp := inputNodeGroup{Name: "hospitals", NodeIDs: []string{"unknownOrg"}}
+	resp := mockStub.MockInvoke("42", methodAndAssetToByte("registerNodeGroup", inp))
+	assert.EqualValuesf(t, 400, resp.Status, "when registering a node group with an unknown node, status %d and message %s", resp.Status, resp.Message)
+
+	inp = inputNodeGroup{Name: "hospitals", NodeIDs: []string{worker}}
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("registerNodeGroup", inp))
+	require.EqualValuesf(t, 200, resp.Status, "when registering a node group, status %d and message %s", resp.Status, resp.Message)
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("registerNodeGroup", inp))
+	assert.EqualValuesf(t, 409, resp.Status, "when registering a node group twice, status %d and message %s", resp.Status, resp.Message)
+
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("updateNodeGroup", inp))
+	assert.EqualValuesf(t, 200, resp.Status, "when updating a node group, status %d and message %s", resp.Status, resp.Message)
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("updateNodeGroup", inputNodeGroup{Name: "labs", NodeIDs: []string{worker}}))
+	assert.EqualValuesf(t, 404, resp.Status, "when updating an unknown node group, status %d and message %s", resp.Status, resp.Message)
+
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("queryNodeGroups")})
+	require.EqualValuesf(t, 200, resp.Status, "when querying the node groups, status %d and message %s", resp.Status, resp.Message)
+	nodeGroups := []outputNodeGroup{}
+	err := json.Unmarshal(resp.Payload, &nodeGroups)
+	assert.NoError(t, err)
+	assert.Equal(t, []outputNodeGroup{{Name: "hospitals", Owner: worker, NodeIDs: []string{worker}}}, nodeGroups)
+
+	// The group can be used in the permissions of an asset
+	inpPermissions := inputUpdatePermissions{
+		Key:         algoHash,
+		Permissions: inputPermissions{Process: inputPermission{AuthorizedIDs: []string{NodeGroupPrefix + "labs"}}},
+	}
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("updatePermissions", inpPermissions))
+	assert.EqualValuesf(t, 400, resp.Status, "when sharing an asset with an unknown node group, status %d and message %s", resp.Status, resp.Message)
+	inpPermissions.Permissions.Process.AuthorizedIDs = []string{NodeGroupPrefix + "hospitals"}
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("updatePermissions", inpPermissions))
+	assert.EqualValuesf(t, 200, resp.Status, "when sharing an asset with a node group, status %d and message %s", resp.Status, resp.Message)
+}
+
+func TestPermissionsValidity(t *testing.T) {
+	groups := NodeGroups{"hospitals": []string{"bar"}}
+	perms := Permissions{Process: Permission{
+		AuthorizedIDs: []string{"foo", NodeGroupPrefix + "hospitals"},
+		Validities: map[string]Validity{
+			"foo":                         {NotBefore: 100, NotAfter: 200},
+			NodeGroupPrefix + "hospitals": {NotAfter: 150},
+		},
+	}}
+	testTable := []struct {
+		name           string
+		node           string
+		timestamp      int64
+		expectedAccess bool
+	}{
+		{"Before the agreement", "foo", 50, false},
+		{"During the agreement", "foo", 150, true},
+		{"After the agreement", "foo", 250, false},
+		{"During the group agreement", "bar", 100, true},
+		{"After the group agreement", "bar", 160, false},
+		{"Owner is not limited", defaultOwner, 1000, true},
+	}
+	for _, test := range testTable {
+		t.Run(test.name, func(t *testing.T) {
+			access := perms.CanProcess(defaultOwner, test.node, PermissionsContext{Groups: groups, Timestamp: test.timestamp})
+			assert.Equal(t, test.expectedAccess, access)
+		})
+	}
+
+	// Merging keeps the strictest window of each node
+	other := Permission{
+		AuthorizedIDs: []string{"foo", "bar"},
+		Validities:    map[string]Validity{"foo": {NotAfter: 180}},
+	}
+	merged := mergePermissions(perms.Process, other, groups)
+	assert.ElementsMatch(t, []string{"foo", "bar"}, merged.AuthorizedIDs)
+	assert.Equal(t, map[string]Validity{"foo": {NotBefore: 100, NotAfter: 180}, "bar": {NotAfter: 150}}, merged.Validities)
+}
+
+func TestUpdatePermissionsWithValidity(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "algo")
+
+	inp := inputUpdatePermissions{
+		Key: algoHash,
+		Permissions: inputPermissions{Process: inputPermission{
+			AuthorizedIDs: []string{},
+			Validities:    map[string]inputValidity{worker: {NotAfter: 1}},
+		}},
+	}
+	resp := mockStub.MockInvoke("42", methodAndAssetToByte("updatePermissions", inp))
+	assert.EqualValuesf(t, 400, resp.Status, "when giving a validity to a node which is not authorized, status %d and message %s", resp.Status, resp.Message)
+
+	inp.Permissions.Process.AuthorizedIDs = []string{worker}
+	inp.Permissions.Process.Validities = map[string]inputValidity{worker: {NotBefore: 20, NotAfter: 10}}
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("updatePermissions", inp))
+	assert.EqualValuesf(t, 400, resp.Status, "when giving a validity which ends before it starts, status %d and message %s", resp.Status, resp.Message)
+
+	inp.Permissions.Process.Validities = map[string]inputValidity{worker: {NotBefore: 10, NotAfter: 20}}
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("updatePermissions", inp))
+	require.EqualValuesf(t, 200, resp.Status, "when giving a validity, status %d and message %s", resp.Status, resp.Message)
+
+	// The validity windows are reported
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("queryAlgo"), keyToJSON(algoHash)})
+	algo := outputAlgo{}
+	err := json.Unmarshal(resp.Payload, &algo)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]Validity{worker: {NotBefore: 10, NotAfter: 20}}, algo.Permissions.Process.Validities)
+}
+
+func TestQueryAccessReport(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "algo")
+	mockStub.MockTransactionStart("42")
+	db := NewLedgerDB(mockStub)
+	for _, nodeID := range []string{"hospitalA", "hospitalB"} {
+		require.NoError(t, db.Put(nodeID, Node{ID: nodeID}))
+		require.NoError(t, db.CreateIndex("node~key", []string{"node", nodeID}))
+	}
+	mockStub.MockTransactionEnd("42")
+
+	// The first traintuple is public
+	inpTraintuple := inputTraintuple{DataSampleKeys: []string{trainDataSampleHash1}}
+	resp := mockStub.MockInvoke("42", inpTraintuple.createDefault())
+	require.EqualValuesf(t, 200, resp.Status, "when adding a traintuple, status %d and message %s", resp.Status, resp.Message)
+	parentKey := map[string]string{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &parentKey))
+
+	// its child uses another algo which is private to its owner
+	inpAlgo := inputAlgo{Hash: modelHash}
+	resp = mockStub.MockInvoke("42", inpAlgo.createDefault())
+	require.EqualValuesf(t, 200, resp.Status, "when adding an algo, status %d and message %s", resp.Status, resp.Message)
+	inpPermissions := inputUpdatePermissions{
+		Key:         modelHash,
+		Permissions: inputPermissions{Process: inputPermission{AuthorizedIDs: []string{worker}}},
+	}
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("updatePermissions", inpPermissions))
+	require.EqualValuesf(t, 200, resp.Status, "when updating the permissions, status %d and message %s", resp.Status, resp.Message)
+	inpTraintuple = inputTraintuple{AlgoKey: modelHash, DataSampleKeys: []string{trainDataSampleHash2}, InModels: []string{parentKey["key"]}}
+	resp = mockStub.MockInvoke("42", inpTraintuple.createDefault())
+	require.EqualValuesf(t, 200, resp.Status, "when adding a traintuple, status %d and message %s", resp.Status, resp.Message)
+	childKey := map[string]string{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &childKey))
+
+	// all the nodes reach the model of the parent
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("queryAccessReport"), keyToJSON(algoHash)})
+	require.EqualValuesf(t, 200, resp.Status, "when querying the access report, status %d and message %s", resp.Status, resp.Message)
+	report := outputAccessReport{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &report))
+	assert.Equal(t, algoHash, report.AssetKey)
+	expectedNodes := []outputNodeAccess{
+		{NodeID: worker, Path: []string{parentKey["key"]}},
+		{NodeID: "hospitalA", Path: []string{parentKey["key"]}},
+		{NodeID: "hospitalB", Path: []string{parentKey["key"]}},
+	}
+	assert.Equal(t, expectedNodes, report.Nodes)
+
+	// only the owner reaches the model of the child
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("queryAccessReport"), keyToJSON(modelHash)})
+	require.EqualValuesf(t, 200, resp.Status, "when querying the access report, status %d and message %s", resp.Status, resp.Message)
+	report = outputAccessReport{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &report))
+	expectedNodes = []outputNodeAccess{{NodeID: worker, Path: []string{childKey["key"]}}}
+	assert.Equal(t, expectedNodes, report.Nodes)
+
+	// the child uses the dataManager too so all the paths are direct
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("queryAccessReport"), keyToJSON(dataManagerOpenerHash)})
+	require.EqualValuesf(t, 200, resp.Status, "when querying the access report, status %d and message %s", resp.Status, resp.Message)
+	report = outputAccessReport{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &report))
+	require.Len(t, report.Nodes, 3)
+	for i, nodeID := range []string{worker, "hospitalA", "hospitalB"} {
+		assert.Equal(t, nodeID, report.Nodes[i].NodeID)
+		assert.Len(t, report.Nodes[i].Path, 1)
+	}
+
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("queryAccessReport"), keyToJSON(trainDataSampleHash1)})
+	assert.EqualValuesf(t, 400, resp.Status, "when querying the access report of a dataSample, status %d and message %s", resp.Status, resp.Message)
+}
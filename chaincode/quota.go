@@ -0,0 +1,277 @@
+// Copyright 2018 Owkin, inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"chaincode/errors"
+	stderrors "errors"
+)
+
+// secondsPerDay is used to get the day of a timestamp for the daily quotas
+const secondsPerDay = 24 * 60 * 60
+
+// -------------------------------------------------------------------------------------------
+// Smart contracts related to quotas
+// -------------------------------------------------------------------------------------------
+
+// setQuota sets the quota of a node on a dataManager. Only the owner of the
+// dataManager can set it. The usage of the node is computed when the quota is
+// created and then kept up to date as tuples are created and processed.
+func setQuota(db LedgerDB, inp inputQuota) (out outputQuota, err error) {
+	dataManager, err := db.GetDataManager(inp.DataManagerKey)
+	if err != nil {
+		return
+	}
+	if err = checkAssetOwner(db, "dataManager", inp.DataManagerKey, dataManager.Owner); err != nil {
+		return
+	}
+	if err = checkNodeIDs(db, []string{inp.NodeID}); err != nil {
+		return
+	}
+	today, err := getToday(db)
+	if err != nil {
+		return
+	}
+
+	quota, exists, err := getQuota(db, inp.DataManagerKey, inp.NodeID)
+	if err != nil {
+		return
+	}
+	if !exists {
+		quota = Quota{
+			AssetType:      QuotaType,
+			DataManagerKey: inp.DataManagerKey,
+			NodeID:         inp.NodeID,
+			Owner:          dataManager.Owner,
+		}
+		if err = quota.computeUsage(db, today); err != nil {
+			return
+		}
+		if err = db.CreateIndex("quota~dataManager~key", []string{"quota", inp.DataManagerKey, getQuotaKey(inp.DataManagerKey, inp.NodeID)}); err != nil {
+			return
+		}
+	}
+	quota.MaxActiveTuples = inp.MaxActiveTuples
+	quota.MaxTuplesPerDay = inp.MaxTuplesPerDay
+	if err = db.Put(getQuotaKey(inp.DataManagerKey, inp.NodeID), quota); err != nil {
+		return
+	}
+	activeTuples, dayTuples, err := quota.getUsage(db, today)
+	if err != nil {
+		return
+	}
+	out.Fill(quota, activeTuples, dayTuples)
+	return
+}
+
+// queryQuota returns the quota of a node on a dataManager and its remaining allowance
+func queryQuota(db LedgerDB, inp inputQuotaKey) (out outputQuota, err error) {
+	quota, err := db.GetQuota(inp.DataManagerKey, inp.NodeID)
+	if err != nil {
+		return
+	}
+	today, err := getToday(db)
+	if err != nil {
+		return
+	}
+	activeTuples, dayTuples, err := quota.getUsage(db, today)
+	if err != nil {
+		return
+	}
+	out.Fill(quota, activeTuples, dayTuples)
+	return
+}
+
+// -------------------------------------------------------------------------------------------
+// Utils for quotas
+// -------------------------------------------------------------------------------------------
+
+// getQuotaKey returns the key under which the quota of a node on a dataManager is stored
+func getQuotaKey(dataManagerKey, nodeID string) string {
+	return HashForKey("quota", dataManagerKey, nodeID)
+}
+
+// getQuotaUsageKey returns the key under which the usage of the quota of a
+// node on a dataManager is stored
+func getQuotaUsageKey(dataManagerKey, nodeID string) string {
+	return HashForKey("quotaUsage", dataManagerKey, nodeID)
+}
+
+// getToday returns the day of the transaction, as a number of days since the epoch
+func getToday(db LedgerDB) (int64, error) {
+	timestamp, err := GetTxTimestamp(db.cc)
+	if err != nil {
+		return 0, err
+	}
+	return timestamp / secondsPerDay, nil
+}
+
+// getQuota returns the quota of a node on a dataManager if any
+func getQuota(db LedgerDB, dataManagerKey, nodeID string) (quota Quota, exists bool, err error) {
+	exists, err = db.KeyExists(getQuotaKey(dataManagerKey, nodeID))
+	if err != nil || !exists {
+		return
+	}
+	quota, err = db.GetQuota(dataManagerKey, nodeID)
+	return
+}
+
+// consumeQuota checks that a node can submit a new tuple on a dataManager and
+// accounts for it in the node's usage. A tuple waiting for its parents is not
+// active yet so it is only limited by the daily quota. It does nothing if
+// there is no quota.
+// It is called when saving the tuple rather than in SetFromInput since the
+// status of the tuple is only set afterwards, from its parents.
+func consumeQuota(db LedgerDB, dataManagerKey, nodeID, status string) error {
+	quota, exists, err := getQuota(db, dataManagerKey, nodeID)
+	if err != nil || !exists {
+		return err
+	}
+	today, err := getToday(db)
+	if err != nil {
+		return err
+	}
+	activeTuples, dayTuples, err := quota.getUsage(db, today)
+	if err != nil {
+		return err
+	}
+	active := isActiveStatus(status)
+	if active && quota.MaxActiveTuples > 0 && activeTuples >= quota.MaxActiveTuples {
+		return errors.Forbidden(errors.CodeQuotaExceeded, "quota exceeded: node %s already has %d active tuples on dataManager %s", nodeID, activeTuples, dataManagerKey)
+	}
+	if quota.MaxTuplesPerDay > 0 && dayTuples >= quota.MaxTuplesPerDay {
+		return errors.Forbidden(errors.CodeQuotaExceeded, "quota exceeded: node %s already submitted %d tuples today on dataManager %s", nodeID, dayTuples, dataManagerKey)
+	}
+	return quota.updateUsage(db, today, func(usage *QuotaUsage) {
+		if active {
+			usage.ActiveTuples++
+		}
+		usage.DayTuples++
+	})
+}
+
+// updateActiveTuples accounts for a tuple which becomes active or stops being
+// active in the usage of the quota of its creator on its dataManager. It does
+// nothing if there is no quota.
+func updateActiveTuples(db LedgerDB, dataManagerKey, nodeID, oldStatus, newStatus string) error {
+	if isActiveStatus(oldStatus) == isActiveStatus(newStatus) {
+		return nil
+	}
+	quota, exists, err := getQuota(db, dataManagerKey, nodeID)
+	if err != nil || !exists {
+		return err
+	}
+	today, err := getToday(db)
+	if err != nil {
+		return err
+	}
+	return quota.updateUsage(db, today, func(usage *QuotaUsage) {
+		if isActiveStatus(newStatus) {
+			usage.ActiveTuples++
+		} else {
+			usage.ActiveTuples--
+		}
+	})
+}
+
+// isActiveStatus checks if a tuple with this status is to be processed or
+// being processed
+func isActiveStatus(status string) bool {
+	return status == StatusTodo || status == StatusDoing
+}
+
+// getUsageRecord returns the usage of the quota, which is empty if no tuple
+// was counted yet
+func (quota *Quota) getUsageRecord(db LedgerDB) (QuotaUsage, error) {
+	usage, err := db.GetQuotaUsage(quota.DataManagerKey, quota.NodeID)
+	if stderrors.Is(err, errors.NotFound()) {
+		return QuotaUsage{AssetType: QuotaUsageType}, nil
+	}
+	return usage, err
+}
+
+// getUsage returns the number of active tuples and of tuples submitted today
+// by the node
+func (quota *Quota) getUsage(db LedgerDB, today int64) (activeTuples int, dayTuples int, err error) {
+	usage, err := quota.getUsageRecord(db)
+	if err != nil {
+		return
+	}
+	activeTuples = usage.ActiveTuples
+	if usage.Day == today {
+		dayTuples = usage.DayTuples
+	}
+	return
+}
+
+// updateUsage applies an update to the usage of the quota, after resetting
+// its daily count if it is from another day
+func (quota *Quota) updateUsage(db LedgerDB, today int64, update func(usage *QuotaUsage)) error {
+	usage, err := quota.getUsageRecord(db)
+	if err != nil {
+		return err
+	}
+	if usage.Day != today {
+		usage.Day = today
+		usage.DayTuples = 0
+	}
+	update(&usage)
+	return db.Put(getQuotaUsageKey(quota.DataManagerKey, quota.NodeID), usage)
+}
+
+// computeUsage sets the usage of the quota from the tuples already submitted
+// by the node on the dataManager
+func (quota *Quota) computeUsage(db LedgerDB, today int64) error {
+	activeTuples := 0
+	dayTuples := 0
+	addUsage := func(status string, creationDate int64) {
+		if isActiveStatus(status) {
+			activeTuples++
+		}
+		if creationDate/secondsPerDay == today {
+			dayTuples++
+		}
+	}
+	traintupleKeys, err := db.GetIndexKeys("traintuple~dataManager~key", []string{"traintuple", quota.DataManagerKey})
+	if err != nil {
+		return err
+	}
+	for _, traintupleKey := range traintupleKeys {
+		traintuple, err := db.GetTraintuple(traintupleKey)
+		if err != nil {
+			return err
+		}
+		if traintuple.Creator == quota.NodeID {
+			addUsage(traintuple.Status, traintuple.CreationDate)
+		}
+	}
+	testtupleKeys, err := db.GetIndexKeys("testtuple~dataManager~key", []string{"testtuple", quota.DataManagerKey})
+	if err != nil {
+		return err
+	}
+	for _, testtupleKey := range testtupleKeys {
+		testtuple, err := db.GetTesttuple(testtupleKey)
+		if err != nil {
+			return err
+		}
+		if testtuple.Creator == quota.NodeID {
+			addUsage(testtuple.Status, testtuple.CreationDate)
+		}
+	}
+	return quota.updateUsage(db, today, func(usage *QuotaUsage) {
+		usage.ActiveTuples = activeTuples
+		usage.DayTuples = dayTuples
+	})
+}
@@ -0,0 +1,137 @@
+// Copyright 2018 Owkin, inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"chaincode/errors"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuota(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "traintuple")
+
+	inpQuota := inputQuota{DataManagerKey: dataManagerOpenerHash, NodeID: "unknownNode", MaxActiveTuples: 2}
+	resp := mockStub.MockInvoke("42", methodAndAssetToByte("setQuota", inpQuota))
+	assert.EqualValuesf(t, 400, resp.Status, "when setting the quota of an unknown node, status %d and message %s", resp.Status, resp.Message)
+
+	// The usage takes into account the existing traintuple
+	inpQuota = inputQuota{DataManagerKey: dataManagerOpenerHash, NodeID: worker, MaxActiveTuples: 2, MaxTuplesPerDay: 3}
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("setQuota", inpQuota))
+	require.EqualValuesf(t, 200, resp.Status, "when setting the quota, status %d and message %s", resp.Status, resp.Message)
+	quota := outputQuota{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &quota))
+	assert.Equal(t, 1, quota.ActiveTuples)
+	assert.Equal(t, 1, quota.DayTuples)
+	require.NotNil(t, quota.RemainingActiveTuples)
+	assert.Equal(t, 1, *quota.RemainingActiveTuples)
+	require.NotNil(t, quota.RemainingDayTuples)
+	assert.Equal(t, 2, *quota.RemainingDayTuples)
+
+	inpTraintuple := inputTraintuple{DataSampleKeys: []string{trainDataSampleHash1}}
+	resp = mockStub.MockInvoke("42", inpTraintuple.createDefault())
+	require.EqualValuesf(t, 200, resp.Status, "when adding a traintuple, status %d and message %s", resp.Status, resp.Message)
+
+	// The node has reached its maximum of active tuples
+	inpTraintuple = inputTraintuple{DataSampleKeys: []string{trainDataSampleHash2}}
+	args := inpTraintuple.createDefault()
+	resp = mockStub.MockInvoke("42", args)
+	assert.EqualValuesf(t, 403, resp.Status, "when exceeding the active tuples quota, status %d and message %s", resp.Status, resp.Message)
+	assert.Contains(t, resp.Message, "quota exceeded")
+	respError := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(resp.Payload, &respError))
+	assert.EqualValues(t, errors.CodeQuotaExceeded, respError["code"])
+
+	// until one of its tuples is done
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("logStartTrain"), keyToJSON(traintupleKey)})
+	require.EqualValuesf(t, 200, resp.Status, "when starting the traintuple, status %d and message %s", resp.Status, resp.Message)
+	success := inputLogSuccessTrain{}
+	resp = mockStub.MockInvoke("42", success.createDefault())
+	require.EqualValuesf(t, 200, resp.Status, "when logging the traintuple success, status %d and message %s", resp.Status, resp.Message)
+	resp = mockStub.MockInvoke("42", args)
+	require.EqualValuesf(t, 200, resp.Status, "when adding a traintuple, status %d and message %s", resp.Status, resp.Message)
+
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("queryQuota", inputQuotaKey{DataManagerKey: dataManagerOpenerHash, NodeID: worker}))
+	require.EqualValuesf(t, 200, resp.Status, "when querying the quota, status %d and message %s", resp.Status, resp.Message)
+	quota = outputQuota{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &quota))
+	assert.Equal(t, 2, quota.ActiveTuples)
+	assert.Equal(t, 3, quota.DayTuples)
+	assert.Equal(t, 0, *quota.RemainingDayTuples)
+
+	// Once the active tuples limit is removed, the daily limit still applies
+	inpQuota.MaxActiveTuples = 0
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("setQuota", inpQuota))
+	require.EqualValuesf(t, 200, resp.Status, "when setting the quota, status %d and message %s", resp.Status, resp.Message)
+	quota = outputQuota{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &quota))
+	assert.Nil(t, quota.RemainingActiveTuples)
+	inpTraintuple = inputTraintuple{DataSampleKeys: []string{trainDataSampleHash1}, InModels: []string{traintupleKey}}
+	resp = mockStub.MockInvoke("42", inpTraintuple.createDefault())
+	assert.EqualValuesf(t, 403, resp.Status, "when exceeding the daily quota, status %d and message %s", resp.Status, resp.Message)
+
+	resp = mockStub.MockInvoke("42", methodAndAssetToByte("queryQuota", inputQuotaKey{DataManagerKey: dataManagerOpenerHash, NodeID: "unknownNode"}))
+	assert.EqualValuesf(t, 404, resp.Status, "when querying a quota which is not set, status %d and message %s", resp.Status, resp.Message)
+}
+
+func TestQuotaWaitingTuples(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "traintuple")
+	inpTesttuple := inputTesttuple{}
+	resp := mockStub.MockInvoke("42", inpTesttuple.createDefault())
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+
+	// The tuples created before the indexes were introduced are counted
+	deleteIndex(t, mockStub, "traintuple~dataManager~key")
+	deleteIndex(t, mockStub, "testtuple~dataManager~key")
+	resp = mockStub.MockInit("42", [][]byte{[]byte("init")})
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	inpQuota := inputQuota{DataManagerKey: dataManagerOpenerHash, NodeID: worker, MaxActiveTuples: 2}
+	resp = mockStub.MockInvoke("43", methodAndAssetToByte("setQuota", inpQuota))
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	quota := outputQuota{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &quota))
+	assert.Equal(t, 1, quota.ActiveTuples, "the waiting testtuple should not be active")
+	assert.Equal(t, 2, quota.DayTuples)
+
+	// The waiting tuples of a compute plan are not active
+	resp = mockStub.MockInvoke("44", methodAndAssetToByte("createComputePlan", defaultComputePlan))
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	resp = mockStub.MockInvoke("45", methodAndAssetToByte("queryQuota", inputQuotaKey{DataManagerKey: dataManagerOpenerHash, NodeID: worker}))
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	quota = outputQuota{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &quota))
+	assert.Equal(t, 2, quota.ActiveTuples)
+	assert.Equal(t, 5, quota.DayTuples)
+
+	// The status updates are counted as the tuples become active and are processed
+	resp = mockStub.MockInvoke("46", [][]byte{[]byte("logStartTrain"), keyToJSON(traintupleKey)})
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	success := inputLogSuccessTrain{}
+	resp = mockStub.MockInvoke("47", success.createDefault())
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	resp = mockStub.MockInvoke("48", methodAndAssetToByte("queryQuota", inputQuotaKey{DataManagerKey: dataManagerOpenerHash, NodeID: worker}))
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	quota = outputQuota{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &quota))
+	// the traintuple is done and its testtuple is now todo
+	assert.Equal(t, 2, quota.ActiveTuples)
+}
@@ -0,0 +1,301 @@
+// Copyright 2018 Owkin, inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// hexadecimalPattern is the pattern checked by the hexadecimal validation rule
+const hexadecimalPattern = "^(0[xX])?[0-9a-fA-F]+$"
+
+// jsonSchema is the JSON Schema (draft 7) of a value, restricted to the
+// keywords needed to describe the inputs and outputs of the smart contracts
+type jsonSchema struct {
+	Type                 string                 `json:"type,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+	PropertyNames        *jsonSchema            `json:"propertyNames,omitempty"`
+	AnyOf                []*jsonSchema          `json:"anyOf,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	Const                interface{}            `json:"const,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+	UniqueItems          bool                   `json:"uniqueItems,omitempty"`
+	MinProperties        *int                   `json:"minProperties,omitempty"`
+	MaxProperties        *int                   `json:"maxProperties,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64               `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *float64               `json:"exclusiveMaximum,omitempty"`
+}
+
+// newJSONSchema returns the schema of the json representation of a Go type,
+// including the constraints of the validate tags of its struct fields
+func newJSONSchema(t reflect.Type) *jsonSchema {
+	return schemaOf(t, map[reflect.Type]bool{})
+}
+
+// schemaOf builds the schema of a type. The structs being described are kept
+// in parents so that a recursive type is described as any value instead of
+// looping forever.
+func schemaOf(t reflect.Type, parents map[reflect.Type]bool) *jsonSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &jsonSchema{Type: "array", Items: schemaOf(t.Elem(), parents)}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: schemaOf(t.Elem(), parents)}
+	case reflect.Struct:
+		if parents[t] {
+			return &jsonSchema{}
+		}
+		parents[t] = true
+		defer delete(parents, t)
+		s := &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{}}
+		addStructFields(s, t, parents)
+		return s
+	}
+	// interfaces can hold any value
+	return &jsonSchema{}
+}
+
+// addStructFields adds the fields of a struct to the properties of its schema
+func addStructFields(s *jsonSchema, t reflect.Type, parents map[reflect.Type]bool) {
+	for _, field := range jsonFields(t) {
+		fieldSchema, required := applyRules(schemaOf(field.Type, parents), field.Type, field.Tag.Get("validate"))
+		s.Properties[field.name] = fieldSchema
+		if required {
+			s.Required = append(s.Required, field.name)
+		}
+	}
+}
+
+// jsonField is a struct field along with its name in the json representation
+type jsonField struct {
+	reflect.StructField
+	name string
+}
+
+// jsonFields returns the fields of a struct which are part of its json
+// representation. The fields of embedded structs are returned as if they were
+// part of the struct, as encoding/json does.
+func jsonFields(t reflect.Type) []jsonField {
+	fields := []jsonField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("json") == "-" {
+			continue
+		}
+		name := getJSONFieldName(field)
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			fields = append(fields, jsonFields(field.Type)...)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, jsonField{StructField: field, name: name})
+	}
+	return fields
+}
+
+// applyRules adds to a schema the constraints of a validate tag and tells if
+// the field is required. The rules following dive apply to the items of a
+// slice or to the values of a map, the ones between keys and endkeys to the
+// keys of the map.
+func applyRules(s *jsonSchema, t reflect.Type, tag string) (*jsonSchema, bool) {
+	if tag == "" {
+		return s, false
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	rules := strings.Split(tag, ",")
+	required, omitEmpty := false, false
+	self := &jsonSchema{}
+	*self = *s
+	constrained := false
+	for i := 0; i < len(rules); i++ {
+		rule, param := rules[i], ""
+		if j := strings.Index(rule, "="); j >= 0 {
+			rule, param = rule[:j], rule[j+1:]
+		}
+		switch rule {
+		case "required":
+			required = true
+			if t.Kind() == reflect.String && self.MinLength == nil {
+				self.MinLength = intPtr(1)
+			}
+		case "omitempty":
+			omitEmpty = true
+		case "dive":
+			remaining := strings.Join(rules[i+1:], ",")
+			if t.Kind() == reflect.Map && strings.HasPrefix(remaining, "keys,") {
+				end := strings.Index(remaining, ",endkeys")
+				if end < 0 {
+					end = len(remaining)
+				}
+				self.PropertyNames, _ = applyRules(&jsonSchema{Type: "string"}, reflect.TypeOf(""), remaining[len("keys,"):end])
+				remaining = strings.TrimPrefix(remaining[end:], ",endkeys")
+				remaining = strings.TrimPrefix(remaining, ",")
+			}
+			switch t.Kind() {
+			case reflect.Slice, reflect.Array:
+				self.Items, _ = applyRules(self.Items, t.Elem(), remaining)
+			case reflect.Map:
+				self.AdditionalProperties, _ = applyRules(self.AdditionalProperties, t.Elem(), remaining)
+			}
+			i = len(rules)
+		default:
+			constrained = applyRule(self, t.Kind(), rule, param) || constrained
+		}
+	}
+	// an empty value is valid whatever the other constraints
+	if omitEmpty && constrained {
+		return &jsonSchema{AnyOf: []*jsonSchema{emptySchema(self.Type, t.Kind()), self}}, required
+	}
+	return self, required
+}
+
+// applyRule adds the constraint of a validation rule to a schema and tells if
+// it constrains the value. The sizes apply to the length of the strings, the
+// number of items of the arrays and maps and to the value of the numbers.
+func applyRule(s *jsonSchema, kind reflect.Kind, rule, param string) bool {
+	n, _ := strconv.Atoi(param)
+	f, _ := strconv.ParseFloat(param, 64)
+	switch rule {
+	case "len":
+		return setBounds(s, kind, "gte", n, f) && setBounds(s, kind, "lte", n, f)
+	case "gte", "min", "lte", "max", "gt", "lt":
+		return setBounds(s, kind, rule, n, f)
+	case "hexadecimal":
+		s.Pattern = hexadecimalPattern
+	case "url":
+		s.Format = "uri"
+	case "unique":
+		s.UniqueItems = true
+	case "oneof":
+		for _, value := range strings.Fields(param) {
+			if s.Type == "integer" || s.Type == "number" {
+				v, _ := strconv.ParseFloat(value, 64)
+				s.Enum = append(s.Enum, v)
+			} else {
+				s.Enum = append(s.Enum, value)
+			}
+		}
+	default:
+		// The other rules can't be described with JSON Schema
+		return false
+	}
+	return true
+}
+
+// setBounds sets a size constraint on a schema according to the kind of the value
+func setBounds(s *jsonSchema, kind reflect.Kind, rule string, n int, f float64) bool {
+	switch kind {
+	case reflect.String:
+		switch rule {
+		case "gte", "min":
+			s.MinLength = intPtr(n)
+		case "gt":
+			s.MinLength = intPtr(n + 1)
+		case "lte", "max":
+			s.MaxLength = intPtr(n)
+		case "lt":
+			s.MaxLength = intPtr(n - 1)
+		}
+	case reflect.Slice, reflect.Array:
+		switch rule {
+		case "gte", "min":
+			s.MinItems = intPtr(n)
+		case "gt":
+			s.MinItems = intPtr(n + 1)
+		case "lte", "max":
+			s.MaxItems = intPtr(n)
+		case "lt":
+			s.MaxItems = intPtr(n - 1)
+		}
+	case reflect.Map:
+		switch rule {
+		case "gte", "min":
+			s.MinProperties = intPtr(n)
+		case "gt":
+			s.MinProperties = intPtr(n + 1)
+		case "lte", "max":
+			s.MaxProperties = intPtr(n)
+		case "lt":
+			s.MaxProperties = intPtr(n - 1)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		switch rule {
+		case "gte", "min":
+			s.Minimum = &f
+		case "gt":
+			s.ExclusiveMinimum = &f
+		case "lte", "max":
+			s.Maximum = &f
+		case "lt":
+			s.ExclusiveMaximum = &f
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// emptySchema returns the schema of the empty value of a type, which passes
+// the validation of the fields tagged with omitempty
+func emptySchema(schemaType string, kind reflect.Kind) *jsonSchema {
+	switch kind {
+	case reflect.String:
+		return &jsonSchema{Type: schemaType, MaxLength: intPtr(0)}
+	case reflect.Slice, reflect.Array:
+		return &jsonSchema{Type: schemaType, MaxItems: intPtr(0)}
+	case reflect.Map:
+		return &jsonSchema{Type: schemaType, MaxProperties: intPtr(0)}
+	case reflect.Bool:
+		return &jsonSchema{Type: schemaType, Const: false}
+	}
+	return &jsonSchema{Type: schemaType, Const: 0}
+}
+
+func intPtr(n int) *int {
+	return &n
+}
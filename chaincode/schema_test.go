@@ -0,0 +1,110 @@
+// Copyright 2018 Owkin, inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type schemaTestEmbedded struct {
+	Embedded string `validate:"required" json:"embedded"`
+}
+
+type schemaTestInput struct {
+	schemaTestEmbedded
+	Hash     string            `validate:"required,len=64,hexadecimal" json:"hash"`
+	Name     string            `validate:"omitempty,gte=1,lte=10" json:"name"`
+	Address  string            `validate:"required,url" json:"address"`
+	Status   string            `validate:"required,oneof=todo done" json:"status"`
+	Keys     []string          `validate:"required,unique,gt=0,dive,len=64,hexadecimal" json:"keys"`
+	Tags     map[string]string `validate:"omitempty,lte=20,dive,keys,gte=1,lte=64,endkeys,lte=100" json:"tags"`
+	Ratio    float64           `validate:"gt=0,lt=1" json:"ratio"`
+	Count    int               `validate:"gte=0" json:"count"`
+	Ignored  string            `json:"-"`
+	NoTag    bool
+	internal string
+}
+
+func TestJSONSchema(t *testing.T) {
+	schema := newJSONSchema(reflect.TypeOf(schemaTestInput{}))
+	schemaJSON, err := json.Marshal(schema)
+	require.NoError(t, err)
+	expected := `{
+		"type": "object",
+		"properties": {
+			"embedded": {"type": "string", "minLength": 1},
+			"hash": {"type": "string", "pattern": "^(0[xX])?[0-9a-fA-F]+$", "minLength": 64, "maxLength": 64},
+			"name": {"anyOf": [
+				{"type": "string", "maxLength": 0},
+				{"type": "string", "minLength": 1, "maxLength": 10}
+			]},
+			"address": {"type": "string", "format": "uri", "minLength": 1},
+			"status": {"type": "string", "enum": ["todo", "done"], "minLength": 1},
+			"keys": {
+				"type": "array",
+				"items": {"type": "string", "pattern": "^(0[xX])?[0-9a-fA-F]+$", "minLength": 64, "maxLength": 64},
+				"minItems": 1,
+				"uniqueItems": true
+			},
+			"tags": {"anyOf": [
+				{"type": "object", "maxProperties": 0},
+				{
+					"type": "object",
+					"additionalProperties": {"type": "string", "maxLength": 100},
+					"propertyNames": {"type": "string", "minLength": 1, "maxLength": 64},
+					"maxProperties": 20
+				}
+			]},
+			"ratio": {"type": "number", "exclusiveMinimum": 0, "exclusiveMaximum": 1},
+			"count": {"type": "integer", "minimum": 0},
+			"NoTag": {"type": "boolean"}
+		},
+		"required": ["embedded", "hash", "address", "status", "keys"]
+	}`
+	assert.JSONEq(t, expected, string(schemaJSON))
+}
+
+func TestDescribeContracts(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStub("substra", scc)
+
+	resp := mockStub.MockInvoke("42", [][]byte{[]byte("describeContracts")})
+	require.EqualValuesf(t, 200, resp.Status, "when describing the contracts, status %d and message %s", resp.Status, resp.Message)
+	out := []outputContract{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &out))
+	assert.Len(t, out, len(contracts))
+	byName := map[string]outputContract{}
+	for _, c := range out {
+		byName[c.Name] = c
+	}
+
+	registerAlgo := byName["registerAlgo"]
+	assert.Equal(t, RoleNode, registerAlgo.Role)
+	assert.False(t, registerAlgo.ReadOnly)
+	require.NotNil(t, registerAlgo.Input)
+	assert.Contains(t, registerAlgo.Input.Required, "hash")
+	assert.Equal(t, hexadecimalPattern, registerAlgo.Input.Properties["hash"].Pattern)
+	assert.Equal(t, "object", registerAlgo.Output.Type)
+
+	queryNodes := byName["queryNodes"]
+	assert.True(t, queryNodes.ReadOnly)
+	assert.Nil(t, queryNodes.Input)
+	assert.Equal(t, "array", queryNodes.Output.Type)
+}
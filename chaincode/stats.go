@@ -0,0 +1,272 @@
+// Copyright 2018 Owkin, inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"chaincode/errors"
+	stderrors "errors"
+	"hash/fnv"
+	"strconv"
+)
+
+// statsShards is the number of records over which the counters of a scope are
+// spread. A transaction only updates one of them so that concurrent
+// transactions seldom write the same keys and fail the MVCC validation.
+const statsShards = 8
+
+// statsScopeAll is the scope of the stats counting all the tuples
+const statsScopeAll = "all"
+
+// -------------------------------------------------------------------------------------------
+// Smart contracts related to stats
+// -------------------------------------------------------------------------------------------
+
+// queryStats returns the number of tuples by asset type, status and worker.
+// They are restricted to the tuples of an objective or of a compute plan if
+// one is given. The counters are kept up to date as tuples are created and
+// processed, so that it reads a fixed number of records whatever the number
+// of tuples. The tuples created before the counters were introduced are
+// counted when the chaincode is upgraded.
+func queryStats(db LedgerDB, inp inputQueryStats) (out outputStats, err error) {
+	if inp.ObjectiveKey != "" && inp.ComputePlanID != "" {
+		err = errors.BadRequest("the stats can't be restricted to both an objective and a compute plan")
+		return
+	}
+	if inp.ObjectiveKey != "" {
+		if _, err = db.GetObjective(inp.ObjectiveKey); err != nil {
+			return
+		}
+	}
+	if inp.ComputePlanID != "" {
+		if _, err = getComputePlanRecord(db, inp.ComputePlanID); err != nil {
+			return
+		}
+	}
+	scope := getStatsScope(inp.ObjectiveKey, inp.ComputePlanID)
+	total := newStats(scope)
+	for shard := 0; shard < statsShards; shard++ {
+		var stats Stats
+		stats, err = getStats(db, scope, shard)
+		if err != nil {
+			return
+		}
+		total.Traintuples.merge(stats.Traintuples)
+		total.Testtuples.merge(stats.Testtuples)
+	}
+	out.Fill(total)
+	return
+}
+
+// -------------------------------------------------------------------------------------------
+// Utils for stats
+// -------------------------------------------------------------------------------------------
+
+// getStatsScope returns the scope of the stats of the tuples of an objective,
+// of a compute plan or of all the tuples if none is given
+func getStatsScope(objectiveKey, computePlanID string) string {
+	switch {
+	case objectiveKey != "":
+		return "objective/" + objectiveKey
+	case computePlanID != "":
+		return "computePlan/" + computePlanID
+	}
+	return statsScopeAll
+}
+
+// getStatsKey returns the key under which a shard of the stats of a scope is stored
+func getStatsKey(scope string, shard int) string {
+	return HashForKey("stats", scope, strconv.Itoa(shard))
+}
+
+// getStatsShard returns the shard of the stats updated by the transaction
+func getStatsShard(db LedgerDB) int {
+	h := fnv.New32a()
+	h.Write([]byte(db.cc.GetTxID()))
+	return int(h.Sum32() % statsShards)
+}
+
+// newStats returns empty stats for a scope
+func newStats(scope string) Stats {
+	return Stats{
+		AssetType:   StatsType,
+		Scope:       scope,
+		Traintuples: TupleCounts{ByStatus: map[string]int{}, ByWorker: map[string]int{}},
+		Testtuples:  TupleCounts{ByStatus: map[string]int{}, ByWorker: map[string]int{}},
+	}
+}
+
+// getStats returns a shard of the stats of a scope, which are empty if no
+// tuple was counted in the shard yet
+func getStats(db LedgerDB, scope string, shard int) (Stats, error) {
+	stats, err := db.GetStats(scope, shard)
+	if stderrors.Is(err, errors.NotFound()) {
+		return newStats(scope), nil
+	}
+	if err != nil {
+		return stats, err
+	}
+	if stats.Traintuples.ByStatus == nil {
+		stats.Traintuples.ByStatus = map[string]int{}
+	}
+	if stats.Traintuples.ByWorker == nil {
+		stats.Traintuples.ByWorker = map[string]int{}
+	}
+	if stats.Testtuples.ByStatus == nil {
+		stats.Testtuples.ByStatus = map[string]int{}
+	}
+	if stats.Testtuples.ByWorker == nil {
+		stats.Testtuples.ByWorker = map[string]int{}
+	}
+	return stats, nil
+}
+
+// updateStats applies an update to the counts of a tuple type in the shard of
+// the transaction of the stats of each scope
+func updateStats(db LedgerDB, assetType AssetType, scopes []string, update func(counts *TupleCounts)) error {
+	shard := getStatsShard(db)
+	for _, scope := range scopes {
+		stats, err := getStats(db, scope, shard)
+		if err != nil {
+			return err
+		}
+		switch assetType {
+		case TraintupleType:
+			update(&stats.Traintuples)
+		case TesttupleType:
+			update(&stats.Testtuples)
+		default:
+			return errors.Internal("no stats for the asset type %d", assetType)
+		}
+		if err := db.Put(getStatsKey(scope, shard), stats); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countNewTuple accounts for a new tuple in the stats of its scopes
+func countNewTuple(db LedgerDB, assetType AssetType, scopes []string, worker, status string) error {
+	return updateStats(db, assetType, scopes, func(counts *TupleCounts) {
+		counts.Total++
+		counts.ByStatus[status]++
+		counts.ByWorker[worker]++
+	})
+}
+
+// countTuple accounts for a tuple in stats counted in memory, by scope
+func countTuple(stats map[string]Stats, assetType AssetType, scopes []string, worker, status string) {
+	for _, scope := range scopes {
+		scopeStats, ok := stats[scope]
+		if !ok {
+			scopeStats = newStats(scope)
+		}
+		counts := &scopeStats.Traintuples
+		if assetType == TesttupleType {
+			counts = &scopeStats.Testtuples
+		}
+		counts.Total++
+		counts.ByStatus[status]++
+		counts.ByWorker[worker]++
+		stats[scope] = scopeStats
+	}
+}
+
+// resetStats replaces the stats of each scope with the ones counted in memory.
+// They are stored in the first shard and the other shards are emptied.
+func resetStats(db LedgerDB, stats map[string]Stats) error {
+	for scope, scopeStats := range stats {
+		if err := db.Put(getStatsKey(scope, 0), scopeStats); err != nil {
+			return err
+		}
+		for shard := 1; shard < statsShards; shard++ {
+			exists, err := db.KeyExists(getStatsKey(scope, shard))
+			if err != nil {
+				return err
+			}
+			if !exists {
+				continue
+			}
+			if err := db.Put(getStatsKey(scope, shard), newStats(scope)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// countStatusUpdate moves a tuple from a status to another in the stats of its scopes
+func countStatusUpdate(db LedgerDB, assetType AssetType, scopes []string, oldStatus, newStatus string) error {
+	return updateStats(db, assetType, scopes, func(counts *TupleCounts) {
+		counts.ByStatus[oldStatus]--
+		counts.ByStatus[newStatus]++
+	})
+}
+
+// merge adds the counts of a shard
+func (counts *TupleCounts) merge(shard TupleCounts) {
+	counts.Total += shard.Total
+	for status, n := range shard.ByStatus {
+		counts.ByStatus[status] += n
+	}
+	for worker, n := range shard.ByWorker {
+		counts.ByWorker[worker] += n
+	}
+}
+
+// withoutZeros returns a copy of the counts without the statuses and workers
+// which have no tuple
+func (counts TupleCounts) withoutZeros() TupleCounts {
+	out := TupleCounts{Total: counts.Total, ByStatus: map[string]int{}, ByWorker: map[string]int{}}
+	for status, n := range counts.ByStatus {
+		if n != 0 {
+			out.ByStatus[status] = n
+		}
+	}
+	for worker, n := range counts.ByWorker {
+		if n != 0 {
+			out.ByWorker[worker] = n
+		}
+	}
+	return out
+}
+
+// getStatsScopes returns the scopes of the stats counting the traintuple
+func (traintuple *Traintuple) getStatsScopes() []string {
+	scopes := []string{statsScopeAll}
+	if traintuple.ObjectiveKey != "" {
+		scopes = append(scopes, getStatsScope(traintuple.ObjectiveKey, ""))
+	}
+	if traintuple.ComputePlanID != "" {
+		scopes = append(scopes, getStatsScope("", traintuple.ComputePlanID))
+	}
+	return scopes
+}
+
+// getStatsScopes returns the scopes of the stats counting the testtuple. It
+// belongs to the compute plan of its traintuple, if any.
+func (testtuple *Testtuple) getStatsScopes(db LedgerDB) ([]string, error) {
+	scopes := []string{statsScopeAll}
+	if testtuple.ObjectiveKey != "" {
+		scopes = append(scopes, getStatsScope(testtuple.ObjectiveKey, ""))
+	}
+	traintuple, err := db.GetTraintuple(testtuple.Model.TraintupleKey)
+	if err != nil {
+		return nil, err
+	}
+	if traintuple.ComputePlanID != "" {
+		scopes = append(scopes, getStatsScope("", traintuple.ComputePlanID))
+	}
+	return scopes, nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 Owkin, inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryStats(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "algo")
+
+	resp := mockStub.MockInvoke("42", methodAndAssetToByte("createComputePlan", defaultComputePlan))
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	outCP := outputComputePlan{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &outCP))
+	inpTraintuple := inputTraintuple{}
+	resp = mockStub.MockInvoke("43", inpTraintuple.createDefault())
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+
+	// Train the first traintuple of the compute plan in other transactions,
+	// which update other shards
+	resp = mockStub.MockInvoke("44", [][]byte{[]byte("logStartTrain"), keyToJSON(outCP.TraintupleKeys[0])})
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	success := inputLogSuccessTrain{}
+	success.Key = outCP.TraintupleKeys[0]
+	resp = mockStub.MockInvoke("45", success.createDefault())
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	shards := 0
+	for shard := 0; shard < statsShards; shard++ {
+		if mockStub.State[getStatsKey(statsScopeAll, shard)] != nil {
+			shards++
+		}
+	}
+	assert.True(t, shards > 1, "the stats should be spread over several shards")
+
+	queryStats := func(inp inputQueryStats) outputStats {
+		resp := mockStub.MockInvoke("46", methodAndAssetToByte("queryStats", inp))
+		require.EqualValues(t, 200, resp.Status, resp.Message)
+		out := outputStats{}
+		require.NoError(t, json.Unmarshal(resp.Payload, &out))
+		return out
+	}
+	stats := queryStats(inputQueryStats{})
+	assert.Equal(t, TupleCounts{
+		Total:    3,
+		ByStatus: map[string]int{StatusDone: 1, StatusTodo: 2},
+		ByWorker: map[string]int{worker: 3},
+	}, stats.Traintuples)
+	assert.Equal(t, TupleCounts{
+		Total:    1,
+		ByStatus: map[string]int{StatusWaiting: 1},
+		ByWorker: map[string]int{worker: 1},
+	}, stats.Testtuples)
+
+	stats = queryStats(inputQueryStats{ComputePlanID: outCP.ComputePlanID})
+	assert.Equal(t, TupleCounts{
+		Total:    2,
+		ByStatus: map[string]int{StatusDone: 1, StatusTodo: 1},
+		ByWorker: map[string]int{worker: 2},
+	}, stats.Traintuples)
+	assert.Equal(t, 1, stats.Testtuples.Total)
+
+	stats = queryStats(inputQueryStats{ObjectiveKey: objectiveDescriptionHash})
+	assert.Equal(t, 3, stats.Traintuples.Total)
+	assert.Equal(t, 1, stats.Testtuples.Total)
+
+	// The optional input can be omitted
+	resp = mockStub.MockInvoke("46", [][]byte{[]byte("queryStats")})
+	assert.EqualValues(t, 200, resp.Status, resp.Message)
+
+	inp := inputQueryStats{ObjectiveKey: objectiveDescriptionHash, ComputePlanID: outCP.ComputePlanID}
+	resp = mockStub.MockInvoke("46", methodAndAssetToByte("queryStats", inp))
+	assert.EqualValues(t, 400, resp.Status, resp.Message)
+	inp = inputQueryStats{ObjectiveKey: algoHash}
+	resp = mockStub.MockInvoke("46", methodAndAssetToByte("queryStats", inp))
+	assert.EqualValues(t, 404, resp.Status, resp.Message)
+}
@@ -16,7 +16,6 @@ package main
 
 import (
 	"chaincode/errors"
-	"fmt"
 	"reflect"
 	"sort"
 	"strconv"
@@ -31,9 +30,11 @@ import (
 // which don't depend on previous testtuples values :
 //  - AssetType
 //  - Creator
+//  - CreationDate & Priority
 //  - Tag
 //  - Dataset
 //  - Certified
+//  - Permissions, restricted to the objective's and the dataManager's ones
 func (testtuple *Testtuple) SetFromInput(db LedgerDB, inp inputTesttuple) error {
 	creator, err := GetTxCreator(db.cc)
 	if err != nil {
@@ -41,6 +42,15 @@ func (testtuple *Testtuple) SetFromInput(db LedgerDB, inp inputTesttuple) error
 	}
 	testtuple.Creator = creator
 	testtuple.Tag = inp.Tag
+	testtuple.Priority = inp.Priority
+	testtuple.CreationDate, err = GetTxTimestamp(db.cc)
+	if err != nil {
+		return err
+	}
+	permissionsContext, err := getPermissionsContext(db)
+	if err != nil {
+		return err
+	}
 	testtuple.AssetType = TesttupleType
 
 	// Get test dataset from objective
@@ -48,6 +58,9 @@ func (testtuple *Testtuple) SetFromInput(db LedgerDB, inp inputTesttuple) error
 	if err != nil {
 		return errors.BadRequest(err, "could not retrieve objective with key %s", testtuple.ObjectiveKey)
 	}
+	if objective.Archived {
+		return errors.BadRequest(errors.CodeArchivedAsset, "objective %s is archived", testtuple.ObjectiveKey)
+	}
 	var objectiveDataManagerKey string
 	var objectiveDataSampleKeys []string
 	if objective.TestDataset != nil {
@@ -76,6 +89,10 @@ func (testtuple *Testtuple) SetFromInput(db LedgerDB, inp inputTesttuple) error
 	} else if objective.TestDataset != nil {
 		dataSampleKeys = objectiveDataSampleKeys
 		dataManagerKey = objectiveDataManagerKey
+		_, _, err = checkSameDataManager(db, dataManagerKey, dataSampleKeys)
+		if err != nil {
+			return err
+		}
 		testtuple.Certified = true
 	} else {
 		return errors.BadRequest("can not create a certified testtuple, no data associated with objective %s", testtuple.ObjectiveKey)
@@ -85,6 +102,14 @@ func (testtuple *Testtuple) SetFromInput(db LedgerDB, inp inputTesttuple) error
 	if err != nil {
 		return errors.BadRequest(err, "could not retrieve dataManager with key %s", dataManagerKey)
 	}
+	if dataManager.Archived {
+		return errors.BadRequest(errors.CodeArchivedAsset, "dataManager %s is archived", dataManagerKey)
+	}
+	if !testtuple.Certified && !dataManager.Permissions.CanProcess(dataManager.Owner, creator, permissionsContext) {
+		return errors.Forbidden("not authorized to process dataManager %s", dataManagerKey)
+	}
+	testtuple.Permissions = MergePermissions(testtuple.Permissions, objective.Permissions, permissionsContext.Groups)
+	testtuple.Permissions = MergePermissions(testtuple.Permissions, dataManager.Permissions, permissionsContext.Groups)
 	testtuple.Dataset = &TtDataset{
 		Worker:         dataManager.Owner,
 		DataSampleKeys: dataSampleKeys,
@@ -99,6 +124,7 @@ func (testtuple *Testtuple) SetFromInput(db LedgerDB, inp inputTesttuple) error
 //  - ObjectiveKey
 //  - Model
 //  - Status
+//  - Permissions, which are the traintuple's ones
 func (testtuple *Testtuple) SetFromTraintuple(db LedgerDB, traintupleKey string) error {
 
 	// check associated traintuple
@@ -110,11 +136,16 @@ func (testtuple *Testtuple) SetFromTraintuple(db LedgerDB, traintupleKey string)
 	if err != nil {
 		return err
 	}
-	if !traintuple.Permissions.CanProcess(traintuple.Creator, creator) {
+	permissionsContext, err := getPermissionsContext(db)
+	if err != nil {
+		return err
+	}
+	if !traintuple.Permissions.CanProcess(traintuple.Creator, creator, permissionsContext) {
 		return errors.Forbidden("not authorized to process traintuple %s", traintupleKey)
 	}
 	testtuple.ObjectiveKey = traintuple.ObjectiveKey
 	testtuple.AlgoKey = traintuple.AlgoKey
+	testtuple.Permissions = traintuple.Permissions
 	testtuple.Model = &Model{
 		TraintupleKey: traintupleKey,
 	}
@@ -127,7 +158,7 @@ func (testtuple *Testtuple) SetFromTraintuple(db LedgerDB, traintupleKey string)
 	case StatusDone:
 		testtuple.Status = StatusTodo
 	case StatusFailed:
-		return errors.BadRequest(
+		return errors.BadRequest(errors.CodeInvalidStatusTransition,
 			"could not register this testtuple, the traintuple %s has a failed status",
 			traintupleKey)
 	default:
@@ -151,7 +182,11 @@ func (testtuple *Testtuple) GetKey() string {
 // Save will put in the legder interface both the testtuple with its key
 // and all the associated composite keys
 func (testtuple *Testtuple) Save(db LedgerDB, testtupleKey string) error {
-	var err error
+	// check the quota of the creator now that the status is known
+	err := consumeQuota(db, testtuple.Dataset.OpenerHash, testtuple.Creator, testtuple.Status)
+	if err != nil {
+		return err
+	}
 	if err = db.Add(testtupleKey, testtuple); err != nil {
 		return err
 	}
@@ -163,6 +198,9 @@ func (testtuple *Testtuple) Save(db LedgerDB, testtupleKey string) error {
 	if err = db.CreateIndex("testtuple~algo~key", []string{"testtuple", testtuple.AlgoKey, testtupleKey}); err != nil {
 		return err
 	}
+	if err = db.CreateIndex("testtuple~dataManager~key", []string{"testtuple", testtuple.Dataset.OpenerHash, testtupleKey}); err != nil {
+		return err
+	}
 	if err = db.CreateIndex("testtuple~worker~status~key", []string{"testtuple", testtuple.Dataset.Worker, testtuple.Status, testtupleKey}); err != nil {
 		return err
 	}
@@ -175,7 +213,11 @@ func (testtuple *Testtuple) Save(db LedgerDB, testtupleKey string) error {
 			return err
 		}
 	}
-	return nil
+	scopes, err := testtuple.getStatsScopes(db)
+	if err != nil {
+		return err
+	}
+	return countNewTuple(db, TesttupleType, scopes, testtuple.Dataset.Worker, testtuple.Status)
 }
 
 // -------------------------------------
@@ -183,16 +225,10 @@ func (testtuple *Testtuple) Save(db LedgerDB, testtupleKey string) error {
 // -------------------------------------
 
 // createTesttuple adds a Testtuple in the ledger
-func createTesttuple(db LedgerDB, args []string) (map[string]string, error) {
-	inp := inputTesttuple{}
-	err := AssetFromJSON(args, &inp)
-	if err != nil {
-		return nil, err
-	}
-
+func createTesttuple(db LedgerDB, inp inputTesttuple) (map[string]string, error) {
 	// check validity of input arg and set testtuple
 	testtuple := Testtuple{}
-	err = testtuple.SetFromTraintuple(db, inp.TraintupleKey)
+	err := testtuple.SetFromTraintuple(db, inp.TraintupleKey)
 	if err != nil {
 		return nil, err
 	}
@@ -222,13 +258,7 @@ func createTesttuple(db LedgerDB, args []string) (map[string]string, error) {
 }
 
 // logStartTest modifies a testtuple by changing its status from todo to doing
-func logStartTest(db LedgerDB, args []string) (outputTesttuple outputTesttuple, err error) {
-	inp := inputHash{}
-	err = AssetFromJSON(args, &inp)
-	if err != nil {
-		return
-	}
-
+func logStartTest(db LedgerDB, inp inputHash) (outputTesttuple outputTesttuple, err error) {
 	// get testtuple, check validity of the update, and update its status
 	testtuple, err := db.GetTesttuple(inp.Key)
 	if err != nil {
@@ -248,13 +278,7 @@ func logStartTest(db LedgerDB, args []string) (outputTesttuple outputTesttuple,
 }
 
 // logSuccessTest modifies a testtuple by changing its status to done, reports perf and logs
-func logSuccessTest(db LedgerDB, args []string) (outputTesttuple outputTesttuple, err error) {
-	inp := inputLogSuccessTest{}
-	err = AssetFromJSON(args, &inp)
-	if err != nil {
-		return
-	}
-
+func logSuccessTest(db LedgerDB, inp inputLogSuccessTest) (outputTesttuple outputTesttuple, err error) {
 	testtuple, err := db.GetTesttuple(inp.Key)
 	if err != nil {
 		return
@@ -274,13 +298,7 @@ func logSuccessTest(db LedgerDB, args []string) (outputTesttuple outputTesttuple
 }
 
 // logFailTest modifies a testtuple by changing its status to fail and reports associated logs
-func logFailTest(db LedgerDB, args []string) (outputTesttuple outputTesttuple, err error) {
-	inp := inputLogFailTest{}
-	err = AssetFromJSON(args, &inp)
-	if err != nil {
-		return
-	}
-
+func logFailTest(db LedgerDB, inp inputLogFailTest) (outputTesttuple outputTesttuple, err error) {
 	// get, update and commit testtuple
 	testtuple, err := db.GetTesttuple(inp.Key)
 	if err != nil {
@@ -300,12 +318,7 @@ func logFailTest(db LedgerDB, args []string) (outputTesttuple outputTesttuple, e
 }
 
 // queryTesttuple returns a testtuple of the ledger given its key
-func queryTesttuple(db LedgerDB, args []string) (out outputTesttuple, err error) {
-	inp := inputHash{}
-	err = AssetFromJSON(args, &inp)
-	if err != nil {
-		return
-	}
+func queryTesttuple(db LedgerDB, inp inputHash) (out outputTesttuple, err error) {
 	testtuple, err := db.GetTesttuple(inp.Key)
 	if err != nil {
 		return
@@ -319,13 +332,9 @@ func queryTesttuple(db LedgerDB, args []string) (out outputTesttuple, err error)
 }
 
 // queryTesttuples returns all testtuples of the ledger
-func queryTesttuples(db LedgerDB, args []string) ([]outputTesttuple, error) {
+func queryTesttuples(db LedgerDB) ([]outputTesttuple, error) {
 	outTesttuples := []outputTesttuple{}
 
-	if len(args) != 0 {
-		err := errors.BadRequest("incorrect number of arguments, expecting nothing")
-		return outTesttuples, err
-	}
 	elementsKeys, err := db.GetIndexKeys("testtuple~traintuple~certified~key", []string{"testtuple"})
 	if err != nil {
 		return outTesttuples, err
@@ -380,14 +389,24 @@ func (testtuple *Testtuple) validateNewStatus(db LedgerDB, status string) error
 // commitStatusUpdate update the testtuple status in the ledger
 func (testtuple *Testtuple) commitStatusUpdate(db LedgerDB, testtupleKey string, newStatus string) error {
 	if err := testtuple.validateNewStatus(db, newStatus); err != nil {
-		return fmt.Errorf("update testtuple %s failed: %s", testtupleKey, err.Error())
+		return errors.BadRequest(err, "update testtuple %s failed:", testtupleKey)
 	}
 
 	oldStatus := testtuple.Status
 	testtuple.Status = newStatus
+	if err := updateActiveTuples(db, testtuple.Dataset.OpenerHash, testtuple.Creator, oldStatus, newStatus); err != nil {
+		return err
+	}
+	if newStatus == StatusDoing {
+		startDate, err := GetTxTimestamp(db.cc)
+		if err != nil {
+			return err
+		}
+		testtuple.StartDate = startDate
+	}
 
 	if err := db.Put(testtupleKey, testtuple); err != nil {
-		return fmt.Errorf("failed to update testtuple status to %s with key %s", newStatus, testtupleKey)
+		return errors.Internal(err, "failed to update testtuple status to %s with key %s:", newStatus, testtupleKey)
 	}
 
 	// update associated composite key
@@ -397,6 +416,13 @@ func (testtuple *Testtuple) commitStatusUpdate(db LedgerDB, testtupleKey string,
 	if err := db.UpdateIndex(indexName, oldAttributes, newAttributes); err != nil {
 		return err
 	}
-	logger.Infof("testtuple %s status updated: %s (from=%s)", testtupleKey, newStatus, oldStatus)
+	scopes, err := testtuple.getStatsScopes(db)
+	if err != nil {
+		return err
+	}
+	if err := countStatusUpdate(db, TesttupleType, scopes, oldStatus, newStatus); err != nil {
+		return err
+	}
+	logger.Info(logLine{{"txID", db.cc.GetTxID()}, {"testtuple", testtupleKey}, {"status", newStatus}, {"from", oldStatus}})
 	return nil
 }
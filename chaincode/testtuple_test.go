@@ -112,3 +112,36 @@ func TestConflictCertifiedNonCertifiedTesttuple(t *testing.T) {
 	assert.EqualValues(t, 409, resp.Status)
 	assert.Contains(t, resp.Message, "already exists")
 }
+
+func TestTesttuplePermissions(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "algo")
+
+	// The traintuple is private to the owner of the algo
+	inpPermissions := inputUpdatePermissions{
+		Key:         algoHash,
+		Permissions: inputPermissions{Process: inputPermission{AuthorizedIDs: []string{worker}}},
+	}
+	resp := mockStub.MockInvoke("42", methodAndAssetToByte("updatePermissions", inpPermissions))
+	assert.EqualValuesf(t, 200, resp.Status, "when updating the permissions, status %d and message %s", resp.Status, resp.Message)
+	inpTraintuple := inputTraintuple{}
+	resp = mockStub.MockInvoke("42", inpTraintuple.createDefault())
+	assert.EqualValuesf(t, 200, resp.Status, "when adding a traintuple, status %d and message %s", resp.Status, resp.Message)
+
+	// so is the testtuple
+	inpTesttuple := inputTesttuple{}
+	resp = mockStub.MockInvoke("42", inpTesttuple.createDefault())
+	assert.EqualValuesf(t, 200, resp.Status, "when adding a testtuple, status %d and message %s", resp.Status, resp.Message)
+	res := map[string]string{}
+	err := json.Unmarshal(resp.Payload, &res)
+	assert.NoError(t, err, "should unmarshal without problem")
+
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("queryTesttuple"), keyToJSON(res["key"])})
+	assert.EqualValuesf(t, 200, resp.Status, "when querying the testtuple, status %d and message %s", resp.Status, resp.Message)
+	out := outputTesttuple{}
+	err = json.Unmarshal(resp.Payload, &out)
+	assert.NoError(t, err, "should unmarshal without problem")
+	assert.False(t, out.Permissions.Process.Public)
+	assert.Equal(t, []string{worker}, out.Permissions.Process.AuthorizedIDs)
+}
@@ -16,7 +16,6 @@ package main
 
 import (
 	"chaincode/errors"
-	"fmt"
 	"strconv"
 )
 
@@ -29,6 +28,7 @@ import (
 // which don't depend on previous traintuples values :
 //  - AssetType
 //  - Creator & permissions
+//  - CreationDate & Priority
 //  - Tag
 //  - AlgoKey & ObjectiveKey
 //  - Dataset
@@ -43,11 +43,23 @@ func (traintuple *Traintuple) SetFromInput(db LedgerDB, inp inputTraintuple) err
 	traintuple.AssetType = TraintupleType
 	traintuple.Creator = creator
 	traintuple.Tag = inp.Tag
+	traintuple.Priority = inp.Priority
+	traintuple.CreationDate, err = GetTxTimestamp(db.cc)
+	if err != nil {
+		return err
+	}
+	permissionsContext, err := getPermissionsContext(db)
+	if err != nil {
+		return err
+	}
 	algo, err := db.GetAlgo(inp.AlgoKey)
 	if err != nil {
 		return errors.BadRequest(err, "could not retrieve algo with key %s", inp.AlgoKey)
 	}
-	if !algo.Permissions.CanProcess(algo.Owner, creator) {
+	if algo.Archived {
+		return errors.BadRequest(errors.CodeArchivedAsset, "algo %s is archived", inp.AlgoKey)
+	}
+	if !algo.Permissions.CanProcess(algo.Owner, creator, permissionsContext) {
 		return errors.Forbidden("not authorized to process algo %s", inp.AlgoKey)
 	}
 	traintuple.AlgoKey = inp.AlgoKey
@@ -57,7 +69,10 @@ func (traintuple *Traintuple) SetFromInput(db LedgerDB, inp inputTraintuple) err
 	if err != nil {
 		return errors.BadRequest(err, "could not retrieve objective with key %s", inp.ObjectiveKey)
 	}
-	if !objective.Permissions.CanProcess(objective.Owner, creator) {
+	if objective.Archived {
+		return errors.BadRequest(errors.CodeArchivedAsset, "objective %s is archived", inp.ObjectiveKey)
+	}
+	if !objective.Permissions.CanProcess(objective.Owner, creator, permissionsContext) {
 		return errors.Forbidden("not authorized to process objective %s", inp.ObjectiveKey)
 	}
 	traintuple.ObjectiveKey = inp.ObjectiveKey
@@ -68,18 +83,21 @@ func (traintuple *Traintuple) SetFromInput(db LedgerDB, inp inputTraintuple) err
 		return err
 	}
 	if !trainOnly {
-		return errors.BadRequest("not possible to create a traintuple with test only data")
+		return errors.BadRequest(errors.CodeTestOnlyData, "not possible to create a traintuple with test only data")
 	}
 
 	dataManager, err := db.GetDataManager(inp.DataManagerKey)
 	if err != nil {
 		return errors.BadRequest(err, "could not retrieve dataManager with key %s", inp.DataManagerKey)
 	}
-	if !dataManager.Permissions.CanProcess(dataManager.Owner, creator) {
+	if dataManager.Archived {
+		return errors.BadRequest(errors.CodeArchivedAsset, "dataManager %s is archived", inp.DataManagerKey)
+	}
+	if !dataManager.Permissions.CanProcess(dataManager.Owner, creator, permissionsContext) {
 		return errors.Forbidden("not authorized to process dataManager %s", inp.DataManagerKey)
 	}
 
-	traintuple.Permissions = MergePermissions(dataManager.Permissions, algo.Permissions)
+	traintuple.Permissions = MergePermissions(dataManager.Permissions, algo.Permissions, permissionsContext.Groups)
 
 	// fill traintuple.Dataset from dataManager and dataSample
 	traintuple.Dataset = &Dataset{
@@ -92,16 +110,26 @@ func (traintuple *Traintuple) SetFromInput(db LedgerDB, inp inputTraintuple) err
 
 // SetFromParents set the status of the traintuple depending on its "parents",
 // i.e. the traintuples from which it received the outModels as inModels.
+// The creator must be allowed to process the parents' models and the
+// permissions of the traintuple are restricted to the parents' ones.
 // Also it's InModelKeys are set.
 func (traintuple *Traintuple) SetFromParents(db LedgerDB, inModels []string) error {
 	status := StatusTodo
 	parentTraintupleKeys := inModels
+	permissionsContext, err := getPermissionsContext(db)
+	if err != nil {
+		return err
+	}
 	for _, parentTraintupleKey := range parentTraintupleKeys {
 		parentTraintuple, err := db.GetTraintuple(parentTraintupleKey)
 		if err != nil {
 			err = errors.BadRequest(err, "could not retrieve parent traintuple with key %s %d", parentTraintupleKeys, len(parentTraintupleKeys))
 			return err
 		}
+		if !parentTraintuple.Permissions.CanProcess(parentTraintuple.Creator, traintuple.Creator, permissionsContext) {
+			return errors.Forbidden("not authorized to process parent traintuple %s", parentTraintupleKey)
+		}
+		traintuple.Permissions = MergePermissions(traintuple.Permissions, parentTraintuple.Permissions, permissionsContext.Groups)
 		// set traintuple to waiting if one of the parent traintuples is not done
 		if parentTraintuple.OutModel == nil {
 			status = StatusWaiting
@@ -169,7 +197,7 @@ func (traintuple *Traintuple) AddToComputePlan(db LedgerDB, inp inputTraintuple,
 	if err != nil {
 		return err
 	} else if len(ttKeys) > 0 {
-		err = errors.BadRequest("ComputePlanID %s with worker %s rank %d already exists", inp.ComputePlanID, traintuple.Dataset.Worker, traintuple.Rank)
+		err = errors.BadRequest(errors.CodeComputePlanRankConflict, "ComputePlanID %s with worker %s rank %d already exists", inp.ComputePlanID, traintuple.Dataset.Worker, traintuple.Rank)
 		return err
 	}
 
@@ -181,6 +209,10 @@ func (traintuple *Traintuple) AddToComputePlan(db LedgerDB, inp inputTraintuple,
 // Save will put in the legder interface both the traintuple with its key
 // and all the associated composite keys
 func (traintuple *Traintuple) Save(db LedgerDB, traintupleKey string) error {
+	// check the quota of the creator now that the status is known
+	if err := consumeQuota(db, traintuple.Dataset.DataManagerKey, traintuple.Creator, traintuple.Status); err != nil {
+		return err
+	}
 
 	// store in ledger
 	if err := db.Add(traintupleKey, traintuple); err != nil {
@@ -194,6 +226,14 @@ func (traintuple *Traintuple) Save(db LedgerDB, traintupleKey string) error {
 	if err := db.CreateIndex("traintuple~worker~status~key", []string{"traintuple", traintuple.Dataset.Worker, traintuple.Status, traintupleKey}); err != nil {
 		return err
 	}
+	if err := db.CreateIndex("traintuple~dataManager~key", []string{"traintuple", traintuple.Dataset.DataManagerKey, traintupleKey}); err != nil {
+		return err
+	}
+	for _, dataSampleKey := range traintuple.Dataset.DataSampleKeys {
+		if err := db.CreateIndex("traintuple~dataSample~key", []string{"traintuple", dataSampleKey, traintupleKey}); err != nil {
+			return err
+		}
+	}
 	for _, inModelKey := range traintuple.InModelKeys {
 		if err := db.CreateIndex("traintuple~inModel~key", []string{"traintuple", inModelKey, traintupleKey}); err != nil {
 			return err
@@ -210,7 +250,7 @@ func (traintuple *Traintuple) Save(db LedgerDB, traintupleKey string) error {
 			return err
 		}
 	}
-	return nil
+	return countNewTuple(db, TraintupleType, traintuple.getStatsScopes(), traintuple.Dataset.Worker, traintuple.Status)
 }
 
 // -------------------------------------------------------------------------------------------
@@ -218,15 +258,9 @@ func (traintuple *Traintuple) Save(db LedgerDB, traintupleKey string) error {
 // -------------------------------------------------------------------------------------------
 
 // createTraintuple adds a Traintuple in the ledger
-func createTraintuple(db LedgerDB, args []string) (map[string]string, error) {
-	inp := inputTraintuple{}
-	err := AssetFromJSON(args, &inp)
-	if err != nil {
-		return nil, err
-	}
-
+func createTraintuple(db LedgerDB, inp inputTraintuple) (map[string]string, error) {
 	traintuple := Traintuple{}
-	err = traintuple.SetFromInput(db, inp)
+	err := traintuple.SetFromInput(db, inp)
 	if err != nil {
 		return nil, err
 	}
@@ -251,6 +285,11 @@ func createTraintuple(db LedgerDB, args []string) (map[string]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	if traintuple.ComputePlanID == traintupleKey {
+		if err = traintuple.startComputePlan(db, traintupleKey); err != nil {
+			return nil, err
+		}
+	}
 	out := outputTraintuple{}
 	err = out.Fill(db, traintuple, traintupleKey)
 	if err != nil {
@@ -268,13 +307,7 @@ func createTraintuple(db LedgerDB, args []string) (map[string]string, error) {
 }
 
 // logStartTrain modifies a traintuple by changing its status from todo to doing
-func logStartTrain(db LedgerDB, args []string) (outputTraintuple outputTraintuple, err error) {
-	inp := inputHash{}
-	err = AssetFromJSON(args, &inp)
-	if err != nil {
-		return
-	}
-
+func logStartTrain(db LedgerDB, inp inputHash) (outputTraintuple outputTraintuple, err error) {
 	// get traintuple, check validity of the update
 	traintuple, err := db.GetTraintuple(inp.Key)
 	if err != nil {
@@ -292,12 +325,7 @@ func logStartTrain(db LedgerDB, args []string) (outputTraintuple outputTraintupl
 
 // logSuccessTrain modifies a traintuple by changing its status from doing to done
 // reports logs and associated performances
-func logSuccessTrain(db LedgerDB, args []string) (outputTraintuple outputTraintuple, err error) {
-	inp := inputLogSuccessTrain{}
-	err = AssetFromJSON(args, &inp)
-	if err != nil {
-		return
-	}
+func logSuccessTrain(db LedgerDB, inp inputLogSuccessTrain) (outputTraintuple outputTraintuple, err error) {
 	traintupleKey := inp.Key
 
 	// get, update and commit traintuple
@@ -341,13 +369,7 @@ func logSuccessTrain(db LedgerDB, args []string) (outputTraintuple outputTraintu
 }
 
 // logFailTrain modifies a traintuple by changing its status to fail and reports associated logs
-func logFailTrain(db LedgerDB, args []string) (outputTraintuple outputTraintuple, err error) {
-	inp := inputLogFailTrain{}
-	err = AssetFromJSON(args, &inp)
-	if err != nil {
-		return
-	}
-
+func logFailTrain(db LedgerDB, inp inputLogFailTrain) (outputTraintuple outputTraintuple, err error) {
 	// get, update and commit traintuple
 	traintuple, err := db.GetTraintuple(inp.Key)
 	if err != nil {
@@ -385,12 +407,7 @@ func logFailTrain(db LedgerDB, args []string) (outputTraintuple outputTraintuple
 }
 
 // queryTraintuple returns info about a traintuple given its key
-func queryTraintuple(db LedgerDB, args []string) (outputTraintuple outputTraintuple, err error) {
-	inp := inputHash{}
-	err = AssetFromJSON(args, &inp)
-	if err != nil {
-		return
-	}
+func queryTraintuple(db LedgerDB, inp inputHash) (outputTraintuple outputTraintuple, err error) {
 	traintuple, err := db.GetTraintuple(inp.Key)
 	if err != nil {
 		return
@@ -404,13 +421,9 @@ func queryTraintuple(db LedgerDB, args []string) (outputTraintuple outputTraintu
 }
 
 // queryTraintuples returns all traintuples
-func queryTraintuples(db LedgerDB, args []string) ([]outputTraintuple, error) {
+func queryTraintuples(db LedgerDB) ([]outputTraintuple, error) {
 	outTraintuples := []outputTraintuple{}
 
-	if len(args) != 0 {
-		err := errors.BadRequest("incorrect number of arguments, expecting nothing")
-		return outTraintuples, err
-	}
 	elementsKeys, err := db.GetIndexKeys("traintuple~algo~key", []string{"traintuple"})
 	if err != nil {
 		return outTraintuples, err
@@ -467,7 +480,7 @@ func (traintuple *Traintuple) updateTraintupleChildren(db LedgerDB, traintupleKe
 	indexName := "traintuple~inModel~key"
 	childTraintupleKeys, err := db.GetIndexKeys(indexName, []string{"traintuple", traintupleKey})
 	if err != nil {
-		return fmt.Errorf("error while getting associated traintuples to update their inModel")
+		return errors.Internal(err, "error while getting associated traintuples to update their inModel:")
 	}
 	for _, childTraintupleKey := range childTraintupleKeys {
 		// get and update traintuple
@@ -482,7 +495,7 @@ func (traintuple *Traintuple) updateTraintupleChildren(db LedgerDB, traintupleKe
 		}
 
 		if childTraintuple.Status != StatusWaiting {
-			return fmt.Errorf("traintuple %s has invalid status : '%s' instead of waiting", childTraintupleKey, childTraintuple.Status)
+			return errors.BadRequest(errors.CodeInvalidStatusTransition, "traintuple %s has invalid status : '%s' instead of waiting", childTraintupleKey, childTraintuple.Status)
 		}
 
 		// get traintuple new status
@@ -551,17 +564,27 @@ func (traintuple *Traintuple) isReady(db LedgerDB, newDoneTraintupleKey string)
 // commitStatusUpdate update the traintuple status in the ledger
 func (traintuple *Traintuple) commitStatusUpdate(db LedgerDB, traintupleKey string, newStatus string) error {
 	if traintuple.Status == newStatus {
-		return fmt.Errorf("cannot update traintuple %s - status already %s", traintupleKey, newStatus)
+		return errors.BadRequest(errors.CodeInvalidStatusTransition, "cannot update traintuple %s - status already %s", traintupleKey, newStatus)
 	}
 
 	if err := traintuple.validateNewStatus(db, newStatus); err != nil {
-		return fmt.Errorf("update traintuple %s failed: %s", traintupleKey, err.Error())
+		return errors.BadRequest(err, "update traintuple %s failed:", traintupleKey)
 	}
 
 	oldStatus := traintuple.Status
 	traintuple.Status = newStatus
+	if err := updateActiveTuples(db, traintuple.Dataset.DataManagerKey, traintuple.Creator, oldStatus, newStatus); err != nil {
+		return err
+	}
+	if newStatus == StatusDoing {
+		startDate, err := GetTxTimestamp(db.cc)
+		if err != nil {
+			return err
+		}
+		traintuple.StartDate = startDate
+	}
 	if err := db.Put(traintupleKey, traintuple); err != nil {
-		return fmt.Errorf("failed to update traintuple %s - %s", traintupleKey, err.Error())
+		return errors.Internal(err, "failed to update traintuple %s -", traintupleKey)
 	}
 
 	// update associated composite keys
@@ -571,7 +594,10 @@ func (traintuple *Traintuple) commitStatusUpdate(db LedgerDB, traintupleKey stri
 	if err := db.UpdateIndex(indexName, oldAttributes, newAttributes); err != nil {
 		return err
 	}
-	logger.Infof("traintuple %s status updated: %s (from=%s)", traintupleKey, newStatus, oldStatus)
+	if err := countStatusUpdate(db, TraintupleType, traintuple.getStatsScopes(), oldStatus, newStatus); err != nil {
+		return err
+	}
+	logger.Info(logLine{{"txID", db.cc.GetTxID()}, {"traintuple", traintupleKey}, {"status", newStatus}, {"from", oldStatus}})
 	return nil
 }
 
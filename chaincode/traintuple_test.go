@@ -15,6 +15,7 @@
 package main
 
 import (
+	"chaincode/errors"
 	"encoding/json"
 	"net/http"
 	"strings"
@@ -86,7 +87,7 @@ func TestTraintupleWithSingleDatasample(t *testing.T) {
 
 func TestTraintupleWithDuplicatedDatasamples(t *testing.T) {
 	scc := new(SubstraChaincode)
-	mockStub := NewMockStub("substra", scc)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
 	registerItem(t, *mockStub, "trainDataset")
 
 	objHash := strings.ReplaceAll(objectiveDescriptionHash, "1", "2")
@@ -277,6 +278,7 @@ func TestTraintuple(t *testing.T) {
 	out := outputTraintuple{}
 	err = json.Unmarshal(resp.Payload, &out)
 	assert.NoError(t, err, "when unmarshalling queried traintuple")
+	assert.NotZero(t, out.CreationDate)
 	expected := outputTraintuple{
 		Key: traintupleKey,
 		Algo: &HashDressName{
@@ -284,7 +286,8 @@ func TestTraintuple(t *testing.T) {
 			Name:           algoName,
 			StorageAddress: algoStorageAddress,
 		},
-		Creator: worker,
+		CreationDate: out.CreationDate,
+		Creator:      worker,
 		Dataset: &TtDataset{
 			DataSampleKeys: []string{trainDataSampleHash1, trainDataSampleHash2},
 			OpenerHash:     dataManagerOpenerHash,
@@ -375,6 +378,8 @@ func TestTraintuple(t *testing.T) {
 		Hash:           modelHash,
 		StorageAddress: modelAddress}
 	expected.Status = traintupleStatus[1]
+	assert.NotZero(t, endTraintuple.StartDate)
+	expected.StartDate = endTraintuple.StartDate
 	assert.Exactly(t, expected, endTraintuple, "retreived Traintuple does not correspond to what is expected")
 
 	// query all traintuples related to a traintuple with the same algo
@@ -438,3 +443,103 @@ func TestInsertTraintupleTwice(t *testing.T) {
 	assert.EqualValues(t, http.StatusConflict, resp.Status)
 
 }
+
+func TestTraintupleWithArchivedAsset(t *testing.T) {
+	for _, tt := range []struct {
+		assetName string
+		key       string
+		archive   string
+		queryAll  string
+	}{
+		{assetName: "algo", key: algoHash, archive: "archiveAlgo", queryAll: "queryAlgos"},
+		{assetName: "objective", key: objectiveDescriptionHash, archive: "archiveObjective", queryAll: "queryObjectives"},
+		{assetName: "dataManager", key: dataManagerOpenerHash, archive: "archiveDataManager", queryAll: "queryDataManagers"},
+	} {
+		t.Run(tt.assetName, func(t *testing.T) {
+			scc := new(SubstraChaincode)
+			mockStub := NewMockStubWithRegisterNode("substra", scc)
+			registerItem(t, *mockStub, "traintuple")
+
+			args := [][]byte{[]byte(tt.archive), keyToJSON(tt.key)}
+			resp := mockStub.MockInvoke("42", args)
+			require.EqualValuesf(t, 200, resp.Status, "when archiving the %s, status %d and message %s", tt.assetName, resp.Status, resp.Message)
+			resp = mockStub.MockInvoke("42", args)
+			assert.EqualValuesf(t, 400, resp.Status, "when archiving the %s twice, status %d and message %s", tt.assetName, resp.Status, resp.Message)
+
+			// Archived assets are hidden by default
+			resp = mockStub.MockInvoke("42", [][]byte{[]byte(tt.queryAll)})
+			require.EqualValuesf(t, 200, resp.Status, "when querying all the %s, status %d and message %s", tt.assetName, resp.Status, resp.Message)
+			var assets []map[string]interface{}
+			err := json.Unmarshal(resp.Payload, &assets)
+			assert.NoError(t, err)
+			assert.Len(t, assets, 0)
+
+			args = methodAndAssetToByte(tt.queryAll, inputQueryAll{IncludeArchived: true})
+			resp = mockStub.MockInvoke("42", args)
+			require.EqualValuesf(t, 200, resp.Status, "when querying all the %s, status %d and message %s", tt.assetName, resp.Status, resp.Message)
+			err = json.Unmarshal(resp.Payload, &assets)
+			assert.NoError(t, err)
+			require.Len(t, assets, 1)
+			assert.Equal(t, true, assets[0]["archived"])
+
+			// The existing traintuple can still be retrieved
+			args = [][]byte{[]byte("queryTraintuple"), keyToJSON(traintupleKey)}
+			resp = mockStub.MockInvoke("42", args)
+			assert.EqualValuesf(t, 200, resp.Status, "when querying the traintuple, status %d and message %s", resp.Status, resp.Message)
+
+			// but no new tuple can use the archived asset
+			inpTraintuple := inputTraintuple{DataSampleKeys: []string{trainDataSampleHash1}}
+			args = inpTraintuple.createDefault()
+			resp = mockStub.MockInvoke("42", args)
+			assert.EqualValuesf(t, 400, resp.Status, "when creating a traintuple with an archived %s, status %d and message %s", tt.assetName, resp.Status, resp.Message)
+			respError := map[string]interface{}{}
+			assert.NoError(t, json.Unmarshal(resp.Payload, &respError))
+			assert.EqualValues(t, errors.CodeArchivedAsset, respError["code"])
+		})
+	}
+}
+
+func TestTraintupleInModelPermissions(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "algo")
+
+	// The parent traintuple is private to the owner of the algo
+	inpPermissions := inputUpdatePermissions{
+		Key:         algoHash,
+		Permissions: inputPermissions{Process: inputPermission{AuthorizedIDs: []string{worker}}},
+	}
+	resp := mockStub.MockInvoke("42", methodAndAssetToByte("updatePermissions", inpPermissions))
+	require.EqualValuesf(t, 200, resp.Status, "when updating the permissions, status %d and message %s", resp.Status, resp.Message)
+	inpTraintuple := inputTraintuple{DataSampleKeys: []string{trainDataSampleHash1}}
+	resp = mockStub.MockInvoke("42", inpTraintuple.createDefault())
+	require.EqualValuesf(t, 200, resp.Status, "when adding a traintuple, status %d and message %s", resp.Status, resp.Message)
+	parentKey := map[string]string{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &parentKey))
+
+	// its child uses a public algo but inherits the permissions of its parent
+	inpAlgo := inputAlgo{Hash: modelHash}
+	resp = mockStub.MockInvoke("42", inpAlgo.createDefault())
+	require.EqualValuesf(t, 200, resp.Status, "when adding an algo, status %d and message %s", resp.Status, resp.Message)
+	inpTraintuple = inputTraintuple{AlgoKey: modelHash, DataSampleKeys: []string{trainDataSampleHash2}, InModels: []string{parentKey["key"]}}
+	resp = mockStub.MockInvoke("42", inpTraintuple.createDefault())
+	require.EqualValuesf(t, 200, resp.Status, "when adding a traintuple, status %d and message %s", resp.Status, resp.Message)
+	childKey := map[string]string{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &childKey))
+
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("queryTraintuple"), keyToJSON(childKey["key"])})
+	require.EqualValuesf(t, 200, resp.Status, "when querying the traintuple, status %d and message %s", resp.Status, resp.Message)
+	out := outputTraintuple{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &out))
+	assert.False(t, out.Permissions.Process.Public)
+	assert.Equal(t, []string{worker}, out.Permissions.Process.AuthorizedIDs)
+
+	// another node can't use the model of the parent
+	mockStub.MockTransactionStart("42")
+	db := NewLedgerDB(mockStub)
+	traintuple := Traintuple{Creator: "hospitalA", Permissions: Permissions{Process: Permission{Public: true}}}
+	err := traintuple.SetFromParents(db, []string{parentKey["key"]})
+	mockStub.MockTransactionEnd("42")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not authorized")
+}
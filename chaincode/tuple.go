@@ -18,9 +18,9 @@ import (
 	"chaincode/errors"
 	"crypto/sha256"
 	"encoding/hex"
+	stderrors "errors"
 	"fmt"
 	"sort"
-	"strconv"
 )
 
 // List of the possible tuple's status
@@ -36,119 +36,8 @@ const (
 // Smart contracts related to multiple tuple types
 // ------------------------------------------------
 
-// createComputePlan is the wrapper for the substra smartcontract CreateComputePlan
-func createComputePlan(db LedgerDB, args []string) (resp outputComputePlan, err error) {
-	inp := inputComputePlan{}
-	err = AssetFromJSON(args, &inp)
-	if err != nil {
-		return
-	}
-	traintupleKeysByID := map[string]string{}
-	resp.TraintupleKeys = []string{}
-	var traintuplesTodo []outputTraintuple
-	for i, computeTraintuple := range inp.Traintuples {
-		inpTraintuple := inputTraintuple{}
-		inpTraintuple.AlgoKey = inp.AlgoKey
-		inpTraintuple.ObjectiveKey = inp.ObjectiveKey
-		inpTraintuple.DataManagerKey = computeTraintuple.DataManagerKey
-		inpTraintuple.DataSampleKeys = computeTraintuple.DataSampleKeys
-		inpTraintuple.Tag = computeTraintuple.Tag
-		inpTraintuple.Rank = strconv.Itoa(i)
-
-		traintuple := Traintuple{}
-		err := traintuple.SetFromInput(db, inpTraintuple)
-		if err != nil {
-			return resp, err
-		}
-
-		// Set the inModels by matching the id to traintuples key previously
-		// encontered in this compute plan
-		for _, InModelID := range computeTraintuple.InModelsIDs {
-			inModelKey, ok := traintupleKeysByID[InModelID]
-			if !ok {
-				return resp, errors.BadRequest("traintuple ID %s: model ID %s not found, check traintuple list order", computeTraintuple.ID, InModelID)
-			}
-			traintuple.InModelKeys = append(traintuple.InModelKeys, inModelKey)
-		}
-
-		traintupleKey := traintuple.GetKey()
-
-		// Set the ComputePlanID
-		if i == 0 {
-			traintuple.ComputePlanID = traintupleKey
-			resp.ComputePlanID = traintuple.ComputePlanID
-		} else {
-			traintuple.ComputePlanID = resp.ComputePlanID
-		}
-
-		// Set status: if it has parents it's waiting
-		// if not it's todo and it has to be included in the event
-		if len(computeTraintuple.InModelsIDs) > 0 {
-			traintuple.Status = StatusWaiting
-		} else {
-			traintuple.Status = StatusTodo
-			out := outputTraintuple{}
-			err = out.Fill(db, traintuple, traintupleKey)
-			if err != nil {
-				return resp, err
-			}
-			traintuplesTodo = append(traintuplesTodo, out)
-		}
-
-		err = traintuple.Save(db, traintupleKey)
-		if err != nil {
-			return resp, err
-		}
-		traintupleKeysByID[computeTraintuple.ID] = traintupleKey
-		resp.TraintupleKeys = append(resp.TraintupleKeys, traintupleKey)
-	}
-
-	resp.TesttupleKeys = []string{}
-	for index, computeTesttuple := range inp.Testtuples {
-		traintupleKey, ok := traintupleKeysByID[computeTesttuple.TraintupleID]
-		if !ok {
-			return resp, errors.BadRequest("testtuple index %s: traintuple ID %s not found", index, computeTesttuple.TraintupleID)
-		}
-		testtuple := Testtuple{}
-		testtuple.Model = &Model{TraintupleKey: traintupleKey}
-		testtuple.ObjectiveKey = inp.ObjectiveKey
-		testtuple.AlgoKey = inp.AlgoKey
-
-		inputTesttuple := inputTesttuple{}
-		inputTesttuple.DataManagerKey = computeTesttuple.DataManagerKey
-		inputTesttuple.DataSampleKeys = computeTesttuple.DataSampleKeys
-		inputTesttuple.Tag = computeTesttuple.Tag
-		err = testtuple.SetFromInput(db, inputTesttuple)
-		if err != nil {
-			return resp, err
-		}
-		testtuple.Status = StatusWaiting
-		testtupleKey := testtuple.GetKey()
-		err = testtuple.Save(db, testtupleKey)
-		if err != nil {
-			return resp, err
-		}
-		resp.TesttupleKeys = append(resp.TesttupleKeys, testtupleKey)
-	}
-
-	event := TuplesEvent{}
-	event.SetTraintuples(traintuplesTodo...)
-	err = SendTuplesEvent(db.cc, event)
-	if err != nil {
-		return resp, err
-	}
-
-	return resp, err
-}
-
 // queryModelDetails returns info about the testtuple and algo related to a traintuple
-func queryModelDetails(db LedgerDB, args []string) (outModelDetails outputModelDetails, err error) {
-	inp := inputHash{}
-	err = AssetFromJSON(args, &inp)
-	if err != nil {
-		return
-	}
-
+func queryModelDetails(db LedgerDB, inp inputHash) (outModelDetails outputModelDetails, err error) {
 	// get associated traintuple
 	outModelDetails.Traintuple, err = getOutputTraintuple(db, inp.Key)
 	if err != nil {
@@ -178,14 +67,9 @@ func queryModelDetails(db LedgerDB, args []string) (outModelDetails outputModelD
 }
 
 // queryModels returns all traintuples and associated testuples
-func queryModels(db LedgerDB, args []string) (outModels []outputModel, err error) {
+func queryModels(db LedgerDB) (outModels []outputModel, err error) {
 	outModels = []outputModel{}
 
-	if len(args) != 0 {
-		err = errors.BadRequest("incorrect number of arguments, expecting nothing")
-		return
-	}
-
 	traintupleKeys, err := db.GetIndexKeys("traintuple~algo~key", []string{"traintuple"})
 	if err != nil {
 		return
@@ -218,15 +102,225 @@ func queryModels(db LedgerDB, args []string) (outModels []outputModel, err error
 	return
 }
 
+// claimNextTuple moves to doing the todo traintuple or testtuple of the calling worker
+// having the highest priority, the oldest one being picked among tuples of same
+// priority. The claimed tuple is returned, the output is empty if there is none.
+func claimNextTuple(db LedgerDB) (out outputClaimedTuple, err error) {
+	worker, err := GetTxCreator(db.cc)
+	if err != nil {
+		return
+	}
+
+	candidates := []tupleCandidate{}
+	traintupleKeys, err := db.GetIndexKeys("traintuple~worker~status~key", []string{"traintuple", worker, StatusTodo})
+	if err != nil {
+		return
+	}
+	for _, traintupleKey := range traintupleKeys {
+		traintuple, err := db.GetTraintuple(traintupleKey)
+		if err != nil {
+			return out, err
+		}
+		candidates = append(candidates, tupleCandidate{
+			key:          traintupleKey,
+			priority:     traintuple.Priority,
+			creationDate: traintuple.CreationDate,
+			traintuple:   &traintuple,
+		})
+	}
+	testtupleKeys, err := db.GetIndexKeys("testtuple~worker~status~key", []string{"testtuple", worker, StatusTodo})
+	if err != nil {
+		return
+	}
+	for _, testtupleKey := range testtupleKeys {
+		testtuple, err := db.GetTesttuple(testtupleKey)
+		if err != nil {
+			return out, err
+		}
+		candidates = append(candidates, tupleCandidate{
+			key:          testtupleKey,
+			priority:     testtuple.Priority,
+			creationDate: testtuple.CreationDate,
+			testtuple:    &testtuple,
+		})
+	}
+	if len(candidates) == 0 {
+		return
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].before(candidates[j]) })
+	next := candidates[0]
+	if next.traintuple != nil {
+		if err = next.traintuple.commitStatusUpdate(db, next.key, StatusDoing); err != nil {
+			return
+		}
+		out.Traintuple = &outputTraintuple{}
+		err = out.Traintuple.Fill(db, *next.traintuple, next.key)
+		return
+	}
+	if err = next.testtuple.commitStatusUpdate(db, next.key, StatusDoing); err != nil {
+		return
+	}
+	out.Testtuple = &outputTesttuple{}
+	err = out.Testtuple.Fill(db, next.key, *next.testtuple)
+	return
+}
+
+// sweepStaleTuples marks as failed the tuples which have been doing for longer than
+// the maximum duration of their compute plan or, by default, of their objective.
+// It can be called by any node, the failure is then propagated to the children.
+func sweepStaleTuples(db LedgerDB) (out outputStaleTuples, err error) {
+	out = outputStaleTuples{TraintupleKeys: []string{}, TesttupleKeys: []string{}}
+	now, err := GetTxTimestamp(db.cc)
+	if err != nil {
+		return
+	}
+	nodes, err := queryNodes(db)
+	if err != nil {
+		return
+	}
+
+	event := TuplesEvent{}
+	for _, node := range nodes {
+		var traintupleKeys []string
+		traintupleKeys, err = db.GetIndexKeys("traintuple~worker~status~key", []string{"traintuple", node.ID, StatusDoing})
+		if err != nil {
+			return
+		}
+		for _, traintupleKey := range traintupleKeys {
+			var traintuple Traintuple
+			traintuple, err = db.GetTraintuple(traintupleKey)
+			if err != nil {
+				return
+			}
+			if traintuple.StartDate == 0 {
+				continue
+			}
+			var maxDuration int64
+			maxDuration, err = getMaxDuration(db, traintuple.ComputePlanID, traintuple.ObjectiveKey)
+			if err != nil {
+				return
+			}
+			if !isStale(traintuple.StartDate, maxDuration, now) {
+				continue
+			}
+			traintuple.Log += getStaleLog(maxDuration)
+			if err = traintuple.commitStatusUpdate(db, traintupleKey, StatusFailed); err != nil {
+				return
+			}
+			if err = traintuple.updateTesttupleChildren(db, traintupleKey, &event); err != nil {
+				return
+			}
+			if err = traintuple.updateTraintupleChildren(db, traintupleKey, &event); err != nil {
+				return
+			}
+			out.TraintupleKeys = append(out.TraintupleKeys, traintupleKey)
+		}
+	}
+
+	for _, node := range nodes {
+		var testtupleKeys []string
+		testtupleKeys, err = db.GetIndexKeys("testtuple~worker~status~key", []string{"testtuple", node.ID, StatusDoing})
+		if err != nil {
+			return
+		}
+		for _, testtupleKey := range testtupleKeys {
+			var testtuple Testtuple
+			testtuple, err = db.GetTesttuple(testtupleKey)
+			if err != nil {
+				return
+			}
+			if testtuple.StartDate == 0 {
+				continue
+			}
+			var traintuple Traintuple
+			traintuple, err = db.GetTraintuple(testtuple.Model.TraintupleKey)
+			if err != nil {
+				return
+			}
+			var maxDuration int64
+			maxDuration, err = getMaxDuration(db, traintuple.ComputePlanID, testtuple.ObjectiveKey)
+			if err != nil {
+				return
+			}
+			if !isStale(testtuple.StartDate, maxDuration, now) {
+				continue
+			}
+			testtuple.Log += getStaleLog(maxDuration)
+			if err = testtuple.commitStatusUpdate(db, testtupleKey, StatusFailed); err != nil {
+				return
+			}
+			out.TesttupleKeys = append(out.TesttupleKeys, testtupleKey)
+		}
+	}
+
+	err = SendTuplesEvent(db.cc, event)
+	return
+}
+
 // ----------------------------------------------------------
 // Utils for smartcontracts related to  multiple tuple types
 // ----------------------------------------------------------
 
+// tupleCandidate is a todo traintuple or testtuple which can be claimed by a worker
+type tupleCandidate struct {
+	key          string
+	priority     int
+	creationDate int64
+	traintuple   *Traintuple
+	testtuple    *Testtuple
+}
+
+// before checks if the candidate should be claimed before the other one: the
+// highest priority comes first, then the oldest tuple and finally the lowest key
+func (candidate tupleCandidate) before(other tupleCandidate) bool {
+	if candidate.priority != other.priority {
+		return candidate.priority > other.priority
+	}
+	if candidate.creationDate != other.creationDate {
+		return candidate.creationDate < other.creationDate
+	}
+	return candidate.key < other.key
+}
+
+// getMaxDuration returns the maximum duration in seconds a tuple can stay doing,
+// the one of the compute plan takes precedence over the one of the objective.
+// The compute plans started with createTraintuple before they were stored in
+// the ledger have no maximum duration of their own.
+// Zero means there is no limit.
+func getMaxDuration(db LedgerDB, computePlanID, objectiveKey string) (int64, error) {
+	if computePlanID != "" {
+		computePlan, err := db.GetComputePlan(computePlanID)
+		if err != nil && !stderrors.Is(err, errors.NotFound()) {
+			return 0, err
+		}
+		if err == nil && computePlan.MaxDuration > 0 {
+			return computePlan.MaxDuration, nil
+		}
+	}
+	objective, err := db.GetObjective(objectiveKey)
+	if err != nil {
+		return 0, err
+	}
+	return objective.MaxDuration, nil
+}
+
+// isStale checks if a tuple which started at startDate exceeded maxDuration.
+// Tuples without a start date or a maximum duration are never stale.
+func isStale(startDate, maxDuration, now int64) bool {
+	return startDate > 0 && maxDuration > 0 && now-startDate > maxDuration
+}
+
+// getStaleLog returns the log added to a tuple marked as failed by a sweep
+func getStaleLog(maxDuration int64) string {
+	return fmt.Sprintf("exceeded the maximum duration of %d seconds", maxDuration)
+}
+
 // checkLog checks the validity of logs
 func checkLog(log string) (err error) {
 	maxLength := 200
 	if length := len(log); length > maxLength {
-		err = fmt.Errorf("too long log, is %d and should be %d ", length, maxLength)
+		err = errors.BadRequest("too long log, is %d and should be %d ", length, maxLength)
 	}
 	return
 }
@@ -237,7 +331,7 @@ func validateTupleOwner(db LedgerDB, worker string) error {
 		return err
 	}
 	if txCreator != worker {
-		return fmt.Errorf("%s is not allowed to update tuple (%s)", txCreator, worker)
+		return errors.Forbidden("%s is not allowed to update tuple (%s)", txCreator, worker)
 	}
 	return nil
 }
@@ -249,7 +343,7 @@ func checkUpdateTuple(db LedgerDB, worker string, oldStatus string, newStatus st
 		StatusTodo:    StatusDoing,
 		StatusDoing:   StatusDone}
 	if statusPossibilities[oldStatus] != newStatus && newStatus != StatusFailed {
-		return errors.BadRequest("cannot change status from %s to %s", oldStatus, newStatus)
+		return errors.BadRequest(errors.CodeInvalidStatusTransition, "cannot change status from %s to %s", oldStatus, newStatus)
 	}
 	return nil
 }
@@ -18,14 +18,13 @@ import (
 	"encoding/json"
 	"testing"
 
-	"github.com/stretchr/testify/require"
-
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRecursiveLogFailed(t *testing.T) {
 	scc := new(SubstraChaincode)
-	mockStub := NewMockStub("substra", scc)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
 	mockStub.MockTransactionStart("42")
 	registerItem(t, *mockStub, "traintuple")
 	db := NewLedgerDB(mockStub)
@@ -33,22 +32,22 @@ func TestRecursiveLogFailed(t *testing.T) {
 	childtraintuple := inputTraintuple{}
 	childtraintuple.createDefault()
 	childtraintuple.InModels = []string{traintupleKey}
-	childResp, err := createTraintuple(db, assetToArgs(childtraintuple))
+	childResp, err := createTraintuple(db, childtraintuple)
 	assert.NoError(t, err)
 
 	grandChildtraintuple := inputTraintuple{}
 	grandChildtraintuple.createDefault()
 	grandChildtraintuple.InModels = []string{childResp["key"]}
-	grandChildresp, err := createTraintuple(db, assetToArgs(grandChildtraintuple))
+	grandChildresp, err := createTraintuple(db, grandChildtraintuple)
 	assert.NoError(t, err)
 
 	grandChildtesttuple := inputTesttuple{TraintupleKey: traintupleKey}
-	testResp, err := createTesttuple(db, assetToArgs(grandChildtesttuple))
+	testResp, err := createTesttuple(db, grandChildtesttuple)
 	assert.NoError(t, err)
 
-	_, err = logStartTrain(db, assetToArgs(inputHash{Key: traintupleKey}))
+	_, err = logStartTrain(db, inputHash{Key: traintupleKey})
 	assert.NoError(t, err)
-	_, err = logFailTrain(db, assetToArgs(inputHash{Key: traintupleKey}))
+	_, err = logFailTrain(db, inputLogFailTrain{inputLog{Key: traintupleKey}})
 	assert.NoError(t, err)
 
 	train2, err := db.GetTraintuple(grandChildresp["key"])
@@ -60,91 +59,6 @@ func TestRecursiveLogFailed(t *testing.T) {
 	assert.Equal(t, StatusFailed, test.Status)
 }
 
-// myMockStub is here to simulate the fact that in real condition you cannot read
-// what you just write. It should be improved and more generally used.
-type myMockStub struct {
-	saveWhenWriting bool
-	writtenState    map[string][]byte
-	*MockStub
-}
-
-func (stub *myMockStub) PutState(key string, value []byte) error {
-	if !stub.saveWhenWriting {
-		if stub.writtenState == nil {
-			stub.writtenState = make(map[string][]byte)
-		}
-		stub.writtenState[key] = value
-		return nil
-	}
-	return stub.PutState(key, value)
-}
-
-func (stub *myMockStub) saveWrittenState(t *testing.T) {
-	if stub.writtenState == nil {
-		return
-	}
-	for k, v := range stub.writtenState {
-		err := stub.MockStub.PutState(k, v)
-		if err != nil {
-			t.Fatalf("unable to `PutState` in saveWrittenState %s", err)
-		}
-	}
-	stub.writtenState = nil
-	return
-}
-
-func TestCreateComputePlan(t *testing.T) {
-	scc := new(SubstraChaincode)
-	mockStub := NewMockStubWithRegisterNode("substra", scc)
-	myStub := myMockStub{MockStub: mockStub}
-	myStub.saveWhenWriting = true
-	registerItem(t, *mockStub, "algo")
-	myStub.MockTransactionStart("42")
-	myStub.saveWhenWriting = false
-
-	// Simply test method and return values
-	inCP := defaultComputePlan
-	outCP, err := createComputePlan(NewLedgerDB(&myStub), assetToArgs(inCP))
-	assert.NoError(t, err)
-	assert.NotNil(t, outCP)
-	assert.EqualValues(t, outCP.ComputePlanID, outCP.TraintupleKeys[0])
-
-	// Save all that was written in the mocked ledger
-	myStub.saveWrittenState(t)
-
-	// Check the traintuples
-	traintuples, err := queryTraintuples(NewLedgerDB(&myStub), []string{})
-	assert.NoError(t, err)
-	assert.Len(t, traintuples, 2)
-	require.Contains(t, outCP.TraintupleKeys, traintuples[0].Key)
-	require.Contains(t, outCP.TraintupleKeys, traintuples[1].Key)
-	var first, second outputTraintuple
-	for _, el := range traintuples {
-		switch el.Key {
-		case outCP.TraintupleKeys[0]:
-			first = el
-		case outCP.TraintupleKeys[1]:
-			second = el
-		}
-	}
-	assert.NotZero(t, first)
-	assert.NotZero(t, second)
-	assert.EqualValues(t, first.Key, first.ComputePlanID)
-	assert.EqualValues(t, first.ComputePlanID, second.ComputePlanID)
-	assert.Len(t, second.InModels, 1)
-	assert.EqualValues(t, first.Key, second.InModels[0].TraintupleKey)
-	assert.Equal(t, first.Status, StatusTodo)
-	assert.Equal(t, second.Status, StatusWaiting)
-
-	// Check the testtuples
-	testtuples, err := queryTesttuples(NewLedgerDB(&myStub), []string{})
-	assert.NoError(t, err)
-	require.Len(t, testtuples, 1)
-	testtuple := testtuples[0]
-	require.Contains(t, outCP.TesttupleKeys, testtuple.Key)
-	assert.EqualValues(t, second.Key, testtuple.Model.TraintupleKey)
-	assert.True(t, testtuple.Certified)
-}
 func TestSpecifiqArgSeq(t *testing.T) {
 	t.SkipNow()
 	// This test is a POC and a example of a test base on the output of the log
@@ -233,3 +147,152 @@ func TestTagTuple(t *testing.T) {
 	assert.EqualValues(t, tag, testtuples[0].Tag)
 
 }
+
+func TestSweepStaleTuples(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "traintuple")
+
+	inpTesttuple := inputTesttuple{}
+	resp := mockStub.MockInvoke("42", inpTesttuple.createDefault())
+	require.EqualValuesf(t, 200, resp.Status, "when adding a testtuple, status %d and message %s", resp.Status, resp.Message)
+	testtupleKey := map[string]string{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &testtupleKey))
+
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("logStartTrain"), keyToJSON(traintupleKey)})
+	require.EqualValuesf(t, 200, resp.Status, "when starting the traintuple, status %d and message %s", resp.Status, resp.Message)
+	traintuple := outputTraintuple{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &traintuple))
+	assert.NotZero(t, traintuple.StartDate)
+
+	// Without a maximum duration nothing is swept
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("sweepStaleTuples")})
+	require.EqualValuesf(t, 200, resp.Status, "when sweeping the tuples, status %d and message %s", resp.Status, resp.Message)
+	sweep := outputStaleTuples{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &sweep))
+	assert.Empty(t, sweep.TraintupleKeys)
+
+	// Set a maximum duration to the objective and move the start date back in time
+	mockStub.MockTransactionStart("42")
+	db := NewLedgerDB(mockStub)
+	objective, err := db.GetObjective(objectiveDescriptionHash)
+	require.NoError(t, err)
+	objective.MaxDuration = 60
+	require.NoError(t, db.Put(objectiveDescriptionHash, objective))
+	storedTraintuple, err := db.GetTraintuple(traintupleKey)
+	require.NoError(t, err)
+	storedTraintuple.StartDate -= 3600
+	require.NoError(t, db.Put(traintupleKey, storedTraintuple))
+	mockStub.MockTransactionEnd("42")
+
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("sweepStaleTuples")})
+	require.EqualValuesf(t, 200, resp.Status, "when sweeping the tuples, status %d and message %s", resp.Status, resp.Message)
+	require.NoError(t, json.Unmarshal(resp.Payload, &sweep))
+	assert.Equal(t, []string{traintupleKey}, sweep.TraintupleKeys)
+	assert.Empty(t, sweep.TesttupleKeys)
+
+	// the failure is propagated to the testtuple
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("queryTraintuple"), keyToJSON(traintupleKey)})
+	require.NoError(t, json.Unmarshal(resp.Payload, &traintuple))
+	assert.Equal(t, StatusFailed, traintuple.Status)
+	assert.Contains(t, traintuple.Log, "exceeded the maximum duration")
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("queryTesttuple"), keyToJSON(testtupleKey["key"])})
+	testtuple := outputTesttuple{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &testtuple))
+	assert.Equal(t, StatusFailed, testtuple.Status)
+}
+
+func TestSweepStaleTuplesWithoutComputePlan(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "algo")
+
+	// A traintuple starting a compute plan created before the compute plans
+	// were stored in the ledger
+	inpTraintuple := inputTraintuple{Rank: "0"}
+	resp := mockStub.MockInvoke("42", inpTraintuple.createDefault())
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	res := map[string]string{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &res))
+	key := res["key"]
+	mockStub.MockTransactionStart("42")
+	require.NoError(t, mockStub.DelState(getComputePlanKey(key)))
+	mockStub.MockTransactionEnd("42")
+	// and one which is not started yet
+	inpTraintuple = inputTraintuple{InModels: []string{key}}
+	resp = mockStub.MockInvoke("42", inpTraintuple.createDefault())
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("logStartTrain"), keyToJSON(key)})
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("sweepStaleTuples")})
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+
+	// The maximum duration of the objective applies
+	mockStub.MockTransactionStart("42")
+	db := NewLedgerDB(mockStub)
+	objective, err := db.GetObjective(objectiveDescriptionHash)
+	require.NoError(t, err)
+	objective.MaxDuration = 60
+	require.NoError(t, db.Put(objectiveDescriptionHash, objective))
+	traintuple, err := db.GetTraintuple(key)
+	require.NoError(t, err)
+	traintuple.StartDate -= 3600
+	require.NoError(t, db.Put(key, traintuple))
+	mockStub.MockTransactionEnd("42")
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("sweepStaleTuples")})
+	require.EqualValues(t, 200, resp.Status, resp.Message)
+	sweep := outputStaleTuples{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &sweep))
+	assert.Equal(t, []string{key}, sweep.TraintupleKeys)
+}
+
+func TestIsStale(t *testing.T) {
+	assert.False(t, isStale(0, 60, 1000), "a tuple without start date is never stale")
+	assert.False(t, isStale(900, 0, 1000), "a tuple without maximum duration is never stale")
+	assert.False(t, isStale(950, 60, 1000))
+	assert.True(t, isStale(900, 60, 1000))
+}
+
+func TestClaimNextTuple(t *testing.T) {
+	scc := new(SubstraChaincode)
+	mockStub := NewMockStubWithRegisterNode("substra", scc)
+	registerItem(t, *mockStub, "traintuple")
+
+	inpTraintuple := inputTraintuple{DataSampleKeys: []string{trainDataSampleHash2}, Priority: 5}
+	resp := mockStub.MockInvoke("42", inpTraintuple.createDefault())
+	require.EqualValuesf(t, 200, resp.Status, "when adding a traintuple, status %d and message %s", resp.Status, resp.Message)
+	priorityKey := map[string]string{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &priorityKey))
+
+	// The traintuple with the highest priority is claimed first
+	for _, expectedKey := range []string{priorityKey["key"], traintupleKey} {
+		resp = mockStub.MockInvoke("42", [][]byte{[]byte("claimNextTuple")})
+		require.EqualValuesf(t, 200, resp.Status, "when claiming a tuple, status %d and message %s", resp.Status, resp.Message)
+		claimed := outputClaimedTuple{}
+		require.NoError(t, json.Unmarshal(resp.Payload, &claimed))
+		require.NotNil(t, claimed.Traintuple)
+		assert.Nil(t, claimed.Testtuple)
+		assert.Equal(t, expectedKey, claimed.Traintuple.Key)
+		assert.Equal(t, StatusDoing, claimed.Traintuple.Status)
+	}
+
+	// Nothing left to claim
+	resp = mockStub.MockInvoke("42", [][]byte{[]byte("claimNextTuple")})
+	require.EqualValuesf(t, 200, resp.Status, "when claiming a tuple, status %d and message %s", resp.Status, resp.Message)
+	claimed := outputClaimedTuple{}
+	require.NoError(t, json.Unmarshal(resp.Payload, &claimed))
+	assert.Nil(t, claimed.Traintuple)
+	assert.Nil(t, claimed.Testtuple)
+}
+
+func TestTupleCandidateOrder(t *testing.T) {
+	older := tupleCandidate{key: "b", priority: 1, creationDate: 10}
+	newer := tupleCandidate{key: "a", priority: 1, creationDate: 20}
+	urgent := tupleCandidate{key: "c", priority: 2, creationDate: 30}
+	assert.True(t, urgent.before(older), "the highest priority comes first")
+	assert.True(t, older.before(newer), "the oldest tuple comes first")
+	assert.False(t, newer.before(older))
+	sameDate := tupleCandidate{key: "a", priority: 1, creationDate: 10}
+	assert.True(t, sameDate.before(older), "the lowest key comes first")
+}
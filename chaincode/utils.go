@@ -17,8 +17,9 @@ package main
 import (
 	"chaincode/errors"
 	"encoding/json"
-	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
@@ -43,7 +44,7 @@ func inputStructToBytes(v interface{}) (sb [][]byte, err error) {
 	for i := 0; i < e.NumField(); i++ {
 		v := e.Field(i)
 		if v.Type().Name() != "string" {
-			err = fmt.Errorf("struct should contain only string values")
+			err = errors.BadRequest("struct should contain only string values")
 			return
 		}
 		varValue := v.String()
@@ -63,7 +64,7 @@ func checkHashes(hashes []string) (err error) {
 	for _, hash := range hashes {
 		// check validity of dataSampleHashes
 		if len(hash) != 64 {
-			err = fmt.Errorf("invalid hash %s", hash)
+			err = errors.BadRequest("invalid hash %s", hash)
 			return
 		}
 	}
@@ -81,13 +82,52 @@ func AssetFromJSON(args []string, asset interface{}) error {
 		return errors.BadRequest(err, "problem when reading json arg: %s, error is:", arg)
 	}
 	v := validator.New()
+	v.RegisterTagNameFunc(getJSONFieldName)
 	err = v.Struct(asset)
 	if err != nil {
-		return errors.BadRequest(err, "inputs validation failed: %s, error is:", arg)
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if ok {
+			// the entries of the maps are validated in a random order, they
+			// are sorted so that all the peers return the same error
+			sort.SliceStable(validationErrors, func(i, j int) bool {
+				return validationErrors[i].Namespace() < validationErrors[j].Namespace()
+			})
+		}
+		e := errors.BadRequest(err, "inputs validation failed: %s, error is:", arg)
+		if ok {
+			e = e.WithFieldErrors(getFieldErrors(validationErrors))
+		}
+		return e
 	}
 	return nil
 }
 
+// getJSONFieldName returns the name of a field in the json inputs so that
+// validation errors refer to the fields as the users know them
+func getJSONFieldName(field reflect.StructField) string {
+	name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// getFieldErrors converts validation errors to the details of each invalid
+// field, named by its path in the json input
+func getFieldErrors(validationErrors validator.ValidationErrors) []errors.FieldError {
+	fields := []errors.FieldError{}
+	for _, fieldError := range validationErrors {
+		// Remove the name of the input struct from the path
+		path := strings.SplitN(fieldError.Namespace(), ".", 2)
+		fields = append(fields, errors.FieldError{
+			Field: path[len(path)-1],
+			Rule:  fieldError.Tag(),
+			Param: fieldError.Param(),
+		})
+	}
+	return fields
+}
+
 // SendTuplesEvent sends an event with updated traintuples and testtuples
 // Only one event can be sent per transaction
 func SendTuplesEvent(stub shim.ChaincodeStubInterface, event interface{}) error {
@@ -102,6 +142,29 @@ func SendTuplesEvent(stub shim.ChaincodeStubInterface, event interface{}) error
 	return nil
 }
 
+// SendDataSamplesRevokedEvent sends an event with revoked data samples
+// Only one event can be sent per transaction
+func SendDataSamplesRevokedEvent(stub shim.ChaincodeStubInterface, event interface{}) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	err = stub.SetEvent("data-samples-revoked", payload)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetTxTimestamp returns the transaction timestamp in seconds since the epoch
+func GetTxTimestamp(stub shim.ChaincodeStubInterface) (int64, error) {
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return 0, err
+	}
+	return txTimestamp.GetSeconds(), nil
+}
+
 // GetTxCreator returns the transaction creator
 func GetTxCreator(stub shim.ChaincodeStubInterface) (string, error) {
 	creator, err := stub.GetCreator()